@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/fakeserver"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/synthetic"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultAddr is the default address the fake feed listens on.
+	DefaultAddr = "127.0.0.1:8080"
+	// DefaultSeed is the default seed of the synthetic matches.
+	DefaultSeed = 1
+	// DefaultPrices is the default initial prices of the synthetic matches.
+	DefaultPrices = "BTC-USD=40000,ETH-USD=3000,ETH-BTC=0.075"
+)
+
+func main() {
+	var (
+		addr            = flag.String("addr", DefaultAddr, "address to listen on")
+		fixtures        = flag.String("fixtures", "", "json or json lines file of the matches to replay")
+		seed            = flag.Int64("seed", DefaultSeed, "seed of the synthetic matches")
+		prices          = flag.String("prices", DefaultPrices, "comma separated product=price initial synthetic prices")
+		interval        = flag.Duration("interval", fakeserver.DefaultInterval, "interval between the matches")
+		malformedEvery  = flag.Int("malformed-every", 0, "send every nth match malformed, 0 disables it")
+		disconnectAfter = flag.Int("disconnect-after", 0, "drop a connection after n matches, 0 disables it")
+		subscribeError  = flag.String("subscribe-error", "", "fail every subscribe request with the reason")
+		reject          = flag.String("reject", "", "comma separated list of products whose subscriptions fail")
+		scenario        = flag.String("scenario", "", "json file of the synthetic scenario to generate the matches from")
+		write           = flag.String("write", "", "write the scenario matches to the json lines file for replaying and exit")
+		duration        = flag.Duration("duration", time.Minute, "duration of the scenario written by -write")
+		verbose         = flag.Bool("verbose", false, "verbose logging")
+	)
+
+	flag.Parse()
+
+	logger := logrus.New()
+	if *verbose {
+		logger.SetLevel(logrus.TraceLevel)
+	}
+
+	var source fakeserver.Source
+
+	if *scenario != "" {
+		generator, err := newGenerator(*scenario)
+		if err != nil {
+			logger.Fatalf("failed to load scenario: %v", err)
+		}
+
+		if *write != "" {
+			err = writeScenario(generator, *write, *duration)
+			if err != nil {
+				logger.Fatalf("failed to write scenario: %v", err)
+			}
+
+			logger.Infof("Wrote %s of the scenario to %s", *duration, *write)
+
+			return
+		}
+
+		source = generator
+	} else if *fixtures != "" {
+		matches, err := fakeserver.LoadMatches(*fixtures)
+		if err != nil {
+			logger.Fatalf("failed to load fixtures: %v", err)
+		}
+
+		source = fakeserver.NewFixtureSource(matches)
+	} else {
+		initialPrices, err := parsePrices(*prices)
+		if err != nil {
+			logger.Fatalf("failed to parse prices: %v", err)
+		}
+
+		source, err = synthetic.NewGenerator(synthetic.NewPriceConfig(*seed, initialPrices))
+		if err != nil {
+			logger.Fatalf("failed to generate prices: %v", err)
+		}
+	}
+
+	config := fakeserver.NewConfig()
+	config.Interval = *interval
+	config.MalformedEvery = *malformedEvery
+	config.DisconnectAfter = *disconnectAfter
+	config.SubscribeError = *subscribeError
+
+	if *reject != "" {
+		config.RejectedProducts = strings.Split(*reject, ",")
+	}
+
+	server := fakeserver.NewServer(source, config)
+	server.SetLogger(logger)
+
+	httpServer := &http.Server{Addr: *addr, Handler: server}
+
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+
+		logger.Infoln("Interrupt key signal received, stopping...")
+		_ = httpServer.Close()
+		server.Close()
+	}()
+
+	logger.Infof("Serving the fake coinbase feed on ws://%s", *addr)
+
+	err := httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		logger.Fatalf("failed to serve: %v", err)
+	}
+}
+
+func newGenerator(path string) (*synthetic.Generator, error) {
+	config, err := synthetic.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return synthetic.NewGenerator(config)
+}
+
+// writeScenario writes the matches generated over the duration of the scenario, in the format of the replay streamer.
+func writeScenario(generator *synthetic.Generator, path string, duration time.Duration) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = synthetic.WriteFeeds(f, generator.Generate(duration))
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// parsePrices parses the initial prices given as product=price pairs.
+func parsePrices(value string) (map[string]float64, error) {
+	prices := make(map[string]float64)
+
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		productID, price, _ := strings.Cut(pair, "=")
+
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(price), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		prices[strings.TrimSpace(productID)] = parsed
+	}
+
+	return prices, nil
+}
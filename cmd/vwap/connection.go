@@ -0,0 +1,110 @@
+package main
+
+import (
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+// newTLSOptions builds the TLS options of the websocket connections from the flags.
+func newTLSOptions(caFile string, certFile string, keyFile string, serverName string, minVersion string) (
+	wsclient.TLSOptions,
+	error,
+) {
+	options := wsclient.TLSOptions{
+		CAFile:     caFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: serverName,
+	}
+
+	if minVersion != "" {
+		version, err := wsclient.ParseTLSVersion(minVersion)
+		if err != nil {
+			return options, err
+		}
+
+		options.MinVersion = version
+	}
+
+	// Load the files once up front, so that a bad file fails on start rather than on every connect.
+	_, err := options.Config()
+
+	return options, err
+}
+
+// connectionConfig is the configuration of the websocket connections set by the flags.
+type connectionConfig struct {
+	tls       wsclient.TLSOptions
+	endpoints []string
+	sendRate  float64
+	sendBurst int
+	sendQueue int
+	faults    *wsclient.FaultConfig
+}
+
+// configureClients configures the websocket clients of the streamer, including the clients of the shards the sharded
+// streamer adds later, and logs their state transitions. The replay streamer has none.
+func configureClients(streamer streaming.Streamer, config connectionConfig, logger *logrus.Logger) error {
+	// The connections of a streamer share the rate limiter, since the exchange limits the messages per IP.
+	var rateLimiter *wsclient.RateLimiter
+	if config.sendRate > 0 {
+		rateLimiter = wsclient.NewRateLimiter(config.sendRate, config.sendBurst, config.sendQueue)
+	}
+
+	configure := func(index int, client *wsclient.Client) error {
+		client.ConnectionOptions.TLS = config.tls
+		client.RateLimiter = rateLimiter
+		if len(config.endpoints) > 0 {
+			client.Endpoints = config.endpoints
+		}
+
+		if config.faults != nil {
+			// Every connection has its own fault injector seeded by the config seed plus the connection index, so
+			// that the faults are reproducible per connection.
+			faultConfig := *config.faults
+			faultConfig.Seed += int64(index)
+
+			faults, err := wsclient.NewFaultInjector(faultConfig)
+			if err != nil {
+				return err
+			}
+
+			client.Faults = faults
+		}
+
+		client.SubscribeState(func(change wsclient.StateChange) {
+			logConnectionState(change, logger)
+		})
+
+		return nil
+	}
+
+	if sharded, ok := streamer.(interface {
+		ConfigureClients(configure func(index int, client *wsclient.Client) error) error
+	}); ok {
+		return sharded.ConfigureClients(configure)
+	}
+
+	client := streamer.GetClient()
+	if client == nil {
+		return nil
+	}
+
+	return configure(0, client)
+}
+
+// logConnectionState logs a connection state transition.
+func logConnectionState(change wsclient.StateChange, logger *logrus.Logger) {
+	if change.Err != nil {
+		logger.Warnf("Connection %s -> %s: %s", change.From, change.To, change.Err)
+		return
+	}
+
+	if change.To == wsclient.StateConnected {
+		logger.Infof("Connection %s -> %s to %s", change.From, change.To, change.Endpoint)
+		return
+	}
+
+	logger.Debugf("Connection %s -> %s", change.From, change.To)
+}
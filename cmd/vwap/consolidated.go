@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/binance"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/advanced"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/consolidated"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/kraken"
+	"github.com/sirupsen/logrus"
+)
+
+// singleFeedFlags are the flags of the single feed pipeline, the consolidated pipeline doesn't support them.
+var singleFeedFlags = []string{
+	"feed",
+	"wsurl",
+	"wsurl-fallbacks",
+	"connections",
+	"pairs-per-connection",
+	"replay",
+	"record-dir",
+	"cross-rates",
+	"report-currency",
+	"fx-rates",
+	"latency-interval",
+}
+
+// checkConsolidateFlags returns an error for the single feed flags set along with the consolidation, and for the pair
+// patterns and quote selection, which are only resolved against the Coinbase products list.
+func checkConsolidateFlags(productIds []string, excludePairs string, quotes string) error {
+	for _, name := range singleFeedFlags {
+		if isFlagSet(name) {
+			return fmt.Errorf("%s isn't supported with consolidate", name)
+		}
+	}
+
+	if needsProductResolution(productIds, excludePairs, quotes) {
+		return errors.New("pair patterns and quote selection aren't supported with consolidate")
+	}
+
+	return nil
+}
+
+// feedVenue returns the venue of a feed. The exchange and the advanced feeds are both the Coinbase venue, whose
+// sliding windows and trade ids they would share, so only one of them can be consolidated.
+func feedVenue(feed string) string {
+	switch feed {
+	case FeedExchange, FeedAdvanced:
+		return instrument.VenueCoinbase
+	default:
+		return feed
+	}
+}
+
+// venueProductIds converts the canonical pairs into the venue's symbols configured in the registry, the pairs
+// without a configured symbol are left to the streamer to convert.
+func venueProductIds(registry *instrument.Registry, venue string, productIds []string) []string {
+	venueIds := make([]string, 0, len(productIds))
+
+	for _, productID := range productIds {
+		inst, err := instrument.Parse(productID)
+		if err == nil {
+			if symbol := registry.Symbol(venue, inst); symbol != "" {
+				productID = symbol
+			}
+		}
+
+		venueIds = append(venueIds, productID)
+	}
+
+	return venueIds
+}
+
+// newFeedStreamer creates the streamer of a feed connected to the feed's default url.
+func newFeedStreamer(
+	ctx context.Context,
+	feed string,
+	productIds []string,
+	binanceStream string,
+	logger *logrus.Logger,
+) (streaming.Streamer, error) {
+	var streamer streaming.Streamer
+
+	switch feed {
+	case FeedExchange:
+		request, err := json.Marshal(coinbase.NewMatchesRequest(coinbase.RequestTypeSubscribe, productIds))
+		if err != nil {
+			return nil, err
+		}
+
+		streamer = coinbase.NewStreamer(ctx, DefaultWebSocketURL, string(request))
+	case FeedAdvanced:
+		streamer = advanced.NewStreamer(ctx, DefaultAdvancedWebSocketURL, productIds)
+	case FeedBinance:
+		streamer = binance.NewStreamer(ctx, DefaultBinanceWebSocketURL, productIds, binanceStream)
+	case FeedKraken:
+		streamer = kraken.NewStreamer(ctx, DefaultKrakenWebSocketURL, productIds)
+	default:
+		return nil, fmt.Errorf("unknown feed %s", feed)
+	}
+
+	streamer.SetLogger(logger)
+
+	return streamer, nil
+}
+
+// newConsolidatedHandler creates the handler consolidating the feeds of all the given venues.
+func newConsolidatedHandler(
+	ctx context.Context,
+	feeds []string,
+	productIds []string,
+	binanceStream string,
+	windowSize int,
+	registry *instrument.Registry,
+	weights map[string]float64,
+	excluded []string,
+	logger *logrus.Logger,
+) (*consolidated.Handler, error) {
+	consolidatedHandler := consolidated.NewHandler(windowSize)
+	consolidatedHandler.SetLogger(logger)
+	consolidatedHandler.SetRegistry(registry)
+
+	for venue, weight := range weights {
+		consolidatedHandler.SetWeight(venue, weight)
+	}
+
+	for _, venue := range excluded {
+		consolidatedHandler.Exclude(venue)
+	}
+
+	venueFeeds := make(map[string]string)
+
+	for _, feed := range feeds {
+		venue := feedVenue(feed)
+		if other, ok := venueFeeds[venue]; ok {
+			return nil, fmt.Errorf("feeds %s and %s are both the %s venue, consolidate only one of them", other, feed, venue)
+		}
+
+		venueFeeds[venue] = feed
+
+		streamer, err := newFeedStreamer(
+			ctx,
+			feed,
+			venueProductIds(registry, venue, productIds),
+			binanceStream,
+			logger,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		consolidatedHandler.AddStreamer(streamer)
+	}
+
+	return consolidatedHandler, nil
+}
+
+// parseWeights parses the venue weights given as venue=weight pairs, e.g. binance=0.5,kraken=1. The weights must be
+// finite and at least 0.
+func parseWeights(weights string) (map[string]float64, error) {
+	parsed := make(map[string]float64)
+
+	for _, pair := range splitList(weights) {
+		venue, weight, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid venue weight %q, want venue=weight", pair)
+		}
+
+		value, err := strconv.ParseFloat(weight, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid venue weight %q: %w", pair, err)
+		}
+
+		// A negative or infinite weight would drop the venue or make the consolidated vwap NaN.
+		if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("invalid venue weight %q, want a finite weight of at least 0", pair)
+		}
+
+		parsed[strings.ToLower(strings.TrimSpace(venue))] = value
+	}
+
+	return parsed, nil
+}
+
+// indexSnapshotFunc prints each consolidated snapshot with the composite index price published from it.
+func indexSnapshotFunc(index *consolidated.Index, logger *logrus.Logger) func(s consolidated.Snapshot) error {
+	return func(s consolidated.Snapshot) error {
+		value, err := index.Publish(s)
+		if errors.Is(err, consolidated.ErrNotEnoughVenues) {
+			logger.Debugf("Skipping %s index %s", s.Instrument, err)
+			fmt.Printf("%v\n", s)
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf(
+			"%v\tindex:%s[%s]\n",
+			s,
+			value.Price.Text('f', 8),
+			strings.Join(value.IncludedVenues(), ","),
+		)
+
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap/conversion"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap/crossrate"
+)
+
+// crossRatePipelineFunc updates the cross rate engine with each window's VWAP, and prints the deviations of the
+// window's VWAP from its implied rates, once per triangle the window's pair is part of.
+func crossRatePipelineFunc(engine *crossrate.Engine) func(s *vwap.SlidingWindow) error {
+	return func(s *vwap.SlidingWindow) error {
+		inst, err := instrument.Parse(s.CurrencyPair())
+		if err != nil {
+			return err
+		}
+
+		for _, deviation := range engine.Update(inst, s.GetCalculator().Avg()) {
+			fmt.Printf(
+				"Cross Rate: %v\tdirect:%v\timplied:%v\tdeviation:%.2fbps\n",
+				deviation.Triangle,
+				deviation.Direct.Text('f', 8),
+				deviation.Implied.Text('f', 8),
+				deviation.BasisPoints,
+			)
+		}
+
+		return nil
+	}
+}
+
+// conversionPipelineFunc updates the converter with each window's VWAP as a live rate, and prints the window's VWAP
+// and notional volume in the reporting currency with the conversion path used.
+func conversionPipelineFunc(converter *conversion.Converter) func(s *vwap.SlidingWindow) error {
+	return func(s *vwap.SlidingWindow) error {
+		inst, err := instrument.Parse(s.CurrencyPair())
+		if err != nil {
+			return err
+		}
+
+		converter.UpdateLive(inst, s.GetCalculator().Avg())
+
+		converted, err := converter.ConvertWindow(s)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf(
+			"Converted: %v\tvwap:%v %v\tnotional:%v %v\tpath:%v\n",
+			converted.Instrument,
+			converted.VWAP.Text('f', 8),
+			converted.Currency,
+			converted.Notional.Text('f', 2),
+			converted.Currency,
+			converted.Path,
+		)
+
+		return nil
+	}
+}
+
+// chainPipelineFuncs chains the pipeline functions, returning the first error.
+func chainPipelineFuncs(funcs ...func(s *vwap.SlidingWindow) error) func(s *vwap.SlidingWindow) error {
+	return func(s *vwap.SlidingWindow) error {
+		for _, f := range funcs {
+			if err := f(s); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
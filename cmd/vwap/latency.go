@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/handler"
+)
+
+// reportLatencies prints the rolling latency percentiles of every product on every interval, until the context is
+// done. They're printed rather than logged, since the logger only logs the fatal errors unless verbose.
+func reportLatencies(ctx context.Context, interval time.Duration, vwapHandler *handler.CoinbaseSteamDataHandler) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, latency := range vwapHandler.Latencies() {
+				fmt.Printf(
+					"Latency: %s\texchange p50:%v p99:%v\tupdate p50:%v p99:%v\tdelivery p50:%v p99:%v\tsamples:%d\n",
+					latency.ProductID,
+					latency.Exchange.P50,
+					latency.Exchange.P99,
+					latency.Update.P50,
+					latency.Update.P99,
+					latency.Delivery.P50,
+					latency.Delivery.P99,
+					latency.Update.Count,
+				)
+			}
+		}
+	}
+}
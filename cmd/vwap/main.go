@@ -7,30 +7,107 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/binance"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/advanced"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/handler"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/replay"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/consolidated"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/kraken"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/recorder"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap/conversion"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap/crossrate"
 	"github.com/sirupsen/logrus"
 )
 
 const (
 	// DefaultPort is the default websocket URL to subscribe to.
 	DefaultWebSocketURL = "wss://ws-feed.exchange.coinbase.com"
+	// DefaultAdvancedWebSocketURL is the default websocket URL of the Coinbase Advanced Trade API.
+	DefaultAdvancedWebSocketURL = advanced.DefaultWebSocketURL
+	// DefaultBinanceWebSocketURL is the default websocket URL of the Binance streams.
+	DefaultBinanceWebSocketURL = binance.DefaultWebSocketURL
+	// DefaultKrakenWebSocketURL is the default websocket URL of the Kraken v2 API.
+	DefaultKrakenWebSocketURL = kraken.DefaultWebSocketURL
+	// DefaultRestURL is the default REST API URL to fetch the trade history from.
+	DefaultRestURL = coinbase.DefaultRestURL
 	// DefaultLogLevel is the default log level set for logrus.
 	DefaultLogLevel = logrus.FatalLevel
 	// DefaultPairs is the default list of pairs to get the vwap for.
 	DefaultPairs = "BTC-USD,ETH-USD,ETH-BTC"
 	// DefaultVwapWindowSize is the default window size for the vwap calculation.
 	DefaultVwapWindowSize = 200
+	// FeedExchange and FeedAdvanced are the supported Coinbase websocket APIs, FeedBinance and FeedKraken are the
+	// trade streams of the other exchanges.
+	FeedExchange = "exchange"
+	FeedAdvanced = "advanced"
+	FeedBinance  = "binance"
+	FeedKraken   = "kraken"
+	// DefaultConnections is the default number of websocket connections the pairs are spread over.
+	DefaultConnections = 1
+	// DefaultResolveInterval is the default interval of re-resolving the product patterns.
+	DefaultResolveInterval = 10 * time.Minute
+	// DefaultShutdownTimeout is the default time the pipeline is given to stop on an interrupt.
+	DefaultShutdownTimeout = 10 * time.Second
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the vwap streaming until it's interrupted or fails, and returns the exit code of the process.
+func run() int {
 	var (
-		queryPairs     = flag.String("pairs", DefaultPairs, "comma separated list of pairs to query")
-		verbose        = flag.Bool("verbose", false, "verbose logging")
-		wsURL          = flag.String("wsurl", DefaultWebSocketURL, "websocket url")
-		vwapWindowSize = flag.Int("window-size", DefaultVwapWindowSize, "vwap window size")
+		queryPairs      = flag.String("pairs", DefaultPairs, "comma separated list of pairs or pair patterns to query")
+		excludePairs    = flag.String("exclude", "", "comma separated list of pairs or pair patterns to exclude")
+		quotes          = flag.String("quote", "", "comma separated list of quote currencies to select the pairs by")
+		productsFile    = flag.String("products-file", "", "products list file used as the cache of the products api")
+		resolveInterval = flag.Duration("resolve-interval", DefaultResolveInterval, "interval of re-resolving patterns")
+		verbose         = flag.Bool("verbose", false, "verbose logging")
+		wsURL           = flag.String("wsurl", DefaultWebSocketURL, "websocket url")
+		feed            = flag.String("feed", FeedExchange, "websocket api: exchange, advanced, binance or kraken")
+		binanceStream   = flag.String("binance-stream", binance.StreamTypeTrade, "binance stream: trade or aggTrade")
+		vwapWindowSize  = flag.Int("window-size", DefaultVwapWindowSize, "vwap window size")
+		restURL         = flag.String("resturl", DefaultRestURL, "rest api url for the trade history backfill")
+		backfill        = flag.Bool("backfill", true, "prefill the vwap windows from the trade history on start")
+		connections     = flag.Int("connections", DefaultConnections, "number of websocket connections")
+		pairsPerConn    = flag.Int("pairs-per-connection", 0, "max number of pairs per connection, 0 for no limit")
+		crossRates      = flag.Bool("cross-rates", false, "publish the implied cross rates and their deviations in bps")
+		reportCurrency  = flag.String("report-currency", "", "currency the vwap and notional volume are also reported in")
+		fxRates         = flag.String("fx-rates", "", "json file of the static fx rates used for the conversion")
+		replayFile      = flag.String("replay", "", "recorded file, glob or record dir of coinbase messages to replay")
+		replaySpeed     = flag.Float64("replay-speed", replay.SpeedRealtime, "replay speed factor, 0 for fastest")
+		recordDir       = flag.String("record-dir", "", "directory the raw coinbase messages are recorded to")
+		recordMaxSize   = flag.Int64("record-max-size", recorder.DefaultMaxBytes, "compressed size a record is rotated at")
+		recordMaxAge    = flag.Duration("record-max-age", recorder.DefaultMaxAge, "age a record file is rotated at")
+		instruments     = flag.String("instruments", "", "json file of the instrument symbol mappings and aliases")
+		consolidate     = flag.String("consolidate", "", "comma separated list of feeds to consolidate the vwap over")
+		venueWeights    = flag.String("venue-weights", "", "comma separated venue=weight list of the consolidated vwap")
+		excludeVenues   = flag.String("exclude-venues", "", "comma separated list of venues excluded from consolidation")
+		index           = flag.Bool("index", false, "publish the composite index price of the consolidated pairs")
+		maxDeviation    = flag.Float64("index-max-deviation", consolidated.DefaultMaxDeviation, "max deviation fraction")
+		maxStaleness    = flag.Duration("index-max-staleness", consolidated.DefaultMaxStaleness, "max venue staleness")
+		minVenues       = flag.Int("index-min-venues", consolidated.DefaultMinVenues, "min venues of an index price")
+		indexAudit      = flag.String("index-audit", "", "json lines file of the index price audit trail")
+		wsURLFallbacks  = flag.String("wsurl-fallbacks", "", "comma separated websocket urls to fail over, by priority")
+		sendRate        = flag.Float64("send-rate", 0, "max outbound websocket messages per second, 0 for no limit")
+		sendBurst       = flag.Int("send-burst", 1, "max burst of outbound websocket messages")
+		sendQueue       = flag.Int("send-queue", 0, "max queued outbound websocket messages, 0 for no limit")
+		latencyInterval = flag.Duration("latency-interval", 0, "interval of printing the latency percentiles, 0 disables")
+		latencyWindow   = flag.Int("latency-window", handler.DefaultLatencyWindow, "samples of the latency percentiles")
+		faultsFile      = flag.String("faults", "", "json file of the faults injected into the websocket connections")
+		shutdownTimeout = flag.Duration("shutdown-timeout", DefaultShutdownTimeout, "time to stop within on interrupt")
+		tlsCA           = flag.String("tls-ca", "", "pem file of the ca certificates the server is verified against")
+		tlsCert         = flag.String("tls-cert", "", "pem file of the client certificate for mutual tls")
+		tlsKey          = flag.String("tls-key", "", "pem file of the client certificate's key for mutual tls")
+		tlsServerName   = flag.String("tls-server-name", "", "server name sent as sni and verified, the url host by default")
+		tlsMinVersion   = flag.String("tls-min-version", "1.2", "minimum tls version: 1.2 or 1.3")
 	)
 
 	flag.Parse()
@@ -45,54 +122,352 @@ func main() {
 		logger.SetLevel(logrus.TraceLevel)
 	}
 
-	productIds := strings.Split(*queryPairs, ",")
+	ctx := context.Background()
+
+	productIds := splitList(*queryPairs)
 
-	// Build the request to subscribe to the coinbase websocket feed.
-	subscribeReq := coinbase.SubscribeRequest{
-		Type:       "subscribe",
-		ProductIds: productIds,
-		Channels: []coinbase.Channel{
-			{
-				Name:       "matches",
-				ProductIds: productIds,
-			},
-		},
+	registry := instrument.NewRegistry()
+	if *instruments != "" {
+		var err error
+
+		registry, err = instrument.LoadRegistry(*instruments)
+		if err != nil {
+			return fail(logger, "Error loading instruments %s", err)
+		}
 	}
 
-	request, err := json.Marshal(subscribeReq)
+	tlsOptions, err := newTLSOptions(*tlsCA, *tlsCert, *tlsKey, *tlsServerName, *tlsMinVersion)
 	if err != nil {
-		logger.Errorf("failed to marshal subscribe request: %v", err)
+		return fail(logger, "failed to load tls options: %v", err)
 	}
 
-	ctx := context.Background()
+	// Inject the faults into the connections, for testing the reconnects.
+	var faultConfig *wsclient.FaultConfig
+	if *faultsFile != "" {
+		config, err := wsclient.LoadFaultConfig(*faultsFile)
+		if err != nil {
+			return fail(logger, "failed to load fault config: %v", err)
+		}
+
+		logger.Warnf("Injecting the faults of %s into the websocket connections", *faultsFile)
+		faultConfig = &config
+	}
+
+	connConfig := connectionConfig{
+		tls:       tlsOptions,
+		sendRate:  *sendRate,
+		sendBurst: *sendBurst,
+		sendQueue: *sendQueue,
+		faults:    faultConfig,
+	}
+
+	// Consolidate the vwap of the pairs over the trades of several venues.
+	if *consolidate != "" {
+		err := checkConsolidateFlags(productIds, *excludePairs, *quotes)
+		if err != nil {
+			return fail(logger, "invalid consolidate flags: %v", err)
+		}
+
+		weights, err := parseWeights(*venueWeights)
+		if err != nil {
+			return fail(logger, "failed to parse venue weights: %v", err)
+		}
+
+		consolidatedHandler, err := newConsolidatedHandler(
+			ctx,
+			splitList(*consolidate),
+			productIds,
+			*binanceStream,
+			*vwapWindowSize,
+			registry,
+			weights,
+			splitList(*excludeVenues),
+			logger,
+		)
+		if err != nil {
+			return fail(logger, "failed to create consolidated handler: %v", err)
+		}
+
+		for _, venueStreamer := range consolidatedHandler.GetStreamers() {
+			if err := configureClients(venueStreamer, connConfig, logger); err != nil {
+				return fail(logger, "failed to configure the connections: %v", err)
+			}
+		}
+
+		if *index {
+			compositeIndex := consolidated.NewIndex(consolidated.IndexConfig{
+				MaxDeviation: *maxDeviation,
+				MaxStaleness: *maxStaleness,
+				MinVenues:    *minVenues,
+			})
+
+			if *indexAudit != "" {
+				auditFile, err := os.OpenFile(*indexAudit, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+				if err != nil {
+					return fail(logger, "failed to open index audit file: %v", err)
+				}
+				defer auditFile.Close()
+
+				compositeIndex.SetAuditLog(consolidated.NewJSONAuditLog(auditFile))
+			}
+
+			consolidatedHandler.SnapshotFunc = indexSnapshotFunc(compositeIndex, logger)
+		}
+
+		logger.Infof("Consolidating %d pairs over the %s feeds", len(productIds), *consolidate)
+
+		pipeline := streaming.NewPipeline(consolidatedHandler)
+		pipeline.SetLogger(logger)
+
+		err = pipeline.Start(ctx)
+		if err != nil {
+			return fail(logger, "failed to handle stream data: %v", err)
+		}
+
+		return waitPipeline(pipeline, interrupt, *shutdownTimeout, logger)
+	}
+
+	// Resolve the pair patterns and the quote currencies against the products list.
+	var resolver *productResolver
+
+	// The product list and the trade history are only available for the Coinbase feeds.
+	isCoinbaseFeed := (*feed == FeedExchange || *feed == FeedAdvanced) && *replayFile == ""
+
+	if needsProductResolution(productIds, *excludePairs, *quotes) {
+		if !isCoinbaseFeed {
+			return fail(logger, "pair patterns and quote selection are only supported for the coinbase feeds")
+		}
+
+		selector := coinbase.ProductSelector{
+			Include: productIds,
+			Exclude: splitList(*excludePairs),
+			Quotes:  splitList(*quotes),
+		}
+
+		// Select all the pairs of the quote currencies unless the pairs are explicitly given.
+		if !isFlagSet("pairs") && len(selector.Quotes) > 0 {
+			selector.Include = nil
+		}
+
+		catalog := coinbase.NewProductCatalog(ctx, *restURL)
+		catalog.SetLogger(logger)
+		catalog.SetCacheFile(*productsFile)
+
+		resolver = &productResolver{lister: catalog, selector: selector, logger: logger}
+
+		var err error
+
+		productIds, err = resolver.resolve()
+		if err != nil {
+			return fail(logger, "failed to resolve pairs: %v", err)
+		}
+	}
+
+	// Only the Coinbase exchange feed is spread over multiple connections.
+	if (*connections > 1 || *pairsPerConn > 0) && (*feed != FeedExchange || *replayFile != "") {
+		return fail(logger, "connections and pairs-per-connection are only supported for the coinbase exchange feed")
+	}
+
+	var (
+		streamer   streaming.Streamer
+		subscriber productSubscriber
+	)
+
+	switch {
+	case *replayFile != "":
+		// Replay the recorded messages instead of streaming them.
+		replayStreamer := replay.NewStreamer(ctx, *replayFile, *replaySpeed)
+		replayStreamer.SetLogger(logger)
+
+		streamer = replayStreamer
+	case *feed == FeedAdvanced:
+		// Use the advanced trade API default url unless the url is explicitly given.
+		if !isFlagSet("wsurl") {
+			*wsURL = DefaultAdvancedWebSocketURL
+		}
 
-	// Create a new websocket streaming client.
-	streamer := coinbase.NewStreamer(ctx, *wsURL, string(request))
-	streamer.SetLogger(logger)
+		advancedStreamer := advanced.NewStreamer(ctx, *wsURL, productIds)
+		advancedStreamer.SetLogger(logger)
 
-	var streamHandler streaming.StreamDataHandler
+		streamer, subscriber = advancedStreamer, advancedStreamer
+	case *feed == FeedBinance:
+		if !isFlagSet("wsurl") {
+			*wsURL = DefaultBinanceWebSocketURL
+		}
+
+		binanceStreamer := binance.NewStreamer(ctx, *wsURL, productIds, *binanceStream)
+		binanceStreamer.SetLogger(logger)
+
+		streamer, subscriber = binanceStreamer, binanceStreamer
+	case *feed == FeedKraken:
+		if !isFlagSet("wsurl") {
+			*wsURL = DefaultKrakenWebSocketURL
+		}
+
+		krakenStreamer := kraken.NewStreamer(ctx, *wsURL, productIds)
+		krakenStreamer.SetLogger(logger)
+
+		streamer, subscriber = krakenStreamer, krakenStreamer
+	case *feed != FeedExchange:
+		return fail(
+			logger,
+			"unknown feed %s, want %s, %s, %s or %s",
+			*feed,
+			FeedExchange,
+			FeedAdvanced,
+			FeedBinance,
+			FeedKraken,
+		)
+	case *connections > 1 || *pairsPerConn > 0:
+		// Spread the pairs over multiple websocket connections.
+		shardedStreamer := coinbase.NewShardedStreamer(ctx, *wsURL, productIds, *connections, *pairsPerConn)
+		shardedStreamer.SetLogger(logger)
+		logger.Infof("Spreading pairs over %d connections", len(shardedStreamer.GetShardProducts()))
+
+		streamer, subscriber = shardedStreamer, shardedStreamer
+	default:
+		// Build the request to subscribe to the coinbase websocket feed.
+		subscribeReq := coinbase.NewMatchesRequest(coinbase.RequestTypeSubscribe, productIds)
+
+		request, err := json.Marshal(subscribeReq)
+		if err != nil {
+			logger.Errorf("failed to marshal subscribe request: %v", err)
+		}
+
+		// Create a new websocket streaming client.
+		coinbaseStreamer := coinbase.NewStreamer(ctx, *wsURL, string(request))
+		coinbaseStreamer.SetLogger(logger)
+
+		streamer, subscriber = coinbaseStreamer, coinbaseStreamer
+	}
+
+	if *wsURLFallbacks != "" {
+		connConfig.endpoints = append([]string{*wsURL}, splitList(*wsURLFallbacks)...)
+	}
+	if err := configureClients(streamer, connConfig, logger); err != nil {
+		return fail(logger, "failed to configure the connections: %v", err)
+	}
 
 	// Create a new vwap data handler.
-	streamHandler = handler.NewStreamDataHandler(*vwapWindowSize, productIds)
-	streamHandler.SetLogger(logger)
-	streamHandler.SetStreamer(streamer)
+	vwapHandler := handler.NewStreamDataHandler(*vwapWindowSize, productIds)
+	if *backfill && isCoinbaseFeed {
+		tradeHistory := coinbase.NewTradeHistory(ctx, *restURL)
+		tradeHistory.SetLogger(logger)
+		vwapHandler.SetTradeHistoryFetcher(tradeHistory)
+	}
+
+	vwapHandler.SetRegistry(registry)
+	vwapHandler.SetLatencyTracker(handler.NewLatencyTracker(*latencyWindow))
+
+	pipelineFuncs := make([]func(s *vwap.SlidingWindow) error, 0)
+
+	if *crossRates {
+		pipelineFuncs = append(pipelineFuncs, crossRatePipelineFunc(crossrate.NewEngine()))
+	}
+
+	if *reportCurrency != "" {
+		converter := conversion.NewConverter(*reportCurrency)
+		if *fxRates != "" {
+			err := converter.LoadStaticRates(*fxRates)
+			if err != nil {
+				return fail(logger, "failed to load fx rates: %v", err)
+			}
+		}
+
+		pipelineFuncs = append(pipelineFuncs, conversionPipelineFunc(converter))
+	}
+
+	if len(pipelineFuncs) > 0 {
+		vwapHandler.SetMessageBlockerFunc(chainPipelineFuncs(pipelineFuncs...))
+	}
+
+	vwapHandler.SetLogger(logger)
+	vwapHandler.SetStreamer(streamer)
+
+	// Record the raw messages of the Coinbase exchange feed.
+	if *recordDir != "" {
+		messageRecorder, ok := streamer.(interface {
+			SetRecorder(recorder streaming.MessageRecorder)
+		})
+		if !ok {
+			return fail(logger, "recording is only supported for the coinbase exchange feed")
+		}
+
+		config := recorder.NewConfig(*recordDir)
+		config.MaxBytes = *recordMaxSize
+		config.MaxAge = *recordMaxAge
+
+		feedRecorder, err := recorder.NewRecorder(config)
+		if err != nil {
+			return fail(logger, "failed to create recorder: %v", err)
+		}
+		defer feedRecorder.Close()
+
+		feedRecorder.SetLogger(logger)
+		messageRecorder.SetRecorder(feedRecorder)
+	}
 
 	logger.Infoln("Starting vwap price streaming...")
-	logger.Infof("Subscribing to %d pairs: %s with window size %d", len(productIds), *queryPairs, *vwapWindowSize)
+	logger.Infof(
+		"Subscribing to %d pairs: %s with window size %d",
+		len(productIds),
+		strings.Join(productIds, ","),
+		*vwapWindowSize,
+	)
+
+	// Start streaming and handling, the replay ends the pipeline once it's finished.
+	pipeline := streaming.NewPipeline(vwapHandler)
+	pipeline.SetLogger(logger)
 
-	// Start streaming and handling.
-	err = streamHandler.Handle()
+	err = pipeline.Start(ctx)
 	if err != nil {
-		logger.Errorf("failed to handle stream data: %v", err)
-		return
+		return fail(logger, "failed to handle stream data: %v", err)
+	}
+
+	// Keep the pattern selection up to date with the newly listed products.
+	if resolver != nil && *resolveInterval > 0 {
+		go resolver.watch(streamer.GetContext(), *resolveInterval, productIds, subscriber, vwapHandler)
+	}
+
+	if *latencyInterval > 0 {
+		go reportLatencies(streamer.GetContext(), *latencyInterval, vwapHandler)
 	}
 
 	// Wait for interrupt signal to gracefully shutdown the process.
-	for {
-		select {
-		case <-interrupt:
-			logger.Infoln("Interrupt key signal received, stopping...")
-			return
+	return waitPipeline(pipeline, interrupt, *shutdownTimeout, logger)
+}
+
+// needsProductResolution reports whether the pairs have to be resolved against the products list.
+func needsProductResolution(pairs []string, exclude string, quotes string) bool {
+	if exclude != "" || quotes != "" {
+		return true
+	}
+
+	for _, pair := range pairs {
+		if coinbase.IsPattern(pair) {
+			return true
 		}
 	}
+
+	return false
+}
+
+// fail logs the error of run at the fatal level, which the logger always logs, and returns the exit code of the failed
+// run. Unlike Fatalf, it doesn't exit, so that the deferred cleanup of run still runs.
+func fail(logger *logrus.Logger, format string, args ...interface{}) int {
+	logger.Logf(logrus.FatalLevel, format, args...)
+
+	return 1
+}
+
+func isFlagSet(name string) bool {
+	set := false
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+// waitPipeline waits for the interrupt signal or for the pipeline to end on its own, stops it within the shutdown
+// timeout and returns the exit code of the process, non-zero when the pipeline has failed.
+func waitPipeline(
+	pipeline *streaming.Pipeline,
+	interrupt <-chan os.Signal,
+	shutdownTimeout time.Duration,
+	logger *logrus.Logger,
+) int {
+	select {
+	case <-interrupt:
+		logger.Infoln("Interrupt key signal received, stopping...")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		err := pipeline.Stop(ctx)
+		if err != nil {
+			logger.Errorf("failed to stop the pipeline within %v: %v", shutdownTimeout, err)
+			return 1
+		}
+	case <-pipeline.Done():
+		logger.Infoln("Pipeline ended, stopping...")
+	}
+
+	err := pipeline.Wait()
+	if err != nil {
+		logger.Errorf("pipeline failed: %v", err)
+		return 1
+	}
+
+	return 0
+}
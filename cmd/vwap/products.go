@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/handler"
+	"github.com/sirupsen/logrus"
+)
+
+// productSubscriber is the streamer that can change its subscriptions at runtime.
+type productSubscriber interface {
+	Subscribe(productIds []string) error
+	Unsubscribe(productIds []string) error
+}
+
+// productResolver resolves the product selection rules against the products list.
+type productResolver struct {
+	lister   coinbase.ProductLister
+	selector coinbase.ProductSelector
+	logger   *logrus.Logger
+}
+
+func (r *productResolver) resolve() ([]string, error) {
+	products, err := r.lister.GetProducts()
+	if err != nil {
+		return nil, err
+	}
+
+	productIds := r.selector.Select(products)
+	if len(productIds) == 0 {
+		return nil, errors.New("no online product matches the selection rules")
+	}
+
+	return productIds, nil
+}
+
+// watch re-resolves the products on every interval, the newly listed products are backfilled and subscribed to, and
+// the products that are no longer selected are unsubscribed from.
+func (r *productResolver) watch(
+	ctx context.Context,
+	interval time.Duration,
+	productIds []string,
+	streamer productSubscriber,
+	vwapHandler *handler.CoinbaseSteamDataHandler,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			resolved, err := r.resolve()
+			if err != nil {
+				r.logger.Errorf("Error re-resolving products: %v", err)
+				continue
+			}
+
+			added, removed := coinbase.DiffProducts(productIds, resolved)
+			if len(added) == 0 && len(removed) == 0 {
+				continue
+			}
+
+			// The tracked products are updated by each operation that succeeded, the failed ones are retried on the
+			// next tick.
+			if len(added) > 0 {
+				r.logger.Infof("Subscribing to %d new pairs: %s", len(added), strings.Join(added, ","))
+
+				err = vwapHandler.Backfill(added)
+				if err != nil {
+					r.logger.Errorf("Error backfilling new pairs: %v", err)
+				}
+
+				err = streamer.Subscribe(added)
+				if err != nil {
+					r.logger.Errorf("Error subscribing to new pairs: %v", err)
+				} else {
+					productIds = append(productIds, added...)
+				}
+			}
+
+			if len(removed) > 0 {
+				r.logger.Infof("Unsubscribing from %d delisted pairs: %s", len(removed), strings.Join(removed, ","))
+
+				err = streamer.Unsubscribe(removed)
+				if err != nil {
+					r.logger.Errorf("Error unsubscribing from delisted pairs: %v", err)
+				} else {
+					productIds = withoutProducts(productIds, removed)
+					vwapHandler.RemoveProducts(removed)
+				}
+			}
+		}
+	}
+}
+
+// withoutProducts returns the products that aren't removed.
+func withoutProducts(productIds []string, removed []string) []string {
+	kept, _ := coinbase.DiffProducts(removed, productIds)
+
+	return kept
+}
+
+// splitList splits a comma separated flag value, ignoring the empty items.
+func splitList(value string) []string {
+	items := make([]string, 0)
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,108 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTimeout is the default timeout applied to every request sent by the client.
+const DefaultTimeout = 10 * time.Second
+
+// Client is a generic REST client that build on top of net/http package. It provides a set of simple functions to
+// send requests and decode the JSON responses.
+type Client struct {
+	Ctx           context.Context
+	BaseURL       string
+	HTTPClient    *http.Client
+	RequestHeader http.Header
+	logger        *logrus.Logger
+}
+
+// StatusError is returned when the server responds with a non 2xx status code.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response status %s: %s", e.Status, e.Body)
+}
+
+func NewClient(ctx context.Context, baseURL string) *Client {
+	return &Client{
+		Ctx:           ctx,
+		BaseURL:       strings.TrimRight(baseURL, "/"),
+		HTTPClient:    &http.Client{Timeout: DefaultTimeout},
+		RequestHeader: http.Header{},
+		logger:        logrus.New(),
+	}
+}
+
+func (c *Client) SetLogger(logger *logrus.Logger) {
+	c.logger = logger
+}
+
+// Get sends a GET request to the given path with the query parameters and decodes the JSON response body into out.
+// The response header is returned as well, so that the caller can follow the pagination cursors.
+func (c *Client) Get(path string, query url.Values, out interface{}) (http.Header, error) {
+	endpoint := c.BaseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(c.Ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range c.RequestHeader {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	c.logger.Debugf("GET %s", endpoint)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		c.logger.Errorf("Error sending request: %s", err)
+
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.Header, err
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.Header, &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       string(body),
+		}
+	}
+
+	if out != nil {
+		err = json.Unmarshal(body, out)
+		if err != nil {
+			return resp.Header, err
+		}
+	}
+
+	return resp.Header, nil
+}
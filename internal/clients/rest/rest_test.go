@@ -0,0 +1,145 @@
+//go:build all
+// +build all
+
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewClient(t *testing.T) {
+	type args struct {
+		ctx     context.Context
+		baseURL string
+	}
+	logger := logrus.New()
+	tests := []struct {
+		name string
+		args args
+		want *Client
+	}{
+		// Add TestNewClient function test cases.
+		{
+			name: "TestNewClient",
+			args: args{
+				ctx:     context.Background(),
+				baseURL: "https://api.exchange.coinbase.com/",
+			},
+			want: &Client{
+				Ctx:           context.Background(),
+				BaseURL:       "https://api.exchange.coinbase.com",
+				HTTPClient:    &http.Client{Timeout: DefaultTimeout},
+				RequestHeader: http.Header{},
+				logger:        logger,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewClient(tt.args.ctx, tt.args.baseURL)
+			got.SetLogger(logger)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewClient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Get(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/products/BTC-USD/trades":
+			w.Header().Set("CB-AFTER", "42")
+			_, _ = w.Write([]byte(`[{"trade_id": 43, "price": "40000.01"}]`))
+		case "/broken":
+			_, _ = w.Write([]byte(`{"broken"`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"NotFound"}`))
+		}
+	}))
+	defer server.Close()
+
+	type trade struct {
+		TradeID int    `json:"trade_id"`
+		Price   string `json:"price"`
+	}
+	type args struct {
+		path  string
+		query url.Values
+	}
+	tests := []struct {
+		name        string
+		args        args
+		want        []trade
+		wantAfter   string
+		wantErr     bool
+		wantErrCode int
+	}{
+		// Add TestClient_Get test cases.
+		{
+			name: "TestClient_Get",
+			args: args{
+				path:  "/products/BTC-USD/trades",
+				query: url.Values{"limit": []string{"1"}},
+			},
+			want:      []trade{{TradeID: 43, Price: "40000.01"}},
+			wantAfter: "42",
+		},
+		{
+			name: "TestClient_Get not found",
+			args: args{
+				path: "/products/BTC-US/trades",
+			},
+			wantErr:     true,
+			wantErrCode: http.StatusNotFound,
+		},
+		{
+			name: "TestClient_Get malformed body",
+			args: args{
+				path: "/broken",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(context.Background(), server.URL)
+
+			var got []trade
+
+			header, err := c.Get(tt.args.path, tt.args.query, &got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErrCode != 0 {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.wantErrCode {
+					t.Errorf("Get() error = %v, want status code %v", err, tt.wantErrCode)
+				}
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Get() = %v, want %v", got, tt.want)
+			}
+
+			if after := header.Get("CB-AFTER"); after != tt.wantAfter {
+				t.Errorf("Get() CB-AFTER = %v, want %v", after, tt.wantAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultMaxDecompressedSize is the default limit of the size of a decompressed message.
+const DefaultMaxDecompressedSize = 16 << 20
+
+const (
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+)
+
+var (
+	// ErrSkipFrame is returned by a codec for the frames that aren't messages, they're skipped silently.
+	ErrSkipFrame = errors.New("frame skipped")
+	// ErrInvalidJSON is returned by the JSON codecs for the frames that don't hold a JSON document.
+	ErrInvalidJSON = errors.New("invalid json")
+	// ErrMessageTooLarge is returned when a decompressed message exceeds the limit.
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// Codec decodes the text and binary frames of a connection into the messages passed to the callbacks.
+type Codec interface {
+	// Decode returns the message of a frame of the websocket message type, or ErrSkipFrame to skip the frame.
+	Decode(messageType int, data []byte) ([]byte, error)
+}
+
+// TextCodec passes the text frames as they are and skips the binary frames. It's the default codec.
+type TextCodec struct{}
+
+func (TextCodec) Decode(messageType int, data []byte) ([]byte, error) {
+	if messageType != websocket.TextMessage {
+		return nil, ErrSkipFrame
+	}
+
+	return data, nil
+}
+
+// RawCodec passes the text and the binary frames as they are, e.g. for protobuf frames decoded by the callback.
+type RawCodec struct{}
+
+func (RawCodec) Decode(messageType int, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// JSONCodec passes the text and the binary frames that hold a JSON document.
+type JSONCodec struct{}
+
+func (JSONCodec) Decode(messageType int, data []byte) ([]byte, error) {
+	if !json.Valid(data) {
+		return nil, ErrInvalidJSON
+	}
+
+	return data, nil
+}
+
+// CompressedCodec decompresses the binary frames with gzip or deflate and decodes them with the wrapped codec, the
+// text frames are decoded as they are.
+type CompressedCodec struct {
+	Compression string
+	Codec       Codec
+	// MaxSize limits the size of a decompressed message, DefaultMaxDecompressedSize when it's zero.
+	MaxSize int64
+}
+
+// NewGzipJSONCodec creates a codec of the gzip compressed JSON messages.
+func NewGzipJSONCodec() *CompressedCodec {
+	return &CompressedCodec{Compression: CompressionGzip, Codec: JSONCodec{}}
+}
+
+// NewDeflateJSONCodec creates a codec of the raw deflate compressed JSON messages.
+func NewDeflateJSONCodec() *CompressedCodec {
+	return &CompressedCodec{Compression: CompressionDeflate, Codec: JSONCodec{}}
+}
+
+func (c *CompressedCodec) Decode(messageType int, data []byte) ([]byte, error) {
+	if messageType == websocket.BinaryMessage {
+		decompressed, err := c.decompress(data)
+		if err != nil {
+			return nil, err
+		}
+
+		data = decompressed
+	}
+
+	if c.Codec == nil {
+		return data, nil
+	}
+
+	return c.Codec.Decode(messageType, data)
+}
+
+func (c *CompressedCodec) decompress(data []byte) ([]byte, error) {
+	var reader io.ReadCloser
+
+	switch c.Compression {
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		reader = gzipReader
+	case CompressionDeflate:
+		reader = flate.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unknown compression %q", c.Compression)
+	}
+	defer reader.Close()
+
+	maxSize := c.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(decompressed)) > maxSize {
+		return nil, fmt.Errorf("%w: over %d bytes", ErrMessageTooLarge, maxSize)
+	}
+
+	return decompressed, nil
+}
@@ -0,0 +1,203 @@
+//go:build all
+// +build all
+
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const codecTestMessage = `{"type":"match","product_id":"BTC-USD"}`
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+
+	w := gzip.NewWriter(&buffer)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func deflated(t *testing.T, data string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+
+	w, err := flate.NewWriter(&buffer, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestCodec_Decode(t *testing.T) {
+	tests := []struct {
+		name        string
+		codec       Codec
+		messageType int
+		data        []byte
+		want        []byte
+		wantErr     error
+	}{
+		// Add TestCodec_Decode test cases.
+		{
+			name:        "TestCodec_Decode text",
+			codec:       TextCodec{},
+			messageType: websocket.TextMessage,
+			data:        []byte(codecTestMessage),
+			want:        []byte(codecTestMessage),
+		},
+		{
+			name:        "TestCodec_Decode text skips binary",
+			codec:       TextCodec{},
+			messageType: websocket.BinaryMessage,
+			data:        []byte{0x0a, 0x03},
+			wantErr:     ErrSkipFrame,
+		},
+		{
+			name:        "TestCodec_Decode raw binary",
+			codec:       RawCodec{},
+			messageType: websocket.BinaryMessage,
+			data:        []byte{0x0a, 0x03},
+			want:        []byte{0x0a, 0x03},
+		},
+		{
+			name:        "TestCodec_Decode json binary",
+			codec:       JSONCodec{},
+			messageType: websocket.BinaryMessage,
+			data:        []byte(codecTestMessage),
+			want:        []byte(codecTestMessage),
+		},
+		{
+			name:        "TestCodec_Decode json invalid",
+			codec:       JSONCodec{},
+			messageType: websocket.TextMessage,
+			data:        []byte(`{"type":`),
+			wantErr:     ErrInvalidJSON,
+		},
+		{
+			name:        "TestCodec_Decode gzip json",
+			codec:       NewGzipJSONCodec(),
+			messageType: websocket.BinaryMessage,
+			data:        gzipped(t, codecTestMessage),
+			want:        []byte(codecTestMessage),
+		},
+		{
+			name:        "TestCodec_Decode gzip json text frame",
+			codec:       NewGzipJSONCodec(),
+			messageType: websocket.TextMessage,
+			data:        []byte(codecTestMessage),
+			want:        []byte(codecTestMessage),
+		},
+		{
+			name:        "TestCodec_Decode gzip invalid json",
+			codec:       NewGzipJSONCodec(),
+			messageType: websocket.BinaryMessage,
+			data:        gzipped(t, "ping"),
+			wantErr:     ErrInvalidJSON,
+		},
+		{
+			name:        "TestCodec_Decode deflate json",
+			codec:       NewDeflateJSONCodec(),
+			messageType: websocket.BinaryMessage,
+			data:        deflated(t, codecTestMessage),
+			want:        []byte(codecTestMessage),
+		},
+		{
+			name:        "TestCodec_Decode gzip too large",
+			codec:       &CompressedCodec{Compression: CompressionGzip, MaxSize: 8},
+			messageType: websocket.BinaryMessage,
+			data:        gzipped(t, codecTestMessage),
+			wantErr:     ErrMessageTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.codec.Decode(tt.messageType, tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Connect_codec(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	frame := gzipped(t, codecTestMessage)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte("not gzip"))
+		_ = conn.WriteMessage(websocket.BinaryMessage, frame)
+
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	c := NewClient(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	c.Codec = NewGzipJSONCodec()
+
+	received := make(chan []byte, 2)
+	c.OnReceivingData = func(data []byte, client Client) {
+		received <- data
+	}
+
+	messages := make(chan string, 2)
+	c.OnReceivingMsg = func(message string, client Client) {
+		messages <- message
+	}
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+
+	// The frame that can't be decoded is skipped.
+	select {
+	case data := <-received:
+		if string(data) != codecTestMessage || <-messages != codecTestMessage {
+			t.Errorf("OnReceivingData() = %q, want %q", data, codecTestMessage)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnReceivingData() not called")
+	}
+}
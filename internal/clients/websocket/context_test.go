@@ -0,0 +1,250 @@
+//go:build all
+// +build all
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/goleak"
+)
+
+// newStreamingServer starts a websocket server that sends a message every few milliseconds until the connection is
+// closed.
+func newStreamingServer(t *testing.T) (string, func()) {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"heartbeat"}`))
+			if err != nil {
+				return
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+
+	return "ws" + strings.TrimPrefix(server.URL, "http"), server.Close
+}
+
+func TestClient_Connect_context(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	serverURL, stop := newStreamingServer(t)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := NewClient(ctx, serverURL)
+
+	disconnected := make(chan error, 1)
+	c.OnDisconnected = func(err error, client Client) {
+		disconnected <- err
+	}
+
+	var received int32
+	c.OnReceivingMsg = func(message string, client Client) {
+		atomic.AddInt32(&received, 1)
+	}
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-disconnected:
+		if err != nil {
+			t.Errorf("OnDisconnected() error = %v, want nil after the context is cancelled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnDisconnected() not called after the context is cancelled")
+	}
+
+	if c.State() != StateClosed {
+		t.Errorf("State() = %v, want %v", c.State(), StateClosed)
+	}
+
+	// The reader has stopped, no more messages are received.
+	count := atomic.LoadInt32(&received)
+	time.Sleep(50 * time.Millisecond)
+
+	if count == 0 || atomic.LoadInt32(&received) != count {
+		t.Errorf("received = %d then %d messages, want some and none after closing", count, received)
+	}
+}
+
+func TestClient_ConnectContext(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	// A listener that accepts the connections and never answers the handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		// Add TestClient_ConnectContext test cases.
+		{
+			name: "TestClient_ConnectContext deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "TestClient_ConnectContext cancelled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return cancelled, func() {}
+			},
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			c := NewClient(context.Background(), "ws://"+listener.Addr().String())
+
+			start := time.Now()
+
+			err := c.ConnectContext(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ConnectContext() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if time.Since(start) > time.Second {
+				t.Errorf("ConnectContext() took %v, want it to return on the context", time.Since(start))
+			}
+
+			if c.State() != StateClosed {
+				t.Errorf("State() = %v, want %v", c.State(), StateClosed)
+			}
+		})
+	}
+}
+
+func TestClient_Close_waits(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	serverURL, stop := newStreamingServer(t)
+	defer stop()
+
+	c := NewClient(context.Background(), serverURL)
+
+	var (
+		closed   int32
+		received int32
+	)
+
+	c.OnReceivingMsg = func(message string, client Client) {
+		// A slow callback still running when Close is called.
+		time.Sleep(20 * time.Millisecond)
+
+		if atomic.LoadInt32(&closed) == 1 {
+			atomic.AddInt32(&received, 1)
+		}
+	}
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	c.Close()
+	atomic.StoreInt32(&closed, 1)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if atomic.LoadInt32(&received) != 0 {
+		t.Errorf("received %d messages after Close() returned, want none", received)
+	}
+}
+
+func TestClient_ReceivedAt(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	serverURL, stop := newStreamingServer(t)
+	defer stop()
+
+	c := NewClient(context.Background(), serverURL)
+
+	receivedAt := make(chan time.Time, 1)
+	c.OnReceivingMsg = func(message string, client Client) {
+		select {
+		case receivedAt <- client.ReceivedAt():
+		default:
+		}
+	}
+
+	before := time.Now()
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+
+	select {
+	case got := <-receivedAt:
+		if got.Before(before) || got.After(time.Now()) {
+			t.Errorf("ReceivedAt() = %v, want between %v and now", got, before)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnReceivingMsg() not called")
+	}
+
+	if !c.ReceivedAt().IsZero() {
+		t.Errorf("ReceivedAt() of the client = %v, want zero", c.ReceivedAt())
+	}
+}
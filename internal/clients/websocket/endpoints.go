@@ -0,0 +1,252 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultFailbackInterval is the default interval the preferred endpoints are probed at while connected to a less
+// preferred one.
+const DefaultFailbackInterval = time.Minute
+
+var (
+	// ErrAllEndpointsFailed is returned by Connect when none of the endpoints could be connected to.
+	ErrAllEndpointsFailed = errors.New("all endpoints failed")
+	// ErrFailback is the error a connection is dropped with to fail back to a preferred endpoint that has recovered.
+	ErrFailback = errors.New("failing back to a preferred endpoint")
+)
+
+// EndpointHealth is the health of an endpoint, an endpoint is healthy until it fails and again once it's connected to.
+type EndpointHealth struct {
+	URL         string
+	Active      bool
+	Healthy     bool
+	Failures    int
+	LastError   error
+	LastFailure time.Time
+	LastSuccess time.Time
+}
+
+// endpointPool tracks the health of the prioritized endpoints of a client and the active one.
+type endpointPool struct {
+	health []EndpointHealth
+	active int
+	mu     sync.Mutex
+}
+
+func newEndpointPool() *endpointPool {
+	return &endpointPool{active: -1}
+}
+
+// setURLs resets the health of the endpoints when their list has changed.
+func (p *endpointPool) setURLs(urls []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(urls) == len(p.health) {
+		same := true
+		for i, url := range urls {
+			same = same && p.health[i].URL == url
+		}
+
+		if same {
+			return
+		}
+	}
+
+	p.health = make([]EndpointHealth, 0, len(urls))
+	for _, url := range urls {
+		p.health = append(p.health, EndpointHealth{URL: url, Healthy: true})
+	}
+
+	p.active = -1
+}
+
+// order returns the indexes of the endpoints in the order they're tried, the healthy ones first, each group by
+// priority.
+func (p *endpointPool) order() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	order := make([]int, 0, len(p.health))
+	for i := range p.health {
+		order = append(order, i)
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return p.health[order[i]].Healthy && !p.health[order[j]].Healthy
+	})
+
+	return order
+}
+
+func (p *endpointPool) url(i int) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.health[i].URL
+}
+
+func (p *endpointPool) succeeded(i int, active bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.health[i].Healthy = true
+	p.health[i].Failures = 0
+	p.health[i].LastSuccess = time.Now()
+
+	if active {
+		p.active = i
+	}
+}
+
+func (p *endpointPool) failed(i int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.health[i].Healthy = false
+	p.health[i].Failures++
+	p.health[i].LastError = err
+	p.health[i].LastFailure = time.Now()
+
+	if p.active == i {
+		p.active = -1
+	}
+}
+
+func (p *endpointPool) snapshot() []EndpointHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	health := make([]EndpointHealth, 0, len(p.health))
+	for i, endpoint := range p.health {
+		endpoint.Active = i == p.active
+		health = append(health, endpoint)
+	}
+
+	return health
+}
+
+// endpointURLs returns the prioritized endpoints of the client, the URL when no endpoints are given.
+func (c *Client) endpointURLs() []string {
+	if len(c.Endpoints) > 0 {
+		return c.Endpoints
+	}
+
+	return []string{c.URL}
+}
+
+// endpointPool returns the endpoint pool of the client, creating it for the clients not created by NewClient.
+func (c *Client) endpointPool() *endpointPool {
+	if c.endpoints == nil {
+		c.endpoints = newEndpointPool()
+	}
+
+	return c.endpoints
+}
+
+// EndpointHealth returns the health of the endpoints in their priority order.
+func (c *Client) EndpointHealth() []EndpointHealth {
+	if c.endpoints == nil {
+		return nil
+	}
+
+	return c.endpoints.snapshot()
+}
+
+// ActiveEndpoint returns the url of the endpoint the client is connected to, or an empty string.
+func (c *Client) ActiveEndpoint() string {
+	for _, endpoint := range c.EndpointHealth() {
+		if endpoint.Active {
+			return endpoint.URL
+		}
+	}
+
+	return ""
+}
+
+// dialEndpoints dials the endpoints in the order of their health and priority, and returns the connection of the
+// first one that succeeds with its index.
+func (c *Client) dialEndpoints(ctx context.Context) (*websocket.Conn, int, error) {
+	pool := c.endpointPool()
+	pool.setURLs(c.endpointURLs())
+
+	var lastErr error
+
+	for _, i := range pool.order() {
+		url := pool.url(i)
+
+		conn, resp, err := c.WebsocketDialer.DialContext(ctx, url, c.RequestHeader)
+		if err == nil {
+			pool.succeeded(i, true)
+			return conn, i, nil
+		}
+
+		err = contextError(ctx, err)
+
+		c.logger.Errorf("Error connecting to websocket %s: %s", url, err)
+		if resp != nil {
+			c.logger.Errorf("HTTP Response %d status: %s", resp.StatusCode, resp.Status)
+		}
+
+		pool.failed(i, err)
+		lastErr = err
+
+		// The context ends the dial of every endpoint.
+		if ctx.Err() != nil {
+			return nil, -1, err
+		}
+	}
+
+	if len(c.endpointURLs()) == 1 {
+		return nil, -1, lastErr
+	}
+
+	return nil, -1, fmt.Errorf("%w: %s", ErrAllEndpointsFailed, lastErr)
+}
+
+// failback probes the endpoints preferred over the active one every FailbackInterval, and drops the connection with
+// ErrFailback once one of them can be connected to, so that the owner of the client reconnects to it.
+func (c *Client) failback(ctx context.Context, active int, keepalive *keepalive, readerDone chan struct{}) {
+	ticker := time.NewTicker(c.FailbackInterval)
+	defer ticker.Stop()
+
+	pool := c.endpointPool()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-readerDone:
+			return
+		case <-ticker.C:
+		}
+
+		for i := 0; i < active; i++ {
+			url := pool.url(i)
+
+			probeCtx, cancel := context.WithTimeout(ctx, c.FailbackInterval)
+			conn, _, err := c.WebsocketDialer.DialContext(probeCtx, url, c.RequestHeader)
+			cancel()
+
+			if err != nil {
+				pool.failed(i, err)
+				continue
+			}
+
+			_ = conn.Close()
+			pool.succeeded(i, false)
+
+			c.logger.Infof("Endpoint %s has recovered, failing back", url)
+			keepalive.drop(fmt.Errorf("%w: %s", ErrFailback, url))
+
+			return
+		}
+	}
+}
@@ -0,0 +1,223 @@
+//go:build all
+// +build all
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/goleak"
+)
+
+// endpointServer is a websocket server that can be taken down, it then rejects the handshakes, and whose
+// connections can be dropped.
+type endpointServer struct {
+	url  string
+	down int32
+	drop chan struct{}
+}
+
+func newEndpointServer(t *testing.T, down bool) *endpointServer {
+	t.Helper()
+
+	s := &endpointServer{drop: make(chan struct{})}
+	if down {
+		s.down = 1
+	}
+
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&s.down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		read := make(chan struct{})
+		go func() {
+			defer close(read)
+
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		select {
+		case <-s.drop:
+		case <-read:
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	s.url = "ws" + strings.TrimPrefix(server.URL, "http")
+
+	return s
+}
+
+func (s *endpointServer) setDown(down bool) {
+	if down {
+		atomic.StoreInt32(&s.down, 1)
+	} else {
+		atomic.StoreInt32(&s.down, 0)
+	}
+}
+
+func TestClient_Connect_failover(t *testing.T) {
+	// The servers are closed by the cleanups, the goroutines are verified after them.
+	t.Cleanup(func() { goleak.VerifyNone(t) })
+
+	primary := newEndpointServer(t, true)
+	backup := newEndpointServer(t, false)
+
+	c := NewClient(context.Background(), primary.url)
+	c.Endpoints = []string{primary.url, backup.url}
+	c.FailbackInterval = 0
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+
+	if c.ActiveEndpoint() != backup.url {
+		t.Errorf("ActiveEndpoint() = %v, want the backup %v", c.ActiveEndpoint(), backup.url)
+	}
+
+	health := c.EndpointHealth()
+	if health[0].Healthy || health[0].Failures != 1 || health[0].LastError == nil || health[0].Active {
+		t.Errorf("EndpointHealth() primary = %+v, want unhealthy with 1 failure", health[0])
+	}
+
+	if !health[1].Healthy || !health[1].Active || health[1].LastSuccess.IsZero() {
+		t.Errorf("EndpointHealth() backup = %+v, want healthy and active", health[1])
+	}
+}
+
+func TestClient_Connect_allEndpointsFailed(t *testing.T) {
+	primary := newEndpointServer(t, true)
+	backup := newEndpointServer(t, true)
+
+	c := NewClient(context.Background(), primary.url)
+	c.Endpoints = []string{primary.url, backup.url}
+
+	err := c.Connect()
+	if !errors.Is(err, ErrAllEndpointsFailed) {
+		t.Errorf("Connect() error = %v, want %v", err, ErrAllEndpointsFailed)
+	}
+
+	if c.ActiveEndpoint() != "" || c.State() != StateClosed {
+		t.Errorf("ActiveEndpoint() = %q, State() = %v, want none and closed", c.ActiveEndpoint(), c.State())
+	}
+}
+
+func TestClient_Connect_rotate(t *testing.T) {
+	t.Cleanup(func() { goleak.VerifyNone(t) })
+
+	primary := newEndpointServer(t, false)
+	backup := newEndpointServer(t, false)
+
+	c := NewClient(context.Background(), primary.url)
+	c.Endpoints = []string{primary.url, backup.url}
+	c.FailbackInterval = 0
+
+	disconnected := make(chan error, 1)
+	c.OnDisconnected = func(err error, client Client) {
+		disconnected <- err
+	}
+
+	err := c.Connect()
+	if err != nil || c.ActiveEndpoint() != primary.url {
+		t.Fatalf("Connect() error = %v, ActiveEndpoint() = %v, want the primary", err, c.ActiveEndpoint())
+	}
+
+	// The primary drops the connection, the reconnect rotates to the backup.
+	close(primary.drop)
+
+	if err := <-disconnected; err == nil {
+		t.Fatalf("OnDisconnected() error = nil, want the read error")
+	}
+
+	err = c.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+
+	if c.ActiveEndpoint() != backup.url {
+		t.Errorf("ActiveEndpoint() = %v, want the backup %v", c.ActiveEndpoint(), backup.url)
+	}
+}
+
+func TestClient_Connect_failback(t *testing.T) {
+	t.Cleanup(func() { goleak.VerifyNone(t) })
+
+	primary := newEndpointServer(t, true)
+	backup := newEndpointServer(t, false)
+
+	c := NewClient(context.Background(), primary.url)
+	c.Endpoints = []string{primary.url, backup.url}
+	c.FailbackInterval = 20 * time.Millisecond
+
+	disconnected := make(chan error, 1)
+	c.OnDisconnected = func(err error, client Client) {
+		disconnected <- err
+	}
+
+	endpoints := make(chan string, 2)
+	c.SubscribeState(func(change StateChange) {
+		if change.To == StateConnected {
+			endpoints <- change.Endpoint
+		}
+	})
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if endpoint := <-endpoints; endpoint != backup.url {
+		t.Fatalf("StateChange.Endpoint = %v, want the backup %v", endpoint, backup.url)
+	}
+
+	// The primary recovers, the connection to the backup is dropped to fail back.
+	primary.setDown(false)
+
+	select {
+	case err := <-disconnected:
+		if !errors.Is(err, ErrFailback) {
+			t.Fatalf("OnDisconnected() error = %v, want %v", err, ErrFailback)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnDisconnected() not called, want a failback")
+	}
+
+	err = c.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+
+	if endpoint := <-endpoints; endpoint != primary.url || c.ActiveEndpoint() != primary.url {
+		t.Errorf("StateChange.Endpoint = %v, ActiveEndpoint() = %v, want the primary", endpoint, c.ActiveEndpoint())
+	}
+
+	if health := c.EndpointHealth(); !health[1].Healthy {
+		t.Errorf("EndpointHealth() backup = %+v, want healthy after failing back", health[1])
+	}
+}
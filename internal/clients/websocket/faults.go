@@ -0,0 +1,362 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultFaultMaxDelay is the default upper limit of the delay injected into the inbound messages.
+const DefaultFaultMaxDelay = 100 * time.Millisecond
+
+var (
+	// ErrInjectedDisconnect is reported to OnDisconnected when the fault injector forces a disconnect.
+	ErrInjectedDisconnect = errors.New("injected disconnect")
+	// ErrInvalidFaultConfig is returned for the fault configs with a probability out of the [0, 1] range, or a
+	// negative duration.
+	ErrInvalidFaultConfig = errors.New("invalid fault config")
+)
+
+// Duration is a time.Duration decoded from a JSON string such as "1m30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// FaultConfig are the faults injected into the inbound messages of a client. Drop, Delay, Duplicate, Reorder and
+// Corrupt are the probabilities of the fault for every message, between 0 and 1. The delays are uniformly distributed
+// up to MaxDelay, DefaultFaultMaxDelay when it's zero. Disconnects are the offsets from the first connect the
+// connection is forcibly dropped at. The same seed always injects the same faults into the same messages.
+type FaultConfig struct {
+	Seed        int64      `json:"seed"`
+	Drop        float64    `json:"drop"`
+	Delay       float64    `json:"delay"`
+	MaxDelay    Duration   `json:"max_delay"`
+	Duplicate   float64    `json:"duplicate"`
+	Reorder     float64    `json:"reorder"`
+	Corrupt     float64    `json:"corrupt"`
+	Disconnects []Duration `json:"disconnects"`
+}
+
+// LoadFaultConfig loads a fault config from a JSON file.
+func LoadFaultConfig(path string) (FaultConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FaultConfig{}, err
+	}
+
+	var config FaultConfig
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return FaultConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// FaultStats are the numbers of the faults injected so far.
+type FaultStats struct {
+	Dropped      int
+	Delayed      int
+	Duplicated   int
+	Reordered    int
+	Corrupted    int
+	Disconnected int
+}
+
+// FaultInjector injects the faults of its config into the inbound messages of a client, set as the Faults of the
+// client. An injector belongs to one client, the random faults are only reproducible when the messages are received in
+// the same order.
+type FaultInjector struct {
+	config FaultConfig
+	random *rand.Rand
+	start  time.Time
+	held   [][]byte
+	stats  FaultStats
+	mu     sync.Mutex
+}
+
+func NewFaultInjector(config FaultConfig) (*FaultInjector, error) {
+	probabilities := []float64{config.Drop, config.Delay, config.Duplicate, config.Reorder, config.Corrupt}
+	for _, p := range probabilities {
+		if p < 0 || p > 1 {
+			return nil, fmt.Errorf("%w: probability %v out of range", ErrInvalidFaultConfig, p)
+		}
+	}
+
+	if config.MaxDelay < 0 {
+		return nil, fmt.Errorf("%w: negative max delay %v", ErrInvalidFaultConfig, time.Duration(config.MaxDelay))
+	}
+
+	if config.MaxDelay == 0 {
+		config.MaxDelay = Duration(DefaultFaultMaxDelay)
+	}
+
+	for _, offset := range config.Disconnects {
+		if offset < 0 {
+			return nil, fmt.Errorf("%w: negative disconnect %v", ErrInvalidFaultConfig, time.Duration(offset))
+		}
+	}
+
+	return &FaultInjector{
+		config: config,
+		random: rand.New(rand.NewSource(config.Seed)),
+	}, nil
+}
+
+// Stats returns the numbers of the faults injected so far.
+func (f *FaultInjector) Stats() FaultStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.stats
+}
+
+// connected starts the disconnect schedule on the first connect, and forgets the message held back on the previous
+// connection.
+func (f *FaultInjector) connected(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.start.IsZero() {
+		f.start = now
+	}
+
+	f.held = nil
+}
+
+// nextDisconnect returns the next scheduled disconnect after the time, false when there's none left.
+func (f *FaultInjector) nextDisconnect(after time.Time) (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var (
+		next  time.Time
+		found bool
+	)
+
+	for _, offset := range f.config.Disconnects {
+		at := f.start.Add(time.Duration(offset))
+		if at.After(after) && (!found || at.Before(next)) {
+			next, found = at, true
+		}
+	}
+
+	return next, found
+}
+
+func (f *FaultInjector) disconnected() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stats.Disconnected++
+}
+
+// inject returns the messages to deliver in place of the received one, and the delay to deliver them after, see
+// deliveryQueue. Every fault is drawn for every message, in the same order, so that the same seed injects the same
+// faults.
+func (f *FaultInjector) inject(message []byte) ([][]byte, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	drop := f.random.Float64() < f.config.Drop
+	corrupt := f.random.Float64() < f.config.Corrupt
+	cut := f.random.Intn(len(message) + 1)
+	duplicate := f.random.Float64() < f.config.Duplicate
+	reorder := f.random.Float64() < f.config.Reorder
+	delay := f.random.Float64() < f.config.Delay
+	delayBy := time.Duration(f.random.Int63n(int64(f.config.MaxDelay)) + 1)
+
+	if drop {
+		f.stats.Dropped++
+		return nil, 0
+	}
+
+	// A corrupted message is truncated, which is never valid JSON.
+	if corrupt && len(message) > 0 {
+		f.stats.Corrupted++
+		message = append([]byte{}, message[:cut%len(message)]...)
+	}
+
+	messages := [][]byte{message}
+	if duplicate {
+		f.stats.Duplicated++
+		messages = append(messages, message)
+	}
+
+	// The held back message is released after the next one. A duplicated message is held back with its copy, so that
+	// neither of them is delivered before the next message.
+	switch {
+	case f.held != nil:
+		messages = append(messages, f.held...)
+		f.held = nil
+	case reorder:
+		f.stats.Reordered++
+		f.held = messages
+		messages = nil
+	}
+
+	if !delay || len(messages) == 0 {
+		return messages, 0
+	}
+
+	f.stats.Delayed++
+
+	return messages, delayBy
+}
+
+// injectDisconnects drops the connection at the next scheduled disconnect of the fault injector, unless the connection
+// is closed or the context is done before. The disconnects scheduled while the client wasn't connected are skipped.
+func (c *Client) injectDisconnects(
+	ctx context.Context,
+	faults *FaultInjector,
+	keepalive *keepalive,
+	readerDone chan struct{},
+) {
+	next, ok := faults.nextDisconnect(time.Now())
+	if !ok {
+		return
+	}
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-readerDone:
+		return
+	case <-timer.C:
+	}
+
+	faults.disconnected()
+
+	c.logger.Warnf("Injecting a disconnect scheduled at %s", next.Format(time.RFC3339Nano))
+	keepalive.drop(ErrInjectedDisconnect)
+}
+
+// delivery are the messages injected in place of a received one, delivered at a later time.
+type delivery struct {
+	messages   [][]byte
+	receivedAt time.Time
+	at         time.Time
+}
+
+// deliveryQueue delivers the injected messages of a connection in the order they were received, each once its time has
+// come. The messages are delivered on its own goroutine, so that the injected delays don't stall the reader, the pongs
+// and the keepalive of the connection.
+type deliveryQueue struct {
+	deliveries []delivery
+	ready      chan struct{}
+	stopped    chan struct{}
+	done       chan struct{}
+	once       sync.Once
+	mu         sync.Mutex
+}
+
+// newDeliveryQueue starts delivering the queued messages with deliver until the queue is stopped.
+func newDeliveryQueue(deliver func(d delivery)) *deliveryQueue {
+	q := &deliveryQueue{
+		ready:   make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go q.run(deliver)
+
+	return q
+}
+
+func (q *deliveryQueue) push(d delivery) {
+	q.mu.Lock()
+	q.deliveries = append(q.deliveries, d)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the first queued delivery without removing it, false when the queue is empty.
+func (q *deliveryQueue) next() (delivery, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.deliveries) == 0 {
+		return delivery{}, false
+	}
+
+	return q.deliveries[0], true
+}
+
+func (q *deliveryQueue) run(deliver func(d delivery)) {
+	defer close(q.done)
+
+	for {
+		d, ok := q.next()
+		if !ok {
+			select {
+			case <-q.stopped:
+				return
+			case <-q.ready:
+				continue
+			}
+		}
+
+		// The later deliveries wait behind the first one, even when they're due before it.
+		if wait := time.Until(d.at); wait > 0 {
+			timer := time.NewTimer(wait)
+
+			select {
+			case <-q.stopped:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		q.mu.Lock()
+		q.deliveries = q.deliveries[1:]
+		q.mu.Unlock()
+
+		select {
+		case <-q.stopped:
+			return
+		default:
+		}
+
+		deliver(d)
+	}
+}
+
+// stop stops the delivery, dropping the messages still queued, and waits until the delivery in progress has returned.
+func (q *deliveryQueue) stop() {
+	q.once.Do(func() { close(q.stopped) })
+	<-q.done
+}
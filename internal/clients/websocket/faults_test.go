@@ -0,0 +1,315 @@
+//go:build all
+// +build all
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestNewFaultInjector(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  FaultConfig
+		wantErr error
+	}{
+		// Add TestNewFaultInjector test cases.
+		{
+			name:   "TestNewFaultInjector valid",
+			config: FaultConfig{Drop: 0.1, Delay: 1, Disconnects: []Duration{Duration(time.Second)}},
+		},
+		{
+			name:    "TestNewFaultInjector probability above one",
+			config:  FaultConfig{Duplicate: 1.5},
+			wantErr: ErrInvalidFaultConfig,
+		},
+		{
+			name:    "TestNewFaultInjector negative probability",
+			config:  FaultConfig{Corrupt: -0.1},
+			wantErr: ErrInvalidFaultConfig,
+		},
+		{
+			name:    "TestNewFaultInjector negative max delay",
+			config:  FaultConfig{MaxDelay: Duration(-time.Second)},
+			wantErr: ErrInvalidFaultConfig,
+		},
+		{
+			name:    "TestNewFaultInjector negative disconnect",
+			config:  FaultConfig{Disconnects: []Duration{Duration(-time.Second)}},
+			wantErr: ErrInvalidFaultConfig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFaultInjector(tt.config)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewFaultInjector() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil && got.config.MaxDelay != Duration(DefaultFaultMaxDelay) {
+				t.Errorf("NewFaultInjector() max delay = %v, want %v", got.config.MaxDelay, DefaultFaultMaxDelay)
+			}
+		})
+	}
+}
+
+func TestFaultInjector_inject(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    FaultConfig
+		want      [][]string
+		wantDelay bool
+		wantStats FaultStats
+	}{
+		// Add TestFaultInjector_inject test cases.
+		{
+			name:   "TestFaultInjector_inject no faults",
+			config: FaultConfig{},
+			want:   [][]string{{"one"}, {"two"}, {"three"}},
+		},
+		{
+			name:      "TestFaultInjector_inject drop",
+			config:    FaultConfig{Drop: 1, Duplicate: 1},
+			want:      [][]string{nil, nil, nil},
+			wantStats: FaultStats{Dropped: 3},
+		},
+		{
+			name:      "TestFaultInjector_inject duplicate",
+			config:    FaultConfig{Duplicate: 1},
+			want:      [][]string{{"one", "one"}, {"two", "two"}, {"three", "three"}},
+			wantStats: FaultStats{Duplicated: 3},
+		},
+		{
+			name:      "TestFaultInjector_inject reorder",
+			config:    FaultConfig{Reorder: 1},
+			want:      [][]string{nil, {"two", "one"}, nil},
+			wantStats: FaultStats{Reordered: 2},
+		},
+		{
+			name:      "TestFaultInjector_inject reorder duplicate",
+			config:    FaultConfig{Duplicate: 1, Reorder: 1},
+			want:      [][]string{nil, {"two", "two", "one", "one"}, nil},
+			wantStats: FaultStats{Duplicated: 3, Reordered: 2},
+		},
+		{
+			name:      "TestFaultInjector_inject delay",
+			config:    FaultConfig{Delay: 1, MaxDelay: Duration(10 * time.Millisecond)},
+			want:      [][]string{{"one"}, {"two"}, {"three"}},
+			wantDelay: true,
+			wantStats: FaultStats{Delayed: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFaultInjector(tt.config)
+			if err != nil {
+				t.Fatalf("NewFaultInjector() error = %v", err)
+			}
+
+			for i, message := range []string{"one", "two", "three"} {
+				messages, delay := f.inject([]byte(message))
+
+				var got []string
+				for _, m := range messages {
+					got = append(got, string(m))
+				}
+
+				if !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("inject(%s) = %v, want %v", message, got, tt.want[i])
+				}
+
+				if (delay > 0) != tt.wantDelay || delay > time.Duration(tt.config.MaxDelay) {
+					t.Errorf("inject(%s) delay = %v, want delayed %v", message, delay, tt.wantDelay)
+				}
+			}
+
+			if got := f.Stats(); got != tt.wantStats {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestFaultInjector_inject_corrupt(t *testing.T) {
+	f, err := NewFaultInjector(FaultConfig{Seed: 1, Corrupt: 1})
+	if err != nil {
+		t.Fatalf("NewFaultInjector() error = %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		messages, _ := f.inject([]byte(codecTestMessage))
+		if len(messages) != 1 {
+			t.Fatalf("inject() = %v, want one message", messages)
+		}
+
+		got := string(messages[0])
+		if got == codecTestMessage || !strings.HasPrefix(codecTestMessage, got) {
+			t.Errorf("inject() = %q, want a truncated message", got)
+		}
+	}
+}
+
+func TestFaultInjector_inject_seed(t *testing.T) {
+	config := FaultConfig{Seed: 7, Drop: 0.3, Delay: 0.3, Duplicate: 0.3, Reorder: 0.3, Corrupt: 0.3}
+
+	run := func(config FaultConfig) ([]string, FaultStats) {
+		f, err := NewFaultInjector(config)
+		if err != nil {
+			t.Fatalf("NewFaultInjector() error = %v", err)
+		}
+
+		var got []string
+		for i := 0; i < 100; i++ {
+			messages, delay := f.inject([]byte(codecTestMessage))
+			for _, m := range messages {
+				got = append(got, string(m)+delay.String())
+			}
+		}
+
+		return got, f.Stats()
+	}
+
+	first, firstStats := run(config)
+	second, secondStats := run(config)
+
+	if !reflect.DeepEqual(first, second) || firstStats != secondStats {
+		t.Errorf("inject() with the same seed = %+v and %+v, want the same faults", firstStats, secondStats)
+	}
+
+	config.Seed = 8
+	if other, _ := run(config); reflect.DeepEqual(first, other) {
+		t.Errorf("inject() with another seed injected the same faults")
+	}
+}
+
+func TestLoadFaultConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faults.json")
+	data := `{"seed": 3, "drop": 0.1, "max_delay": "50ms", "disconnects": ["10s", "1m"]}`
+
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadFaultConfig(path)
+	if err != nil {
+		t.Fatalf("LoadFaultConfig() error = %v", err)
+	}
+
+	want := FaultConfig{
+		Seed:        3,
+		Drop:        0.1,
+		MaxDelay:    Duration(50 * time.Millisecond),
+		Disconnects: []Duration{Duration(10 * time.Second), Duration(time.Minute)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadFaultConfig() = %+v, want %+v", got, want)
+	}
+
+	if _, err := LoadFaultConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("LoadFaultConfig() of a missing file expected an error")
+	}
+}
+
+func TestClient_Connect_faults(t *testing.T) {
+	t.Cleanup(func() { goleak.VerifyNone(t) })
+
+	serverURL, stop := newStreamingServer(t)
+	t.Cleanup(stop)
+
+	faults, err := NewFaultInjector(FaultConfig{Disconnects: []Duration{Duration(30 * time.Millisecond)}})
+	if err != nil {
+		t.Fatalf("NewFaultInjector() error = %v", err)
+	}
+
+	c := NewClient(context.Background(), serverURL)
+	c.Faults = faults
+
+	disconnected := make(chan error, 1)
+	c.OnDisconnected = func(err error, client Client) {
+		disconnected <- err
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	select {
+	case err := <-disconnected:
+		if !errors.Is(err, ErrInjectedDisconnect) {
+			t.Errorf("OnDisconnected() error = %v, want %v", err, ErrInjectedDisconnect)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnDisconnected() not called")
+	}
+
+	if got := faults.Stats().Disconnected; got != 1 {
+		t.Errorf("Stats() disconnected = %v, want 1", got)
+	}
+
+	// The passed disconnect isn't injected again after reconnecting.
+	disconnected = make(chan error, 1)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	select {
+	case err := <-disconnected:
+		t.Errorf("OnDisconnected() error = %v after reconnecting, want none", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Close()
+}
+
+func TestClient_Connect_faults_delay(t *testing.T) {
+	t.Cleanup(func() { goleak.VerifyNone(t) })
+
+	faults, err := NewFaultInjector(FaultConfig{Delay: 1, MaxDelay: Duration(150 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("NewFaultInjector() error = %v", err)
+	}
+
+	// The delays are longer than the pong timeout, the pongs are still read while the messages are delayed.
+	c := NewClient(context.Background(), newKeepaliveServer(t, true, 10*time.Millisecond))
+	c.PingInterval = 20 * time.Millisecond
+	c.PongTimeout = 50 * time.Millisecond
+	c.Faults = faults
+
+	var received int32
+	c.OnReceivingMsg = func(message string, client Client) {
+		atomic.AddInt32(&received, 1)
+	}
+
+	disconnected := make(chan error, 1)
+	c.OnDisconnected = func(err error, client Client) {
+		if err != nil {
+			disconnected <- err
+		}
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	select {
+	case err := <-disconnected:
+		t.Errorf("OnDisconnected() error = %v while delaying the messages, want none", err)
+	case <-time.After(400 * time.Millisecond):
+	}
+
+	c.Close()
+
+	if atomic.LoadInt32(&received) == 0 {
+		t.Errorf("OnReceivingMsg() not called, want the delayed messages")
+	}
+}
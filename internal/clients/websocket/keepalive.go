@@ -0,0 +1,188 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// minKeepaliveTick is the shortest interval the keepalive checks the connection at.
+const minKeepaliveTick = 10 * time.Millisecond
+
+// keepalive pings the server of a connection and watches the pongs and the received messages. When a pong isn't
+// received in time, or no message is received for the idle timeout, it closes the connection so that the blocked read
+// fails, and the reader reports the TimeoutError returned by err.
+type keepalive struct {
+	conn         *websocket.Conn
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	idleTimeout  time.Duration
+	lastMessage  time.Time
+	lastPong     time.Time
+	pingSent     time.Time
+	deadErr      error
+	done         chan struct{}
+	exited       chan struct{}
+	stopOnce     sync.Once
+	mu           sync.Mutex
+	logger       *logrus.Logger
+}
+
+func newKeepalive(
+	conn *websocket.Conn,
+	pingInterval time.Duration,
+	pongTimeout time.Duration,
+	idleTimeout time.Duration,
+	logger *logrus.Logger,
+) *keepalive {
+	now := time.Now()
+
+	k := &keepalive{
+		conn:         conn,
+		pingInterval: pingInterval,
+		pongTimeout:  pongTimeout,
+		idleTimeout:  idleTimeout,
+		lastMessage:  now,
+		lastPong:     now,
+		pingSent:     now,
+		done:         make(chan struct{}),
+		exited:       make(chan struct{}),
+		logger:       logger,
+	}
+
+	if pingInterval <= 0 && idleTimeout <= 0 {
+		close(k.exited)
+		return k
+	}
+
+	conn.SetPongHandler(func(string) error {
+		k.mu.Lock()
+		k.lastPong = time.Now()
+		k.mu.Unlock()
+
+		return nil
+	})
+
+	go k.run()
+
+	return k
+}
+
+// tick returns the interval the connection is checked at, a fraction of the shortest configured duration.
+func (k *keepalive) tick() time.Duration {
+	tick := time.Duration(0)
+
+	for _, d := range []time.Duration{k.pingInterval, k.pongTimeout, k.idleTimeout} {
+		if d > 0 && (tick == 0 || d < tick) {
+			tick = d
+		}
+	}
+
+	tick /= 4
+	if tick < minKeepaliveTick {
+		tick = minKeepaliveTick
+	}
+
+	return tick
+}
+
+func (k *keepalive) run() {
+	defer close(k.exited)
+
+	ticker := time.NewTicker(k.tick())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case now := <-ticker.C:
+			if k.check(now) {
+				return
+			}
+		}
+	}
+}
+
+// check sends the due ping and marks the connection dead on a timeout, it returns true once the connection is dead.
+func (k *keepalive) check(now time.Time) bool {
+	k.mu.Lock()
+
+	var deadErr error
+
+	switch {
+	case k.pingInterval > 0 && k.pongTimeout > 0 && k.lastPong.Before(k.pingSent) &&
+		now.Sub(k.pingSent) > k.pongTimeout:
+		deadErr = &TimeoutError{Kind: TimeoutPong, Timeout: k.pongTimeout}
+	case k.idleTimeout > 0 && now.Sub(k.lastMessage) > k.idleTimeout:
+		deadErr = &TimeoutError{Kind: TimeoutIdle, Timeout: k.idleTimeout}
+	}
+
+	if deadErr != nil {
+		k.mu.Unlock()
+
+		k.logger.Warningf("Connection is dead: %s", deadErr)
+		k.drop(deadErr)
+
+		return true
+	}
+
+	// Only one ping is outstanding at a time, the next is sent once it's answered.
+	ping := k.pingInterval > 0 && !k.lastPong.Before(k.pingSent) && now.Sub(k.pingSent) >= k.pingInterval
+	if ping {
+		k.pingSent = now
+	}
+	k.mu.Unlock()
+
+	if ping {
+		err := k.conn.WriteControl(websocket.PingMessage, nil, now.Add(k.pingInterval))
+		if err != nil {
+			// The ping hasn't been sent, don't wait for its pong, a broken connection is reported by the reader.
+			k.logger.Errorf("ping: %s", err)
+
+			k.mu.Lock()
+			k.lastPong = now
+			k.mu.Unlock()
+		}
+	}
+
+	return false
+}
+
+// drop closes the connection so that the reader reports it with the error, the first error is kept.
+func (k *keepalive) drop(err error) {
+	k.mu.Lock()
+	if k.deadErr == nil {
+		k.deadErr = err
+	}
+	k.mu.Unlock()
+
+	_ = k.conn.Close()
+}
+
+// received records that a message has been received.
+func (k *keepalive) received() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.lastMessage = time.Now()
+}
+
+// err returns the error the connection has been dropped with, a TimeoutError or a failback, or nil.
+func (k *keepalive) err() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.deadErr
+}
+
+// stop stops the keepalive and waits for it, so that it doesn't ping the connection once stop returns.
+func (k *keepalive) stop() {
+	k.stopOnce.Do(func() {
+		close(k.done)
+	})
+
+	<-k.exited
+}
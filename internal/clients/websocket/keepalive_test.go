@@ -0,0 +1,215 @@
+//go:build all
+// +build all
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// newKeepaliveServer starts a websocket server that answers the pings when pong is set, and sends a message every
+// interval when it's not zero.
+func newKeepaliveServer(t *testing.T, pong bool, interval time.Duration) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if !pong {
+			conn.SetPingHandler(func(string) error { return nil })
+		}
+
+		done := make(chan struct{})
+		defer close(done)
+
+		if interval > 0 {
+			go func() {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-done:
+						return
+					case <-ticker.C:
+						if conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"heartbeat"}`)) != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestClient_keepalive(t *testing.T) {
+	tests := []struct {
+		name         string
+		pong         bool
+		interval     time.Duration
+		pingInterval time.Duration
+		pongTimeout  time.Duration
+		idleTimeout  time.Duration
+		wantKind     string
+	}{
+		// Add TestClient_keepalive test cases.
+		{
+			name:         "TestClient_keepalive pong timeout",
+			pong:         false,
+			pingInterval: 50 * time.Millisecond,
+			pongTimeout:  100 * time.Millisecond,
+			wantKind:     TimeoutPong,
+		},
+		{
+			name:         "TestClient_keepalive idle timeout",
+			pong:         true,
+			pingInterval: 50 * time.Millisecond,
+			pongTimeout:  100 * time.Millisecond,
+			idleTimeout:  200 * time.Millisecond,
+			wantKind:     TimeoutIdle,
+		},
+		{
+			name:         "TestClient_keepalive alive",
+			pong:         true,
+			interval:     20 * time.Millisecond,
+			pingInterval: 50 * time.Millisecond,
+			pongTimeout:  100 * time.Millisecond,
+			idleTimeout:  200 * time.Millisecond,
+		},
+		{
+			name:     "TestClient_keepalive disabled",
+			pong:     false,
+			wantKind: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(context.Background(), newKeepaliveServer(t, tt.pong, tt.interval))
+			c.PingInterval = tt.pingInterval
+			c.PongTimeout = tt.pongTimeout
+			c.IdleTimeout = tt.idleTimeout
+
+			disconnected := make(chan error, 1)
+			c.OnDisconnected = func(err error, client Client) {
+				disconnected <- err
+			}
+
+			err := c.Connect()
+			if err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+
+			select {
+			case err := <-disconnected:
+				var timeoutErr *TimeoutError
+				if tt.wantKind == "" || !errors.As(err, &timeoutErr) || timeoutErr.Kind != tt.wantKind {
+					t.Fatalf("OnDisconnected() error = %v, want a %q timeout", err, tt.wantKind)
+				}
+
+				if !errors.Is(err, ErrConnectionDead) {
+					t.Errorf("OnDisconnected() error = %v, want it to wrap ErrConnectionDead", err)
+				}
+
+				if c.IsConnected() {
+					t.Errorf("IsConnected = true after the connection is dead")
+				}
+			case <-time.After(time.Second):
+				if tt.wantKind != "" {
+					t.Fatalf("OnDisconnected() not called, want a %q timeout", tt.wantKind)
+				}
+
+				c.Close()
+			}
+		})
+	}
+}
+
+// pingErrorHook counts the failed pings logged while counting is set.
+type pingErrorHook struct {
+	counting int32
+	errors   int32
+}
+
+func (h *pingErrorHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel}
+}
+
+func (h *pingErrorHook) Fire(entry *logrus.Entry) error {
+	if atomic.LoadInt32(&h.counting) == 1 && strings.HasPrefix(entry.Message, "ping") {
+		atomic.AddInt32(&h.errors, 1)
+	}
+
+	return nil
+}
+
+func TestClient_keepalive_stopped(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	// The server drops every connection right away.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	hook := &pingErrorHook{}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(hook)
+
+	c := NewClient(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	c.SetLogger(logger)
+	c.PingInterval = minKeepaliveTick
+
+	disconnected := make(chan struct{})
+	c.OnDisconnected = func(err error, client Client) {
+		// The keepalive of the dropped connection must not ping it while it's being reported.
+		atomic.StoreInt32(&hook.counting, 1)
+		time.Sleep(10 * minKeepaliveTick)
+		atomic.StoreInt32(&hook.counting, 0)
+
+		close(disconnected)
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("OnDisconnected() not called")
+	}
+
+	if errors := atomic.LoadInt32(&hook.errors); errors != 0 {
+		t.Errorf("keepalive pinged the dropped connection %d times in OnDisconnected", errors)
+	}
+}
@@ -0,0 +1,207 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRateLimited is wrapped by the RateLimitError of a request that can't be sent right away without waiting.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrQueueFull is wrapped by the RateLimitError of a request that doesn't fit into the outbound queue.
+	ErrQueueFull = errors.New("outbound queue full")
+)
+
+// RateLimitError is the error of a request rejected by the rate limiter, it wraps ErrRateLimited or ErrQueueFull.
+// RetryAfter is the time until a token is available when nothing else is queued.
+type RateLimitError struct {
+	Err        error
+	QueueDepth int
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: %d queued, retry after %s", e.Err, e.QueueDepth, e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimiter is a token bucket limiting the outbound messages of one or more clients, e.g. the shards of a streamer
+// sharing a per IP limit. It refills Rate tokens per second up to Burst, and every message takes a token. The waiting
+// messages form an ordered queue: a message is sent only after the messages queued before it have been sent.
+type RateLimiter struct {
+	rate     float64
+	burst    float64
+	maxQueue int
+	tokens   float64
+	last     time.Time
+	queue    []chan struct{}
+	mu       sync.Mutex
+}
+
+// NewRateLimiter creates a limiter of rate messages per second with bursts of up to burst messages, at least 1, a
+// non-positive rate never refills the bucket. A positive maxQueue limits the number of the queued messages.
+func NewRateLimiter(rate float64, burst int, maxQueue int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		rate:     rate,
+		burst:    float64(burst),
+		maxQueue: maxQueue,
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// QueueDepth returns the number of the queued messages, including the one being sent.
+func (l *RateLimiter) QueueDepth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.queue)
+}
+
+// Wait waits until it's the turn of the message and a token is available, or the context is done. The returned
+// release must be called once the message has been sent, to let the next message go.
+func (l *RateLimiter) Wait(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+
+	if l.maxQueue > 0 && len(l.queue) >= l.maxQueue {
+		err := &RateLimitError{Err: ErrQueueFull, QueueDepth: len(l.queue), RetryAfter: l.delay()}
+		l.mu.Unlock()
+
+		return nil, err
+	}
+
+	turn := l.enqueue()
+	l.mu.Unlock()
+
+	release := func() { l.leave(turn) }
+
+	select {
+	case <-turn:
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+
+	for {
+		delay := l.take()
+		if delay == 0 {
+			return release, nil
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Allow takes a token without waiting, it fails with ErrRateLimited when a message is queued or no token is
+// available. The returned release must be called once the message has been sent.
+func (l *RateLimiter) Allow() (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+
+	if len(l.queue) > 0 || l.tokens < 1 {
+		return nil, &RateLimitError{Err: ErrRateLimited, QueueDepth: len(l.queue), RetryAfter: l.delay()}
+	}
+
+	l.tokens--
+	turn := l.enqueue()
+
+	return func() { l.leave(turn) }, nil
+}
+
+// enqueue appends a turn to the queue, the turn of the head of the queue is closed.
+func (l *RateLimiter) enqueue() chan struct{} {
+	turn := make(chan struct{})
+	l.queue = append(l.queue, turn)
+
+	if len(l.queue) == 1 {
+		close(turn)
+	}
+
+	return turn
+}
+
+// leave removes the turn from the queue, and gives the turn to the next message when it was the head.
+func (l *RateLimiter) leave(turn chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, queued := range l.queue {
+		if queued != turn {
+			continue
+		}
+
+		l.queue = append(l.queue[:i], l.queue[i+1:]...)
+
+		if i == 0 && len(l.queue) > 0 {
+			close(l.queue[0])
+		}
+
+		return
+	}
+}
+
+// take takes a token and returns 0, or returns the time until a token is available.
+func (l *RateLimiter) take() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	return l.delay()
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (l *RateLimiter) refill() {
+	now := time.Now()
+
+	if l.rate > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+
+	l.last = now
+}
+
+// delay returns the time until a token is available.
+func (l *RateLimiter) delay() time.Duration {
+	if l.tokens >= 1 {
+		return 0
+	}
+
+	if l.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if delay <= 0 {
+		delay = time.Nanosecond
+	}
+
+	return delay
+}
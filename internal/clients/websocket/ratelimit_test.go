@@ -0,0 +1,222 @@
+//go:build all
+// +build all
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	l := NewRateLimiter(20, 2, 0)
+	start := time.Now()
+
+	for i := 0; i < 4; i++ {
+		release, err := l.Wait(context.Background())
+		if err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+
+		release()
+	}
+
+	// The burst of 2 is immediate, the other 2 wait 50ms each.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Wait() took %v, want about 100ms", elapsed)
+	}
+}
+
+func TestRateLimiter_order(t *testing.T) {
+	l := NewRateLimiter(100, 1, 0)
+
+	// Hold the head of the queue while the messages are queued one after the other.
+	head, err := l.Allow()
+	if err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+
+	var (
+		sent []int
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			release, err := l.Wait(context.Background())
+			if err != nil {
+				t.Errorf("Wait() error = %v", err)
+				return
+			}
+
+			mu.Lock()
+			sent = append(sent, i)
+			mu.Unlock()
+
+			release()
+		}(i)
+
+		for l.QueueDepth() < i+2 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	head()
+	wg.Wait()
+
+	want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent = %v, want %v", sent, want)
+	}
+
+	if l.QueueDepth() != 0 {
+		t.Errorf("QueueDepth() = %d, want 0", l.QueueDepth())
+	}
+}
+
+func TestRateLimiter_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     func(l *RateLimiter) error
+		wantErr error
+	}{
+		// Add TestRateLimiter_errors test cases.
+		{
+			name: "TestRateLimiter_errors rate limited",
+			run: func(l *RateLimiter) error {
+				release, err := l.Allow()
+				if err != nil {
+					return err
+				}
+				release()
+
+				_, err = l.Allow()
+
+				return err
+			},
+			wantErr: ErrRateLimited,
+		},
+		{
+			name: "TestRateLimiter_errors queue full",
+			run: func(l *RateLimiter) error {
+				release, err := l.Wait(context.Background())
+				if err != nil {
+					return err
+				}
+				defer release()
+
+				_, err = l.Wait(context.Background())
+
+				return err
+			},
+			wantErr: ErrQueueFull,
+		},
+		{
+			name: "TestRateLimiter_errors cancelled",
+			run: func(l *RateLimiter) error {
+				release, err := l.Allow()
+				if err != nil {
+					return err
+				}
+				release()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+				defer cancel()
+
+				_, err = l.Wait(ctx)
+
+				return err
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(1, 1, 1)
+
+			err := tt.run(l)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			var rateLimitErr *RateLimitError
+			if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter <= 0 {
+				t.Errorf("RetryAfter = %v, want positive", rateLimitErr.RetryAfter)
+			}
+
+			if l.QueueDepth() != 0 {
+				t.Errorf("QueueDepth() = %d, want 0", l.QueueDepth())
+			}
+		})
+	}
+}
+
+func TestClient_SendRequest_rateLimit(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	received := make(chan string, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			received <- string(message)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	c.RateLimiter = NewRateLimiter(50, 1, 0)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.TrySendRequest("1"); err != nil {
+		t.Fatalf("TrySendRequest() error = %v", err)
+	}
+
+	if err := c.TrySendRequest("2"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("TrySendRequest() error = %v, want %v", err, ErrRateLimited)
+	}
+
+	for _, message := range []string{"3", "4"} {
+		if err := c.SendRequest(message); err != nil {
+			t.Fatalf("SendRequest() error = %v", err)
+		}
+	}
+
+	for _, want := range []string{"1", "3", "4"} {
+		if got := <-received; got != want {
+			t.Errorf("received %v, want %v", got, want)
+		}
+	}
+
+	if c.QueueDepth() != 0 {
+		t.Errorf("QueueDepth() = %d, want 0", c.QueueDepth())
+	}
+}
@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// State is the state of the connection of a client.
+type State int32
+
+const (
+	// StateIdle is the state of a client that has never connected.
+	StateIdle State = iota
+	// StateDialing is the state while the connection is being established.
+	StateDialing
+	// StateConnected is the state of an open connection.
+	StateConnected
+	// StateClosing is the state while the connection is being closed by Close.
+	StateClosing
+	// StateClosed is the state after the connection has been closed, or has failed to be established.
+	StateClosed
+	// StateReconnecting is the state between the reconnect attempts after the connection has dropped.
+	StateReconnecting
+)
+
+var stateNames = map[State]string{
+	StateIdle:         "idle",
+	StateDialing:      "dialing",
+	StateConnected:    "connected",
+	StateClosing:      "closing",
+	StateClosed:       "closed",
+	StateReconnecting: "reconnecting",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// ErrInvalidState is returned when an operation isn't allowed in the current state, e.g. connecting twice.
+var ErrInvalidState = errors.New("invalid connection state")
+
+// StateChange is a transition of the connection state, Err is the error that caused it, if any. Endpoint is the url
+// connected to by the last transition to StateConnected.
+type StateChange struct {
+	From     State
+	To       State
+	Err      error
+	Endpoint string
+	Time     time.Time
+}
+
+// stateMachine holds the connection state of a client and its subscribers. It's shared by the copies of the client
+// passed to the callbacks, so that they all observe the same state.
+type stateMachine struct {
+	state       State
+	endpoint    string
+	subscribers map[int]func(change StateChange)
+	nextID      int
+	mu          sync.Mutex
+}
+
+func newStateMachine() *stateMachine {
+	return &stateMachine{subscribers: make(map[int]func(change StateChange))}
+}
+
+func (m *stateMachine) current() State {
+	if m == nil {
+		return StateIdle
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+// transition moves to the state when the current state is one of from, and notifies the subscribers. It returns the
+// previous state and whether the transition happened.
+func (m *stateMachine) transition(to State, err error, from ...State) (State, bool) {
+	m.mu.Lock()
+
+	previous := m.state
+
+	allowed := false
+	for _, state := range from {
+		if previous == state {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		m.mu.Unlock()
+		return previous, false
+	}
+
+	m.state = to
+	endpoint := m.endpoint
+
+	subscribers := make([]func(change StateChange), 0, len(m.subscribers))
+	for id := 0; id < m.nextID; id++ {
+		if subscriber, ok := m.subscribers[id]; ok {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+	m.mu.Unlock()
+
+	change := StateChange{From: previous, To: to, Err: err, Endpoint: endpoint, Time: time.Now()}
+	for _, subscriber := range subscribers {
+		subscriber(change)
+	}
+
+	return previous, true
+}
+
+func (m *stateMachine) setEndpoint(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.endpoint = endpoint
+}
+
+func (m *stateMachine) subscribe(subscriber func(change StateChange)) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.nextID
+	m.nextID++
+	m.subscribers[id] = subscriber
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		delete(m.subscribers, id)
+	}
+}
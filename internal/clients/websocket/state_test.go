@@ -0,0 +1,274 @@
+//go:build all
+// +build all
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// stateRecorder records the states a client transitions to.
+type stateRecorder struct {
+	states []State
+	mu     sync.Mutex
+}
+
+func (r *stateRecorder) record(change StateChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.states = append(r.states, change.To)
+}
+
+func (r *stateRecorder) get() []State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]State{}, r.states...)
+}
+
+// newStateServer starts a websocket server that closes every connection once it receives a message.
+func newStateServer(t *testing.T) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _, _ = conn.ReadMessage()
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestClient_State(t *testing.T) {
+	serverURL := newStateServer(t)
+
+	tests := []struct {
+		name       string
+		url        string
+		run        func(c *Client) error
+		wantStates []State
+		wantErr    error
+	}{
+		// Add TestClient_State test cases.
+		{
+			name: "TestClient_State close",
+			url:  serverURL,
+			run: func(c *Client) error {
+				err := c.Connect()
+				c.Close()
+				c.Close()
+
+				return err
+			},
+			wantStates: []State{StateDialing, StateConnected, StateClosing, StateClosed},
+		},
+		{
+			name: "TestClient_State dropped",
+			url:  serverURL,
+			run: func(c *Client) error {
+				disconnected := make(chan struct{})
+				c.OnDisconnected = func(err error, client Client) {
+					close(disconnected)
+				}
+
+				err := c.Connect()
+				if err != nil {
+					return err
+				}
+
+				_ = c.SendRequest(ReqString)
+				<-disconnected
+
+				return nil
+			},
+			wantStates: []State{StateDialing, StateConnected, StateClosed},
+		},
+		{
+			name: "TestClient_State connect error",
+			url:  "ws://127.0.0.1:1",
+			run: func(c *Client) error {
+				if c.Connect() == nil {
+					return errors.New("connected to a closed port")
+				}
+
+				return nil
+			},
+			wantStates: []State{StateDialing, StateClosed},
+		},
+		{
+			name: "TestClient_State reconnecting",
+			url:  "ws://127.0.0.1:1",
+			run: func(c *Client) error {
+				c.SetReconnecting(true)
+				_ = c.Connect()
+				c.SetReconnecting(false)
+
+				return nil
+			},
+			wantStates: []State{StateReconnecting, StateDialing, StateReconnecting, StateClosed},
+		},
+		{
+			name: "TestClient_State send while reconnecting",
+			url:  serverURL,
+			run: func(c *Client) error {
+				disconnected := make(chan struct{})
+				c.OnDisconnected = func(err error, client Client) {
+					close(disconnected)
+				}
+
+				err := c.Connect()
+				if err != nil {
+					return err
+				}
+
+				_ = c.SendRequest(ReqString)
+				<-disconnected
+
+				// The failed reconnect leaves no connection to send to.
+				c.SetReconnecting(true)
+				c.URL = "ws://127.0.0.1:1"
+				_ = c.Connect()
+
+				return c.SendRequest(ReqString)
+			},
+			wantStates: []State{
+				StateDialing,
+				StateConnected,
+				StateClosed,
+				StateReconnecting,
+				StateDialing,
+				StateReconnecting,
+			},
+			wantErr: ErrInvalidState,
+		},
+		{
+			name: "TestClient_State connect twice",
+			url:  serverURL,
+			run: func(c *Client) error {
+				err := c.Connect()
+				if err != nil {
+					return err
+				}
+				defer c.Close()
+
+				return c.Connect()
+			},
+			wantStates: []State{StateDialing, StateConnected, StateClosing, StateClosed},
+			wantErr:    ErrInvalidState,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(context.Background(), tt.url)
+
+			recorder := &stateRecorder{}
+			unsubscribe := c.SubscribeState(recorder.record)
+
+			err := tt.run(c)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			// The transitions after unsubscribing aren't recorded.
+			unsubscribe()
+			c.SetReconnecting(true)
+
+			if got := recorder.get(); !reflect.DeepEqual(got, tt.wantStates) {
+				t.Errorf("states = %v, want %v", got, tt.wantStates)
+			}
+		})
+	}
+}
+
+func TestState_String(t *testing.T) {
+	tests := []struct {
+		name  string
+		state State
+		want  string
+	}{
+		// Add TestState_String test cases.
+		{name: "TestState_String idle", state: StateIdle, want: "idle"},
+		{name: "TestState_String reconnecting", state: StateReconnecting, want: "reconnecting"},
+		{name: "TestState_String unknown", state: State(42), want: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_snapshot_reconnect(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	// The server drops the first connection right away and keeps the next ones open.
+	var connections int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if atomic.AddInt32(&connections, 1) == 1 {
+			return
+		}
+
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	c := NewClient(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	c.SetLogger(logger)
+
+	// The owner reconnects as soon as the connection is closed, while the dropped connection is still being reported.
+	reconnected := make(chan error, 1)
+	c.SubscribeState(func(change StateChange) {
+		if change.To == StateClosed && change.Err != nil {
+			go func() { reconnected <- c.Connect() }()
+		}
+	})
+
+	disconnected := make(chan *websocket.Conn, 1)
+	c.OnDisconnected = func(err error, socket Client) {
+		if err != nil {
+			disconnected <- socket.Conn
+		}
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if err := <-reconnected; err != nil {
+		t.Fatalf("Connect() reconnect error = %v", err)
+	}
+	defer c.Close()
+
+	if conn := <-disconnected; conn == nil {
+		t.Errorf("OnDisconnected() client.Conn = nil, want a connection")
+	}
+}
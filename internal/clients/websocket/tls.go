@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DefaultTLSMinVersion is the default minimum TLS version of the wss connections.
+const DefaultTLSMinVersion = tls.VersionTLS12
+
+// ErrNoCertificates is returned when a CA file doesn't contain any PEM certificate.
+var ErrNoCertificates = errors.New("no certificates found")
+
+// TLSOptions is the TLS configuration of the wss connections. The server certificate is verified against the system
+// roots by default, RootCAs and CAFile replace them with custom roots. The client certificate of mutual TLS is given
+// either as Certificates or as the CertFile and KeyFile PEM files.
+type TLSOptions struct {
+	// InsecureSkipVerify disables the verification of the server certificate, only for testing.
+	InsecureSkipVerify bool
+	RootCAs            *x509.CertPool
+	CAFile             string
+	Certificates       []tls.Certificate
+	CertFile           string
+	KeyFile            string
+	// ServerName overrides the name sent as SNI and verified against the server certificate, the host of the url by
+	// default.
+	ServerName string
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS13, DefaultTLSMinVersion when it's zero.
+	MinVersion uint16
+}
+
+// Config builds the tls.Config of the options, loading the CA and the client certificate files.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		RootCAs:            o.RootCAs,
+		Certificates:       append([]tls.Certificate{}, o.Certificates...),
+		ServerName:         o.ServerName,
+		MinVersion:         o.MinVersion,
+	}
+
+	if config.MinVersion == 0 {
+		config.MinVersion = DefaultTLSMinVersion
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// The caller's pool is cloned, since Config is called on every connect and the certificates would be appended
+		// to it again.
+		if config.RootCAs == nil {
+			config.RootCAs = x509.NewCertPool()
+		} else {
+			config.RootCAs = config.RootCAs.Clone()
+		}
+
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w in %s", ErrNoCertificates, o.CAFile)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = append(config.Certificates, certificate)
+	}
+
+	return config, nil
+}
+
+// ParseTLSVersion parses a TLS version given as "1.0", "1.1", "1.2" or "1.3".
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown tls version %q", version)
+	}
+}
@@ -0,0 +1,311 @@
+//go:build all
+// +build all
+
+package websocket
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testPKI is a self-signed CA with a server certificate of fake.local and 127.0.0.1, and a client certificate.
+type testPKI struct {
+	caPool     *x509.CertPool
+	caFile     string
+	server     tls.Certificate
+	client     tls.Certificate
+	clientCert string
+	clientKey  string
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	caKey, caTemplate := newTestKey(t), &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pki := testPKI{caPool: x509.NewCertPool(), caFile: filepath.Join(dir, "ca.pem")}
+	pki.caPool.AddCert(caCert)
+	writeTestPEM(t, pki.caFile, "CERTIFICATE", caDER)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage, certFile string, keyFile string) tls.Certificate {
+		key := newTestKey(t)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "fake.local"},
+			DNSNames:     []string{"fake.local"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeTestPEM(t, certFile, "CERTIFICATE", der)
+		writeTestPEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return certificate
+	}
+
+	pki.server = issue(2, x509.ExtKeyUsageServerAuth, filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	pki.clientCert, pki.clientKey = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	pki.client = issue(3, x509.ExtKeyUsageClientAuth, pki.clientCert, pki.clientKey)
+
+	return pki
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func writeTestPEM(t *testing.T, path string, blockType string, der []byte) {
+	t.Helper()
+
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTLSServer starts a wss echo server with the server certificate of the pki.
+func newTLSServer(t *testing.T, pki testPKI, config *tls.Config) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+
+	config.Certificates = []tls.Certificate{pki.server}
+	server.TLS = config
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return "wss" + strings.TrimPrefix(server.URL, "https")
+}
+
+func TestClient_Connect_tls(t *testing.T) {
+	pki := newTestPKI(t)
+
+	serverURL := newTLSServer(t, pki, &tls.Config{})
+	tls12URL := newTLSServer(t, pki, &tls.Config{MaxVersion: tls.VersionTLS12})
+	mtlsURL := newTLSServer(t, pki, &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pki.caPool})
+
+	tests := []struct {
+		name    string
+		url     string
+		options TLSOptions
+		wantErr bool
+	}{
+		// Add TestClient_Connect_tls test cases.
+		{
+			name:    "TestClient_Connect_tls verified by default",
+			url:     serverURL,
+			options: TLSOptions{},
+			wantErr: true,
+		},
+		{
+			name:    "TestClient_Connect_tls ca file",
+			url:     serverURL,
+			options: TLSOptions{CAFile: pki.caFile},
+		},
+		{
+			name:    "TestClient_Connect_tls root cas",
+			url:     serverURL,
+			options: TLSOptions{RootCAs: pki.caPool},
+		},
+		{
+			name:    "TestClient_Connect_tls insecure skip verify",
+			url:     serverURL,
+			options: TLSOptions{InsecureSkipVerify: true},
+		},
+		{
+			name:    "TestClient_Connect_tls server name",
+			url:     serverURL,
+			options: TLSOptions{RootCAs: pki.caPool, ServerName: "fake.local"},
+		},
+		{
+			name:    "TestClient_Connect_tls wrong server name",
+			url:     serverURL,
+			options: TLSOptions{RootCAs: pki.caPool, ServerName: "other.local"},
+			wantErr: true,
+		},
+		{
+			name:    "TestClient_Connect_tls min version",
+			url:     tls12URL,
+			options: TLSOptions{RootCAs: pki.caPool, MinVersion: tls.VersionTLS13},
+			wantErr: true,
+		},
+		{
+			name:    "TestClient_Connect_tls default min version",
+			url:     tls12URL,
+			options: TLSOptions{RootCAs: pki.caPool},
+		},
+		{
+			name:    "TestClient_Connect_tls mtls without certificate",
+			url:     mtlsURL,
+			options: TLSOptions{RootCAs: pki.caPool},
+			wantErr: true,
+		},
+		{
+			name:    "TestClient_Connect_tls mtls certificate files",
+			url:     mtlsURL,
+			options: TLSOptions{RootCAs: pki.caPool, CertFile: pki.clientCert, KeyFile: pki.clientKey},
+		},
+		{
+			name:    "TestClient_Connect_tls mtls certificates",
+			url:     mtlsURL,
+			options: TLSOptions{RootCAs: pki.caPool, Certificates: []tls.Certificate{pki.client}},
+		},
+		{
+			name:    "TestClient_Connect_tls invalid ca file",
+			url:     serverURL,
+			options: TLSOptions{CAFile: pki.clientKey},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(context.Background(), tt.url)
+			c.ConnectionOptions.TLS = tt.options
+
+			var connectErr error
+			c.OnConnectError = func(err error, client Client) {
+				connectErr = err
+			}
+
+			err := c.Connect()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Connect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				if connectErr != err {
+					t.Errorf("OnConnectError() error = %v, want %v", connectErr, err)
+				}
+
+				return
+			}
+
+			if err := c.SendRequest(ReqString); err != nil {
+				t.Errorf("SendRequest() error = %v", err)
+			}
+
+			c.Close()
+		})
+	}
+}
+
+func TestTLSOptions_Config_rootCAs(t *testing.T) {
+	pki := newTestPKI(t)
+
+	pool := x509.NewCertPool()
+	options := TLSOptions{RootCAs: pool, CAFile: pki.caFile}
+
+	// The CA file is appended to a copy of the caller's pool on every call.
+	for i := 0; i < 2; i++ {
+		config, err := options.Config()
+		if err != nil {
+			t.Fatalf("Config() error = %v", err)
+		}
+
+		if config.RootCAs == pool || config.RootCAs.Equal(pool) {
+			t.Errorf("Config() RootCAs is the caller's pool, want a copy with the ca file")
+		}
+	}
+
+	if !pool.Equal(x509.NewCertPool()) {
+		t.Errorf("Config() appended the ca file to the caller's pool")
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		// Add TestParseTLSVersion test cases.
+		{name: "TestParseTLSVersion 1.2", version: "1.2", want: tls.VersionTLS12},
+		{name: "TestParseTLSVersion 1.3", version: "1.3", want: tls.VersionTLS13},
+		{name: "TestParseTLSVersion unknown", version: "2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTLSVersion(tt.version)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseTLSVersion() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,19 +2,56 @@ package websocket
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// DefaultPingInterval is the default interval between the pings sent to the server.
+	DefaultPingInterval = 30 * time.Second
+	// DefaultPongTimeout is the default time a pong is waited for after a ping.
+	DefaultPongTimeout = 10 * time.Second
+)
+
+const (
+	TimeoutPong = "pong"
+	TimeoutIdle = "idle"
+)
+
+// ErrConnectionDead is wrapped by the errors the connection is reported dead with by the keepalive.
+var ErrConnectionDead = errors.New("connection is dead")
+
+// TimeoutError is the error passed to OnDisconnected when the keepalive marks the connection dead, either because a
+// ping hasn't been answered or because no message has been received for the idle timeout. It wraps
+// ErrConnectionDead.
+type TimeoutError struct {
+	Kind    string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Kind == TimeoutPong {
+		return fmt.Sprintf("no pong received within %s of the ping", e.Timeout)
+	}
+
+	return fmt.Sprintf("no message received for %s", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return ErrConnectionDead
+}
+
 // Client is a generic websocket client that build on top of gorilla/websocket package. It implements the event driven
-// pattern and provides a set of simple functions to receive messages.
+// pattern and provides a set of simple functions to receive messages. The callbacks get a snapshot of the client, which
+// a reconnect doesn't change under them.
 type Client struct {
 	Ctx               context.Context
 	Conn              *websocket.Conn
@@ -24,20 +61,49 @@ type Client struct {
 	RequestHeader     http.Header
 	OnConnected       func(client Client)
 	OnReceivingMsg    func(message string, client Client)
+	OnReceivingData   func(data []byte, client Client)
 	OnConnectError    func(err error, client Client)
-	OnDisconnected    func(err error, client Client)
-	IsConnected       bool
-	Timeout           time.Duration
-	sendMu            *sync.Mutex
-	receiveMu         *sync.Mutex
-	logger            *logrus.Logger
+	// OnDisconnected is called when the connection drops, with a TimeoutError when the keepalive marked it dead, or
+	// with a nil error when it's closed by Close.
+	OnDisconnected func(err error, client Client)
+	Timeout        time.Duration
+	// PingInterval is the interval the server is pinged at, the connection is dead when the pong isn't received within
+	// PongTimeout. A zero PingInterval disables the pings.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+	// IdleTimeout marks the connection dead when no message, the pongs excluded, is received for it, zero disables it.
+	IdleTimeout time.Duration
+	// Endpoints is the prioritized list of the urls to fail over, URL is the only endpoint when it's empty. Connect
+	// tries the healthy endpoints first, and a dropped endpoint is rotated away from on the reconnect.
+	Endpoints []string
+	// FailbackInterval is the interval the preferred endpoints are probed at while connected to a less preferred one,
+	// the connection is dropped with ErrFailback once one of them has recovered.
+	FailbackInterval time.Duration
+	// Codec decodes the frames into the messages passed to OnReceivingData and OnReceivingMsg, TextCodec by default.
+	Codec Codec
+	// RateLimiter paces the requests sent when it's set, it can be shared by several clients.
+	RateLimiter *RateLimiter
+	// Faults injects faults into the inbound messages and forces disconnects when it's set, for testing the reconnects
+	// and the gap handling of the streamers.
+	Faults     *FaultInjector
+	receivedAt time.Time
+	endpoints  *endpointPool
+	state      *stateMachine
+	closing    *int32
+	readerDone chan struct{}
+	sendMu     *sync.Mutex
+	receiveMu  *sync.Mutex
+	logger     *logrus.Logger
 }
 
+// ConnOptions are the options of the connection. The wss urls are connected to over TLS configured by TLS, UseSSL is
+// kept for compatibility and has no effect.
 type ConnOptions struct {
 	UseCompression bool
 	UseSSL         bool
 	Proxy          func(*http.Request) (*url.URL, error)
 	SubProtocols   []string
+	TLS            TLSOptions
 }
 
 func NewClient(ctx context.Context, wsURL string) *Client {
@@ -49,11 +115,16 @@ func NewClient(ctx context.Context, wsURL string) *Client {
 			UseCompression: false,
 			UseSSL:         true,
 		},
-		WebsocketDialer: &websocket.Dialer{},
-		Timeout:         0,
-		sendMu:          &sync.Mutex{},
-		receiveMu:       &sync.Mutex{},
-		logger:          logrus.New(),
+		WebsocketDialer:  &websocket.Dialer{},
+		Timeout:          0,
+		PingInterval:     DefaultPingInterval,
+		PongTimeout:      DefaultPongTimeout,
+		FailbackInterval: DefaultFailbackInterval,
+		endpoints:        newEndpointPool(),
+		state:            newStateMachine(),
+		sendMu:           &sync.Mutex{},
+		receiveMu:        &sync.Mutex{},
+		logger:           logrus.New(),
 	}
 }
 
@@ -61,115 +132,397 @@ func (c *Client) SetLogger(logger *logrus.Logger) {
 	c.logger = logger
 }
 
-func (c *Client) setConnectionOptions() {
+// stateMachine returns the state machine of the client, creating it for the clients not created by NewClient.
+func (c *Client) stateMachine() *stateMachine {
+	if c.state == nil {
+		c.state = newStateMachine()
+	}
+
+	return c.state
+}
+
+// State returns the current state of the connection, its transitions can be observed with SubscribeState.
+func (c *Client) State() State {
+	return c.state.current()
+}
+
+// IsConnected returns whether the connection is open.
+func (c *Client) IsConnected() bool {
+	return c.State() == StateConnected
+}
+
+// ReceivedAt returns the time the frame of the message was read, before it was decoded. It's only set on the client
+// passed to OnReceivingData and OnReceivingMsg, it's zero otherwise.
+func (c *Client) ReceivedAt() time.Time {
+	return c.receivedAt
+}
+
+// SubscribeState calls the subscriber on every transition of the connection state, until the returned function is
+// called. The subscriber is called synchronously on the goroutine making the transition, so it mustn't block, and it
+// mustn't connect or close the client.
+func (c *Client) SubscribeState(subscriber func(change StateChange)) func() {
+	return c.stateMachine().subscribe(subscriber)
+}
+
+// SetReconnecting marks a closed connection as reconnecting while the owner of the client retries to connect, and
+// marks it back as closed once the owner gives up.
+func (c *Client) SetReconnecting(reconnecting bool) {
+	if reconnecting {
+		c.stateMachine().transition(StateReconnecting, nil, StateIdle, StateClosed)
+	} else {
+		c.stateMachine().transition(StateClosed, nil, StateReconnecting)
+	}
+}
+
+func (c *Client) setConnectionOptions() error {
+	tlsConfig, err := c.ConnectionOptions.TLS.Config()
+	if err != nil {
+		return err
+	}
+
 	c.WebsocketDialer.EnableCompression = c.ConnectionOptions.UseCompression
-	c.WebsocketDialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.ConnectionOptions.UseSSL}
+	c.WebsocketDialer.TLSClientConfig = tlsConfig
 	c.WebsocketDialer.Proxy = c.ConnectionOptions.Proxy
 	c.WebsocketDialer.Subprotocols = c.ConnectionOptions.SubProtocols
+
+	return nil
 }
 
 // Connect connects to the websocket server with a given request message, it then pipes the incoming messages to the
-// OnReceivingMsg callback receiver for further processing.
+// OnReceivingMsg callback receiver for further processing. The dial is cancelled when the Ctx of the client is done.
 func (c *Client) Connect() error {
+	return c.ConnectContext(c.context())
+}
+
+// ConnectContext is Connect with a context bounding the dial only, e.g. with a dial deadline. The connection itself
+// lives until the Ctx of the client is done, which closes it like Close.
+func (c *Client) ConnectContext(ctx context.Context) error {
 	var (
 		err    error
-		resp   *http.Response
 		logger = c.logger
 	)
 
-	c.setConnectionOptions()
+	state := c.stateMachine()
+
+	previous, ok := state.transition(StateDialing, nil, StateIdle, StateClosed, StateReconnecting)
+	if !ok {
+		return fmt.Errorf("%w: connect while %s", ErrInvalidState, previous)
+	}
+
+	var conn *websocket.Conn
+
+	active := -1
 
-	// Connect to the websocket server.
-	c.Conn, resp, err = c.WebsocketDialer.Dial(c.URL, c.RequestHeader)
+	err = c.setConnectionOptions()
+	if err == nil {
+		// Connect to the websocket server, failing over the endpoints.
+		conn, active, err = c.dialEndpoints(ctx)
+	}
 
 	if err != nil {
 		logger.Errorf("Error connecting to websocket: %s", err)
-		if resp != nil {
-			logger.Errorf("HTTP Response %d status: %s", resp.StatusCode, resp.Status)
-		}
 
-		c.IsConnected = false
+		// A failed reconnect attempt is still reconnecting.
+		if previous == StateReconnecting {
+			state.transition(StateReconnecting, err, StateDialing)
+		} else {
+			state.transition(StateClosed, err, StateDialing)
+		}
 
 		// Set the OnConnectError callback.
 		if c.OnConnectError != nil {
-			c.OnConnectError(err, *c)
+			c.OnConnectError(err, c.snapshot())
 		}
 
 		return err
 	}
 
+	// The closing flag and the reader's done channel belong to this connection only, a reconnect gets new ones. They're
+	// set before the transition, which publishes them to Close.
+	closing := new(int32)
+	readerDone := make(chan struct{})
+
+	// The connection is only replaced once the dial has succeeded, under the send lock so that a concurrent send or
+	// snapshot sees either the previous connection or this one.
+	c.sendMu.Lock()
+	c.Conn = conn
+	c.closing = closing
+	c.readerDone = readerDone
+	c.sendMu.Unlock()
+
+	endpoint := c.endpointPool().url(active)
+	state.setEndpoint(endpoint)
+	state.transition(StateConnected, nil, StateDialing)
+
 	if c.OnConnected != nil {
-		c.IsConnected = true
-		c.OnConnected(*c)
+		c.OnConnected(c.snapshot())
 	}
 
-	logger.Infoln("Connected to server")
+	logger.Infof("Connected to server %s", endpoint)
 
-	// Set the OnDisconnected callback.
-	defaultCloseHandler := c.Conn.CloseHandler()
-	c.Conn.SetCloseHandler(func(code int, text string) error {
+	// Log the close frame, the disconnect itself is reported by the reader once the read fails.
+	defaultCloseHandler := conn.CloseHandler()
+	conn.SetCloseHandler(func(code int, text string) error {
 		result := defaultCloseHandler(code, text)
-		logger.Warning("Disconnected from server ", result)
-		if c.OnDisconnected != nil {
-			c.IsConnected = false
-			c.OnDisconnected(errors.New(text), *c)
-		}
+		logger.Warningf("Disconnected from server %d %s", code, text)
 
 		return result
 	})
 
+	keepalive := newKeepalive(conn, c.PingInterval, c.PongTimeout, c.IdleTimeout, logger)
+
+	codec := c.Codec
+	if codec == nil {
+		codec = TextCodec{}
+	}
+
+	go c.closeOnDone(c.context(), readerDone)
+
+	faults := c.Faults
+	if faults != nil {
+		faults.connected(time.Now())
+		go c.injectDisconnects(c.context(), faults, keepalive, readerDone)
+	}
+
+	if active > 0 && c.FailbackInterval > 0 {
+		go c.failback(c.context(), active, keepalive, readerDone)
+	}
+
+	// Pipe the response messages to the OnReceivingData and OnReceivingMsg callback receivers.
+	deliver := func(messages [][]byte, receivedAt time.Time) {
+		client := c.snapshot()
+		client.receivedAt = receivedAt
+
+		c.receiveMu.Lock()
+		defer c.receiveMu.Unlock()
+
+		for _, message := range messages {
+			if c.OnReceivingData != nil {
+				c.OnReceivingData(message, client)
+			}
+
+			if c.OnReceivingMsg != nil {
+				c.OnReceivingMsg(string(message), client)
+			}
+		}
+	}
+
+	// The injected messages are delivered by the delivery queue, which is stopped before the disconnect is reported,
+	// so that no message is delivered after it.
+	var queue *deliveryQueue
+	if faults != nil {
+		queue = newDeliveryQueue(func(d delivery) {
+			// Closed while delaying, the messages are dropped with the connection.
+			if atomic.LoadInt32(closing) == 1 {
+				return
+			}
+
+			deliver(d.messages, d.receivedAt)
+		})
+	}
+
+	// stop stops the keepalive and the delivery of the connection, before it's reported to OnDisconnected, so that
+	// they don't outlive it into the reconnect.
+	stop := func() {
+		keepalive.stop()
+
+		if queue != nil {
+			queue.stop()
+		}
+	}
+
 	go func() {
+		defer close(readerDone)
+		defer stop()
+
 		for {
-			c.receiveMu.Lock()
 			if c.Timeout != 0 {
-				err := c.Conn.SetReadDeadline(time.Now().Add(c.Timeout))
+				err := conn.SetReadDeadline(time.Now().Add(c.Timeout))
 				if err != nil {
 					logger.Errorf("Error setting read deadline: %s", err)
+					stop()
+					c.disconnect(conn, err)
 
 					return
 				}
 			}
 
-			messageType, message, err := c.Conn.ReadMessage()
-			if messageType == -1 {
-				logger.Errorf("Error reading message: %s", err)
-				c.Close()
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				// The connection has been closed by Close, it has already been reported.
+				if atomic.LoadInt32(closing) == 1 {
+					return
+				}
 
-				return
-			}
+				// The keepalive closed the dead connection, or the connection failed back, report why instead of the
+				// read error.
+				if deadErr := keepalive.err(); deadErr != nil {
+					err = deadErr
+				}
 
-			if err != nil {
-				logger.Errorf("read: %s", err)
-				if c.OnDisconnected != nil {
-					c.IsConnected = false
+				// The endpoint is rotated away from on the reconnect, unless a preferred one has recovered.
+				if !errors.Is(err, ErrFailback) {
+					c.endpointPool().failed(active, err)
 				}
 
-				c.OnDisconnected(err, *c)
+				logger.Errorf("read: %s", err)
+				stop()
+				c.disconnect(conn, err)
 
 				return
 			}
 
-			// Pipe the response message to the OnReceivingMsg callback receiver.
-			if websocket.TextMessage == messageType && c.OnReceivingMsg != nil {
-				c.OnReceivingMsg(string(message), *c)
+			receivedAt := time.Now()
+			keepalive.received()
+
+			message, err = codec.Decode(messageType, message)
+			if errors.Is(err, ErrSkipFrame) {
+				continue
+			}
+
+			if err != nil {
+				logger.Errorf("Error decoding message: %s", err)
+				continue
 			}
 
-			c.receiveMu.Unlock()
+			if queue == nil {
+				deliver([][]byte{message}, receivedAt)
+				continue
+			}
+
+			messages, delay := faults.inject(message)
+			if len(messages) > 0 {
+				queue.push(delivery{messages: messages, receivedAt: receivedAt, at: receivedAt.Add(delay)})
+			}
 		}
 	}()
 
 	return nil
 }
 
-// Send is a proxy function of WriteMessage, it sends a message to the websocket server.
+// contextError wraps a dial error into the error of the context when the context has ended the dial. The dialer
+// reports a passed deadline as an i/o timeout, possibly before the context itself has expired.
+func contextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+
+	if deadline, ok := ctx.Deadline(); ctxErr == nil && ok && !time.Now().Before(deadline) {
+		ctxErr = context.DeadlineExceeded
+	}
+
+	if ctxErr == nil || errors.Is(err, ctxErr) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", ctxErr, err)
+}
+
+// snapshot returns the copy of the client passed to the callbacks. It's copied under the send lock, since a reconnect
+// replaces the connection of the client concurrently with the callbacks of the previous one.
+func (c *Client) snapshot() Client {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	return *c
+}
+
+// context returns the Ctx of the client, the background context when it isn't set.
+func (c *Client) context() context.Context {
+	if c.Ctx == nil {
+		return context.Background()
+	}
+
+	return c.Ctx
+}
+
+// closeOnDone closes the connection once the context is done, unless its reader has stopped before.
+func (c *Client) closeOnDone(ctx context.Context, readerDone chan struct{}) {
+	select {
+	case <-ctx.Done():
+		c.logger.Infoln("Context done, closing the connection")
+		c.Close()
+	case <-readerDone:
+	}
+}
+
+// disconnect closes a broken connection and reports it to the OnDisconnected callback, so that the owner of the
+// client can reconnect.
+func (c *Client) disconnect(conn *websocket.Conn, err error) {
+	_ = conn.Close()
+
+	// The connection is being closed by Close, which reports it.
+	_, ok := c.stateMachine().transition(StateClosed, err, StateConnected)
+	if !ok {
+		return
+	}
+
+	if c.OnDisconnected != nil {
+		c.OnDisconnected(err, c.snapshot())
+	}
+}
+
+// Send is a proxy function of WriteMessage, it sends a message to the websocket server. It fails with ErrInvalidState
+// unless the connection is open, e.g. while reconnecting.
 func (c *Client) send(messageType int, data []byte) error {
 	c.sendMu.Lock()
 	defer c.sendMu.Unlock()
 
+	if state := c.State(); state != StateConnected || c.Conn == nil {
+		return fmt.Errorf("%w: send while %s", ErrInvalidState, state)
+	}
+
 	return c.Conn.WriteMessage(messageType, data)
 }
 
+// SendRequest sends the message, waiting for its turn in the outbound queue of the RateLimiter, if any, until the Ctx
+// of the client is done.
 func (c *Client) SendRequest(message string) error {
+	return c.SendRequestContext(c.context(), message)
+}
+
+// SendRequestContext sends the message, waiting for its turn in the outbound queue of the RateLimiter, if any, until
+// the context is done. It fails with a RateLimitError wrapping ErrQueueFull when the queue is full.
+func (c *Client) SendRequestContext(ctx context.Context, message string) error {
+	if c.RateLimiter == nil {
+		return c.sendRequest(message)
+	}
+
+	release, err := c.RateLimiter.Wait(ctx)
+	if err != nil {
+		c.logger.Errorf("write: %s", err)
+
+		return err
+	}
+	defer release()
+
+	return c.sendRequest(message)
+}
+
+// TrySendRequest sends the message only when the RateLimiter, if any, allows it right away, and otherwise fails fast
+// with a RateLimitError wrapping ErrRateLimited.
+func (c *Client) TrySendRequest(message string) error {
+	if c.RateLimiter == nil {
+		return c.sendRequest(message)
+	}
+
+	release, err := c.RateLimiter.Allow()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return c.sendRequest(message)
+}
+
+// QueueDepth returns the number of the messages queued by the RateLimiter, including the one being sent.
+func (c *Client) QueueDepth() int {
+	if c.RateLimiter == nil {
+		return 0
+	}
+
+	return c.RateLimiter.QueueDepth()
+}
+
+func (c *Client) sendRequest(message string) error {
 	err := c.send(websocket.TextMessage, []byte(message))
 	if err != nil {
 		c.logger.Errorf("write: %s", err)
@@ -180,34 +533,56 @@ func (c *Client) SendRequest(message string) error {
 	return nil
 }
 
-// Close closes the websocket connection.
+// Close closes the websocket connection and waits until its reader has stopped, it does nothing unless the connection
+// is open. It mustn't be called from the callbacks of the connection being closed, since they run on its reader.
 func (c *Client) Close() {
 	logger := c.logger
 
-	if !c.IsConnected || c.Conn == nil {
+	c.sendMu.Lock()
+	conn, closing, readerDone := c.Conn, c.closing, c.readerDone
+	c.sendMu.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	state := c.stateMachine()
+
+	_, ok := state.transition(StateClosing, nil, StateConnected)
+	if !ok {
 		return
 	}
 
-	err := c.send(
+	if closing != nil {
+		atomic.StoreInt32(closing, 1)
+	}
+
+	// The close frame is written directly, the connection is no longer open for the other messages.
+	c.sendMu.Lock()
+	err := conn.WriteMessage(
 		websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
 	)
+	c.sendMu.Unlock()
+
 	if err != nil {
 		logger.Errorf("write close: %s", err)
-
-		return
 	}
 
-	err = c.Conn.Close()
+	// The connection is closed even when the close frame couldn't be sent.
+	err = conn.Close()
 	if err != nil {
 		logger.Errorf("close: %s", err)
+	}
 
-		return
+	if readerDone != nil {
+		<-readerDone
 	}
 
-	// Set the OnDisconnected callback.
+	state.transition(StateClosed, nil, StateClosing)
+
+	// Closing on purpose is reported without an error.
 	if c.OnDisconnected != nil {
-		c.IsConnected = false
-		c.OnDisconnected(err, *c)
+		c.OnDisconnected(nil, c.snapshot())
 	}
 }
@@ -6,7 +6,9 @@ package websocket
 import (
 	"context"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -20,7 +22,48 @@ const (
 	ReqString    = `{"type":"subscribe","product_ids":["BTC-USD"],"channels":{ "name": "matches", "product_ids": ["BTC-USD"]}}`
 )
 
+// newEchoServer starts a local websocket server that sends every message it receives back, so that the client tests
+// don't depend on the live feed.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			err = conn.WriteMessage(messageType, data)
+			if err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// testStateMachine returns a state machine in the state.
+func testStateMachine(state State) *stateMachine {
+	m := newStateMachine()
+	m.state = state
+
+	return m
+}
+
 func TestClient_Close(t *testing.T) {
+	serverURL := newEchoServer(t)
+
 	type fields struct {
 		Ctx               context.Context
 		Conn              *websocket.Conn
@@ -32,7 +75,7 @@ func TestClient_Close(t *testing.T) {
 		OnReceivingMsg    func(message string, client Client)
 		OnConnectError    func(err error, client Client)
 		OnDisconnected    func(err error, client Client)
-		IsConnected       bool
+		state             *stateMachine
 		Timeout           time.Duration
 		sendMu            *sync.Mutex
 		receiveMu         *sync.Mutex
@@ -49,7 +92,7 @@ func TestClient_Close(t *testing.T) {
 				Ctx:             context.Background(),
 				Conn:            nil,
 				WebsocketDialer: &websocket.Dialer{},
-				URL:             WsURLSandbox,
+				URL:             serverURL,
 				ConnectionOptions: ConnOptions{
 					UseCompression: false,
 					UseSSL:         true,
@@ -59,7 +102,7 @@ func TestClient_Close(t *testing.T) {
 				OnReceivingMsg: nil,
 				OnConnectError: nil,
 				OnDisconnected: nil,
-				IsConnected:    true,
+				state:          testStateMachine(StateConnected),
 				Timeout:        0,
 				sendMu:         &sync.Mutex{},
 				receiveMu:      &sync.Mutex{},
@@ -80,7 +123,7 @@ func TestClient_Close(t *testing.T) {
 				OnReceivingMsg:    tt.fields.OnReceivingMsg,
 				OnConnectError:    tt.fields.OnConnectError,
 				OnDisconnected:    tt.fields.OnDisconnected,
-				IsConnected:       tt.fields.IsConnected,
+				state:             tt.fields.state,
 				Timeout:           tt.fields.Timeout,
 				sendMu:            tt.fields.sendMu,
 				receiveMu:         tt.fields.receiveMu,
@@ -104,6 +147,8 @@ func TestClient_Close(t *testing.T) {
 }
 
 func TestClient_Connect(t *testing.T) {
+	serverURL := newEchoServer(t)
+
 	type fields struct {
 		Ctx               context.Context
 		Conn              *websocket.Conn
@@ -115,7 +160,7 @@ func TestClient_Connect(t *testing.T) {
 		OnReceivingMsg    func(message string, client Client)
 		OnConnectError    func(err error, client Client)
 		OnDisconnected    func(err error, client Client)
-		IsConnected       bool
+		state             *stateMachine
 		Timeout           time.Duration
 		sendMu            *sync.Mutex
 		receiveMu         *sync.Mutex
@@ -133,7 +178,7 @@ func TestClient_Connect(t *testing.T) {
 				Ctx:             context.Background(),
 				Conn:            nil,
 				WebsocketDialer: &websocket.Dialer{},
-				URL:             WsURLSandbox,
+				URL:             serverURL,
 				ConnectionOptions: ConnOptions{
 					UseCompression: false,
 					UseSSL:         true,
@@ -143,7 +188,7 @@ func TestClient_Connect(t *testing.T) {
 				OnReceivingMsg: nil,
 				OnConnectError: nil,
 				OnDisconnected: nil,
-				IsConnected:    true,
+				state:          testStateMachine(StateIdle),
 				Timeout:        0,
 				sendMu:         &sync.Mutex{},
 				receiveMu:      &sync.Mutex{},
@@ -165,7 +210,7 @@ func TestClient_Connect(t *testing.T) {
 				OnReceivingMsg:    tt.fields.OnReceivingMsg,
 				OnConnectError:    tt.fields.OnConnectError,
 				OnDisconnected:    tt.fields.OnDisconnected,
-				IsConnected:       tt.fields.IsConnected,
+				state:             tt.fields.state,
 				Timeout:           tt.fields.Timeout,
 				sendMu:            tt.fields.sendMu,
 				receiveMu:         tt.fields.receiveMu,
@@ -174,15 +219,18 @@ func TestClient_Connect(t *testing.T) {
 			if err := c.Connect(); (err != nil) != tt.wantErr {
 				t.Errorf("Connect() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			defer c.Close()
 
-			if !c.IsConnected {
-				t.Errorf("Connect() IsConnected = %v, want %v", c.IsConnected, true)
+			if !c.IsConnected() {
+				t.Errorf("Connect() IsConnected = %v, want %v", c.IsConnected(), true)
 			}
 		})
 	}
 }
 
 func TestClient_SendRequest(t *testing.T) {
+	serverURL := newEchoServer(t)
+
 	type fields struct {
 		Ctx               context.Context
 		Conn              *websocket.Conn
@@ -194,7 +242,7 @@ func TestClient_SendRequest(t *testing.T) {
 		OnReceivingMsg    func(message string, client Client)
 		OnConnectError    func(err error, client Client)
 		OnDisconnected    func(err error, client Client)
-		IsConnected       bool
+		state             *stateMachine
 		Timeout           time.Duration
 		sendMu            *sync.Mutex
 		receiveMu         *sync.Mutex
@@ -216,7 +264,7 @@ func TestClient_SendRequest(t *testing.T) {
 				Ctx:             context.Background(),
 				Conn:            nil,
 				WebsocketDialer: &websocket.Dialer{},
-				URL:             WsURLSandbox,
+				URL:             serverURL,
 				ConnectionOptions: ConnOptions{
 					UseCompression: false,
 					UseSSL:         true,
@@ -226,7 +274,7 @@ func TestClient_SendRequest(t *testing.T) {
 				OnReceivingMsg: nil,
 				OnConnectError: nil,
 				OnDisconnected: nil,
-				IsConnected:    false,
+				state:          testStateMachine(StateIdle),
 				Timeout:        0,
 				sendMu:         &sync.Mutex{},
 				receiveMu:      &sync.Mutex{},
@@ -251,30 +299,46 @@ func TestClient_SendRequest(t *testing.T) {
 				OnReceivingMsg:    tt.fields.OnReceivingMsg,
 				OnConnectError:    tt.fields.OnConnectError,
 				OnDisconnected:    tt.fields.OnDisconnected,
-				IsConnected:       tt.fields.IsConnected,
+				state:             tt.fields.state,
 				Timeout:           tt.fields.Timeout,
 				sendMu:            tt.fields.sendMu,
 				receiveMu:         tt.fields.receiveMu,
 				logger:            tt.fields.logger,
 			}
+			received := make(chan string, 1)
+			c.OnReceivingMsg = func(message string, socket Client) {
+				select {
+				case received <- message:
+				default:
+				}
+			}
+
 			err := c.Connect()
 			if err != nil {
 				t.Errorf("Connect() error before SendRequest() error = %v", err)
 			}
+			defer c.Close()
+
 			if err := c.SendRequest(tt.args.message); (err != nil) != tt.wantErr {
 				t.Errorf("SendRequest() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			c.OnReceivingMsg = func(message string, socket Client) {
-				t.Logf("Received message: %s", message)
-				if message == "" {
-					t.Errorf("SendRequest() OnReceivingMsg = %v, want %v", message, ReqString)
+
+			// The echo server sends the request back.
+			select {
+			case message := <-received:
+				if message != tt.args.message {
+					t.Errorf("SendRequest() OnReceivingMsg = %v, want %v", message, tt.args.message)
 				}
+			case <-time.After(time.Second):
+				t.Errorf("SendRequest() no message received")
 			}
 		})
 	}
 }
 
 func TestClient_send(t *testing.T) {
+	serverURL := newEchoServer(t)
+
 	type fields struct {
 		Ctx               context.Context
 		Conn              *websocket.Conn
@@ -286,7 +350,7 @@ func TestClient_send(t *testing.T) {
 		OnReceivingMsg    func(message string, client Client)
 		OnConnectError    func(err error, client Client)
 		OnDisconnected    func(err error, client Client)
-		IsConnected       bool
+		state             *stateMachine
 		Timeout           time.Duration
 		sendMu            *sync.Mutex
 		receiveMu         *sync.Mutex
@@ -309,7 +373,7 @@ func TestClient_send(t *testing.T) {
 				Ctx:             context.Background(),
 				Conn:            nil,
 				WebsocketDialer: &websocket.Dialer{},
-				URL:             WsURLSandbox,
+				URL:             serverURL,
 				ConnectionOptions: ConnOptions{
 					UseCompression: false,
 					UseSSL:         true,
@@ -319,7 +383,7 @@ func TestClient_send(t *testing.T) {
 				OnReceivingMsg: nil,
 				OnConnectError: nil,
 				OnDisconnected: nil,
-				IsConnected:    false,
+				state:          testStateMachine(StateIdle),
 				Timeout:        0,
 				sendMu:         &sync.Mutex{},
 				receiveMu:      &sync.Mutex{},
@@ -345,7 +409,7 @@ func TestClient_send(t *testing.T) {
 				OnReceivingMsg:    tt.fields.OnReceivingMsg,
 				OnConnectError:    tt.fields.OnConnectError,
 				OnDisconnected:    tt.fields.OnDisconnected,
-				IsConnected:       tt.fields.IsConnected,
+				state:             tt.fields.state,
 				Timeout:           tt.fields.Timeout,
 				sendMu:            tt.fields.sendMu,
 				receiveMu:         tt.fields.receiveMu,
@@ -355,6 +419,7 @@ func TestClient_send(t *testing.T) {
 			if err != nil {
 				t.Errorf("Connect() error before send() error = %v", err)
 			}
+			defer c.Close()
 			if err := c.send(tt.args.messageType, tt.args.data); (err != nil) != tt.wantErr {
 				t.Errorf("send() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -388,11 +453,16 @@ func TestNewClient(t *testing.T) {
 					UseCompression: false,
 					UseSSL:         true,
 				},
-				WebsocketDialer: &websocket.Dialer{},
-				Timeout:         0,
-				sendMu:          &sync.Mutex{},
-				receiveMu:       &sync.Mutex{},
-				logger:          logger,
+				WebsocketDialer:  &websocket.Dialer{},
+				Timeout:          0,
+				PingInterval:     DefaultPingInterval,
+				PongTimeout:      DefaultPongTimeout,
+				FailbackInterval: DefaultFailbackInterval,
+				endpoints:        newEndpointPool(),
+				state:            newStateMachine(),
+				sendMu:           &sync.Mutex{},
+				receiveMu:        &sync.Mutex{},
+				logger:           logger,
 			},
 		},
 	}
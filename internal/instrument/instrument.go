@@ -0,0 +1,257 @@
+package instrument
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	VenueCoinbase = "coinbase"
+	VenueBinance  = "binance"
+	VenueKraken   = "kraken"
+)
+
+// DefaultAssetAliases maps the legacy or venue specific asset codes to the canonical ones.
+var DefaultAssetAliases = map[string]string{
+	"XBT": "BTC",
+	"XDG": "DOGE",
+}
+
+// DefaultQuotes are the quote assets used to split the symbols without a separator, such as BTCUSDT. The longer
+// quotes are tried first, so that BTCUSDT splits into BTC and USDT rather than BTCUS and DT.
+var DefaultQuotes = []string{"USDT", "USDC", "BUSD", "FDUSD", "TUSD", "DAI", "USD", "EUR", "GBP", "JPY", "BTC", "ETH"}
+
+// Instrument is the canonical model of a tradable pair, independent of the venue it's traded on.
+type Instrument struct {
+	Base  string `json:"base"`
+	Quote string `json:"quote"`
+}
+
+// String returns the canonical name of the instrument, e.g. BTC-USD, or the bare symbol of a raw instrument without a
+// quote.
+func (i Instrument) String() string {
+	if i.Quote == "" {
+		return i.Base
+	}
+
+	return i.Base + "-" + i.Quote
+}
+
+// Inverse returns the instrument with the base and the quote swapped.
+func (i Instrument) Inverse() Instrument {
+	return Instrument{Base: i.Quote, Quote: i.Base}
+}
+
+// Parse parses a canonical instrument name such as BTC-USD.
+func Parse(name string) (Instrument, error) {
+	assets := strings.Split(strings.ToUpper(strings.TrimSpace(name)), "-")
+	if len(assets) != 2 || assets[0] == "" || assets[1] == "" {
+		return Instrument{}, fmt.Errorf("invalid instrument %q, want BASE-QUOTE", name)
+	}
+
+	return Instrument{Base: assets[0], Quote: assets[1]}, nil
+}
+
+// Config is the JSON configuration of a registry.
+type Config struct {
+	AssetAliases map[string]string  `json:"asset_aliases"`
+	Quotes       []string           `json:"quotes"`
+	Instruments  []InstrumentConfig `json:"instruments"`
+}
+
+// InstrumentConfig maps the venue symbols of an instrument, keyed by the venue, e.g. {"kraken": ["XBT/USD"]}.
+type InstrumentConfig struct {
+	Base    string              `json:"base"`
+	Quote   string              `json:"quote"`
+	Aliases map[string][]string `json:"aliases"`
+}
+
+// Registry maps the venue symbols to the canonical instruments. The explicitly configured symbols are resolved
+// first, the other symbols are split into the base and the quote assets by the separators (-, / or _) or by the known
+// quote assets, and the asset aliases are applied.
+type Registry struct {
+	assetAliases map[string]string
+	quotes       []string
+	symbols      map[string]map[string]Instrument
+	venueSymbols map[string]map[Instrument]string
+	mu           sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		assetAliases: make(map[string]string),
+		symbols:      make(map[string]map[string]Instrument),
+		venueSymbols: make(map[string]map[Instrument]string),
+	}
+
+	for asset, alias := range DefaultAssetAliases {
+		r.assetAliases[asset] = alias
+	}
+
+	r.setQuotes(DefaultQuotes)
+
+	return r
+}
+
+// LoadRegistry creates a registry with the defaults extended by the JSON configuration file.
+func LoadRegistry(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	r := NewRegistry()
+
+	err = r.Apply(config)
+	if err != nil {
+		return nil, fmt.Errorf("apply %s: %w", path, err)
+	}
+
+	return r, nil
+}
+
+// Apply extends the registry with the configured asset aliases, quotes and instrument symbols.
+func (r *Registry) Apply(config Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for asset, alias := range config.AssetAliases {
+		r.assetAliases[strings.ToUpper(asset)] = strings.ToUpper(alias)
+	}
+
+	if len(config.Quotes) > 0 {
+		r.setQuotes(append(config.Quotes, r.quotes...))
+	}
+
+	for _, instrumentConfig := range config.Instruments {
+		if instrumentConfig.Base == "" || instrumentConfig.Quote == "" {
+			return fmt.Errorf("instrument %v must have both base and quote", instrumentConfig)
+		}
+
+		inst := Instrument{Base: strings.ToUpper(instrumentConfig.Base), Quote: strings.ToUpper(instrumentConfig.Quote)}
+
+		for venue, symbols := range instrumentConfig.Aliases {
+			for _, symbol := range symbols {
+				r.addSymbol(venue, symbol, inst)
+			}
+		}
+	}
+
+	return nil
+}
+
+// AddSymbol maps a venue symbol to an instrument, the first symbol added for an instrument is also the one used to
+// subscribe to the instrument on that venue.
+func (r *Registry) AddSymbol(venue string, symbol string, inst Instrument) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addSymbol(venue, symbol, inst)
+}
+
+func (r *Registry) addSymbol(venue string, symbol string, inst Instrument) {
+	venue = strings.ToLower(venue)
+
+	if r.symbols[venue] == nil {
+		r.symbols[venue] = make(map[string]Instrument)
+		r.venueSymbols[venue] = make(map[Instrument]string)
+	}
+
+	r.symbols[venue][strings.ToUpper(symbol)] = inst
+	if _, ok := r.venueSymbols[venue][inst]; !ok {
+		r.venueSymbols[venue][inst] = symbol
+	}
+}
+
+// setQuotes sets the unique quotes ordered from the longest.
+func (r *Registry) setQuotes(quotes []string) {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(quotes))
+
+	for _, quote := range quotes {
+		quote = strings.ToUpper(quote)
+		if !seen[quote] {
+			seen[quote] = true
+			unique = append(unique, quote)
+		}
+	}
+
+	sort.SliceStable(unique, func(i, j int) bool {
+		return len(unique[i]) > len(unique[j])
+	})
+
+	r.quotes = unique
+}
+
+// Resolve returns the canonical instrument of a venue symbol.
+func (r *Registry) Resolve(venue string, symbol string) (Instrument, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+
+	if inst, ok := r.symbols[strings.ToLower(venue)][symbol]; ok {
+		return inst, nil
+	}
+
+	base, quote, ok := r.split(symbol)
+	if !ok {
+		return Instrument{}, fmt.Errorf("unknown %s symbol %q", venue, symbol)
+	}
+
+	return Instrument{Base: r.alias(base), Quote: r.alias(quote)}, nil
+}
+
+// ResolveOrRaw returns the canonical instrument of a venue symbol, or a raw instrument named after the symbol itself
+// when it can't be resolved, so that the datapoints of unknown symbols are still kept apart.
+func (r *Registry) ResolveOrRaw(venue string, symbol string) Instrument {
+	inst, err := r.Resolve(venue, symbol)
+	if err != nil {
+		return Instrument{Base: strings.ToUpper(symbol)}
+	}
+
+	return inst
+}
+
+// Symbol returns the configured venue symbol of an instrument, or an empty string when there's none.
+func (r *Registry) Symbol(venue string, inst Instrument) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.venueSymbols[strings.ToLower(venue)][inst]
+}
+
+func (r *Registry) split(symbol string) (string, string, bool) {
+	for _, separator := range []string{"-", "/", "_"} {
+		if assets := strings.Split(symbol, separator); len(assets) == 2 && assets[0] != "" && assets[1] != "" {
+			return assets[0], assets[1], true
+		}
+	}
+
+	for _, quote := range r.quotes {
+		if len(symbol) > len(quote) && strings.HasSuffix(symbol, quote) {
+			return strings.TrimSuffix(symbol, quote), quote, true
+		}
+	}
+
+	return "", "", false
+}
+
+func (r *Registry) alias(asset string) string {
+	if alias, ok := r.assetAliases[asset]; ok {
+		return alias
+	}
+
+	return asset
+}
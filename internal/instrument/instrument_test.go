@@ -0,0 +1,151 @@
+//go:build all
+// +build all
+
+package instrument
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testInstrumentsFile = "../../tests/data/instruments.json"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Instrument
+		wantErr bool
+	}{
+		// Add TestParse test cases.
+		{
+			name:  "TestParse canonical",
+			input: "BTC-USD",
+			want:  Instrument{Base: "BTC", Quote: "USD"},
+		},
+		{
+			name:  "TestParse lower case",
+			input: " eth-usdt ",
+			want:  Instrument{Base: "ETH", Quote: "USDT"},
+		},
+		{
+			name:    "TestParse missing quote",
+			input:   "BTC-",
+			wantErr: true,
+		},
+		{
+			name:    "TestParse venue symbol",
+			input:   "BTCUSDT",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	registry, err := LoadRegistry(testInstrumentsFile)
+	if err != nil {
+		t.Fatalf("LoadRegistry() error = %v", err)
+	}
+
+	type args struct {
+		venue  string
+		symbol string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantRaw string
+		wantErr bool
+	}{
+		// Add TestRegistry_Resolve test cases.
+		{
+			name: "TestRegistry_Resolve coinbase",
+			args: args{venue: VenueCoinbase, symbol: "ETH-USD"},
+			want: "ETH-USD",
+		},
+		{
+			name: "TestRegistry_Resolve binance longest quote",
+			args: args{venue: VenueBinance, symbol: "btcusdt"},
+			want: "BTC-USDT",
+		},
+		{
+			name: "TestRegistry_Resolve binance configured",
+			args: args{venue: VenueBinance, symbol: "BTCUSD"},
+			want: "BTC-USD",
+		},
+		{
+			name: "TestRegistry_Resolve kraken alias",
+			args: args{venue: VenueKraken, symbol: "XDG/USD"},
+			want: "DOGE-USD",
+		},
+		{
+			name:    "TestRegistry_Resolve unknown",
+			args:    args{venue: VenueBinance, symbol: "foobar"},
+			wantRaw: "FOOBAR",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := registry.Resolve(tt.args.venue, tt.args.symbol)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+
+			// The unknown symbols resolve to the bare symbol.
+			wantRaw := tt.want
+			if tt.wantErr {
+				wantRaw = tt.wantRaw
+			}
+
+			if got := registry.ResolveOrRaw(tt.args.venue, tt.args.symbol).String(); got != wantRaw {
+				t.Errorf("ResolveOrRaw() = %v, want %v", got, wantRaw)
+			}
+		})
+	}
+}
+
+func TestRegistry_Symbol(t *testing.T) {
+	registry, err := LoadRegistry(testInstrumentsFile)
+	if err != nil {
+		t.Fatalf("LoadRegistry() error = %v", err)
+	}
+
+	btcUSD := Instrument{Base: "BTC", Quote: "USD"}
+
+	got := []string{
+		registry.Symbol(VenueKraken, btcUSD),
+		registry.Symbol(VenueBinance, btcUSD),
+		registry.Symbol(VenueBinance, Instrument{Base: "SOL", Quote: "USD"}),
+	}
+	want := []string{"BTC/USD", "BTCUSD", ""}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Symbol() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadRegistry(t *testing.T) {
+	_, err := LoadRegistry("../../tests/data/missing.json")
+	if err == nil {
+		t.Errorf("LoadRegistry() expected an error for a missing file")
+	}
+}
@@ -0,0 +1,274 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWebSocketURL is the Binance raw stream websocket URL.
+const DefaultWebSocketURL = "wss://stream.binance.com:9443/ws"
+
+// Streamer is a streaming service for Binance. It implements the streaming.Streamer interface.
+// It subscribes to the trade or the aggTrade streams of the products and pipes every trade as a vwap.DataPoint keyed
+// by the product id it was subscribed with, e.g. BTC-USDT rather than the Binance symbol BTCUSDT.
+type Streamer struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wsURL       string
+	client      *wsclient.Client
+	streamType  string
+	products    map[string]string
+	requestID   int64
+	reconnector *streaming.Reconnector
+	err         error
+	mu          sync.Mutex
+	logger      *logrus.Logger
+}
+
+func NewStreamer(ctx context.Context, wsURL string, productIds []string, streamType string) *Streamer {
+	ctx, cancel := context.WithCancel(ctx)
+
+	products := make(map[string]string, len(productIds))
+	for _, productID := range productIds {
+		products[Symbol(productID)] = productID
+	}
+
+	return &Streamer{
+		ctx:         ctx,
+		cancel:      cancel,
+		wsURL:       wsURL,
+		client:      wsclient.NewClient(ctx, wsURL),
+		streamType:  streamType,
+		products:    products,
+		reconnector: streaming.NewReconnector(streaming.DefaultReconnectDelay, streaming.DefaultMaxReconnectDelay),
+		logger:      logrus.New(),
+	}
+}
+
+func (s *Streamer) SetLogger(logger *logrus.Logger) {
+	s.logger = logger
+	s.client.SetLogger(logger)
+}
+
+// SetReconnectDelay sets the initial and the maximum delay between the reconnect attempts, the delay doubles after
+// every failed attempt. A zero initial delay disables reconnecting.
+func (s *Streamer) SetReconnectDelay(delay time.Duration, maxDelay time.Duration) {
+	s.reconnector.Delay = delay
+	s.reconnector.MaxDelay = maxDelay
+}
+
+func (s *Streamer) GetClient() *wsclient.Client {
+	return s.client
+}
+
+func (s *Streamer) GetContext() context.Context {
+	return s.ctx
+}
+
+// Stream subscribes to the trade streams of the products and pipes the trades to the streamFeeds channel. When the
+// connection drops unexpectedly, the streamer reconnects and re-sends the subscriptions.
+func (s *Streamer) Stream(streamFeeds chan interface{}) error {
+	client := s.client
+
+	ctx := s.ctx
+
+	client.OnConnected = func(socket wsclient.Client) {
+		s.logger.Infoln("Connected to binance server.")
+	}
+
+	client.OnConnectError = func(err error, socket wsclient.Client) {
+		s.logger.Infoln("Received connect error ", err)
+	}
+
+	client.OnReceivingMsg = func(message string, socket wsclient.Client) {
+		payload := []byte(message)
+
+		// Unwrap the messages of the combined stream endpoint.
+		var combined CombinedMessage
+		if json.Unmarshal(payload, &combined) == nil && combined.Stream != "" {
+			payload = combined.Data
+		}
+
+		var response Response
+
+		err := json.Unmarshal(payload, &response)
+		if err != nil {
+			s.logger.Errorf("Error unmarshalling message %s", err)
+			return
+		}
+
+		// Stop on subscribe errors.
+		if err = response.Err(); err != nil {
+			s.logger.Errorf("Received subscribe error: %v", err)
+			s.fail(fmt.Errorf("%w: %s", streaming.ErrSubscribeFailed, err))
+			return
+		}
+
+		if response.ID != nil {
+			s.logger.Debugf("Request %d succeeded", *response.ID)
+			return
+		}
+
+		var event TradeEvent
+
+		err = json.Unmarshal(payload, &event)
+		if err != nil {
+			s.logger.Errorf("Error unmarshalling trade %s", err)
+			return
+		}
+
+		if event.EventType != s.streamType {
+			return
+		}
+
+		s.mu.Lock()
+		productID, ok := s.products[event.Symbol]
+		s.mu.Unlock()
+
+		if !ok {
+			s.logger.Warnf("Received trade of unknown symbol %s", event.Symbol)
+			return
+		}
+
+		// The datapoints are piped in the order they're received, the handler relies on the increasing trade ids.
+		dataPoint := event.ToDataPoint(productID)
+		dataPoint.ReceivedAt = socket.ReceivedAt()
+
+		select {
+		case streamFeeds <- dataPoint:
+		case <-ctx.Done():
+		}
+	}
+
+	client.OnDisconnected = func(err error, socket wsclient.Client) {
+		if err == nil {
+			s.logger.Infoln("Disconnected from server")
+			return
+		}
+
+		s.logger.Errorf("Received disconnect error %s", err)
+		s.reconnector.Reconnect(ctx, s.client, s.subscribe, s.logger)
+	}
+
+	if !client.IsConnected() {
+		err := client.Connect()
+		if err != nil {
+			s.logger.Errorf("Error connecting to server %s", err)
+
+			return err
+		}
+	}
+
+	return s.subscribe()
+}
+
+func (s *Streamer) subscribe() error {
+	s.mu.Lock()
+	productIds := make([]string, 0, len(s.products))
+	for _, productID := range s.products {
+		productIds = append(productIds, productID)
+	}
+	s.mu.Unlock()
+
+	sort.Strings(productIds)
+
+	return s.sendRequest(MethodSubscribe, productIds)
+}
+
+// Subscribe subscribes the running stream to the trades of additional products.
+func (s *Streamer) Subscribe(productIds []string) error {
+	s.mu.Lock()
+	for _, productID := range productIds {
+		s.products[Symbol(productID)] = productID
+	}
+	s.mu.Unlock()
+
+	return s.sendRequest(MethodSubscribe, productIds)
+}
+
+// Unsubscribe unsubscribes the running stream from the trades of the products.
+func (s *Streamer) Unsubscribe(productIds []string) error {
+	s.mu.Lock()
+	for _, productID := range productIds {
+		delete(s.products, Symbol(productID))
+	}
+	s.mu.Unlock()
+
+	return s.sendRequest(MethodUnsubscribe, productIds)
+}
+
+func (s *Streamer) sendRequest(method string, productIds []string) error {
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	params := make([]string, 0, len(productIds))
+	for _, productID := range productIds {
+		params = append(params, StreamName(productID, s.streamType))
+	}
+
+	s.mu.Lock()
+	s.requestID++
+	id := s.requestID
+	s.mu.Unlock()
+
+	request, err := json.Marshal(Request{Method: method, Params: params, ID: id})
+	if err != nil {
+		return err
+	}
+
+	err = s.client.SendRequest(string(request))
+	if err != nil {
+		s.logger.Errorf("Error sending %s request %s", method, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Stop cancels the context of the streamer, so that the feed being piped is dropped rather than blocking the close,
+// then stops reconnecting and closes the connection.
+func (s *Streamer) Stop() {
+	s.cancelContext()
+	s.reconnector.Stop()
+
+	if s.client.IsConnected() {
+		s.client.Close()
+	}
+}
+
+// Err returns the fatal error that has ended the stream, e.g. a rejected subscription, if any.
+func (s *Streamer) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// fail ends the stream with a fatal error, the first one is kept. It's called from the client callbacks, so it only
+// cancels the context, which closes the connection.
+func (s *Streamer) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+
+	s.cancelContext()
+}
+
+// cancelContext cancels the context of the streamer, which stops piping the feeds and reconnecting.
+func (s *Streamer) cancelContext() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
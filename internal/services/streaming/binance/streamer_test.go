@@ -0,0 +1,174 @@
+//go:build all
+// +build all
+
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// newFakeServer starts a local websocket server speaking the Binance subscribe protocol. It acknowledges every
+// subscribe request and replies with the trade fixtures, the requests with an unknown stream get the error fixture.
+func newFakeServer(t *testing.T, requests chan<- Request) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	trades := []string{
+		readFixture(t, "message_feed_binance_trade.json"),
+		readFixture(t, "message_feed_binance_agg_trade.json"),
+		readFixture(t, "message_feed_binance_combined_trade.json"),
+	}
+	subscribeError := readFixture(t, "message_feed_binance_subscribe_error.json")
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var req Request
+			if json.Unmarshal(message, &req) != nil {
+				continue
+			}
+			requests <- req
+
+			if strings.Contains(strings.Join(req.Params, ","), "ethbt@") {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(subscribeError))
+				continue
+			}
+
+			response, _ := json.Marshal(map[string]interface{}{"result": nil, "id": req.ID})
+			_ = conn.WriteMessage(websocket.TextMessage, response)
+
+			if req.Method != MethodSubscribe {
+				continue
+			}
+
+			for _, trade := range trades {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(trade))
+			}
+		}
+	}))
+}
+
+func TestStreamer_Stream(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	tests := []struct {
+		name        string
+		productIds  []string
+		streamType  string
+		wantRequest Request
+		want        []vwap.DataPoint
+		wantCancel  bool
+	}{
+		// Add TestStreamer_Stream test cases.
+		{
+			name:       "TestStreamer_Stream trade",
+			productIds: []string{"BTC-USDT", "ETH-USDT", "ETH-BTC"},
+			streamType: StreamTypeTrade,
+			wantRequest: Request{
+				Method: MethodSubscribe,
+				Params: []string{"btcusdt@trade", "ethbtc@trade", "ethusdt@trade"},
+				ID:     1,
+			},
+			want: []vwap.DataPoint{
+				{Type: StreamTypeTrade, TradeID: 1325432861, ProductID: "BTC-USDT"},
+				{Type: StreamTypeTrade, TradeID: 339745123, ProductID: "ETH-BTC"},
+			},
+		},
+		{
+			name:       "TestStreamer_Stream aggTrade",
+			productIds: []string{"ETH-USDT"},
+			streamType: StreamTypeAggTrade,
+			wantRequest: Request{
+				Method: MethodSubscribe,
+				Params: []string{"ethusdt@aggTrade"},
+				ID:     1,
+			},
+			want: []vwap.DataPoint{
+				{Type: StreamTypeAggTrade, TradeID: 812440135, ProductID: "ETH-USDT"},
+			},
+		},
+		{
+			name:       "TestStreamer_Stream subscribe error",
+			productIds: []string{"ETH-BT"},
+			streamType: StreamTypeTrade,
+			wantRequest: Request{
+				Method: MethodSubscribe,
+				Params: []string{"ethbt@trade"},
+				ID:     1,
+			},
+			wantCancel: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := make(chan Request, 10)
+			server := newFakeServer(t, requests)
+			defer server.Close()
+
+			s := NewStreamer(
+				context.Background(),
+				"ws"+strings.TrimPrefix(server.URL, "http"),
+				tt.productIds,
+				tt.streamType,
+			)
+			s.SetLogger(logger)
+			defer s.Stop()
+
+			streamFeeds := make(chan interface{})
+			if err := s.Stream(streamFeeds); err != nil {
+				t.Fatalf("Stream() error = %v", err)
+			}
+
+			select {
+			case got := <-requests:
+				if got.Method != tt.wantRequest.Method || got.ID != tt.wantRequest.ID ||
+					strings.Join(got.Params, ",") != strings.Join(tt.wantRequest.Params, ",") {
+					t.Errorf("Stream() request = %v, want %v", got, tt.wantRequest)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Stream() request not received")
+			}
+
+			if tt.wantCancel {
+				select {
+				case <-s.GetContext().Done():
+				case <-time.After(5 * time.Second):
+					t.Errorf("Stream() context not cancelled on subscribe error")
+				}
+
+				return
+			}
+
+			for _, want := range tt.want {
+				select {
+				case feed := <-streamFeeds:
+					got := feed.(vwap.DataPoint)
+					if got.Type != want.Type || got.TradeID != want.TradeID || got.ProductID != want.ProductID {
+						t.Errorf("Stream() datapoint = %v, want %v", got, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Stream() datapoint not received, want %v", want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package binance
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+)
+
+const (
+	StreamTypeTrade    = "trade"
+	StreamTypeAggTrade = "aggTrade"
+
+	MethodSubscribe   = "SUBSCRIBE"
+	MethodUnsubscribe = "UNSUBSCRIBE"
+)
+
+// Request is the Binance websocket subscribe or unsubscribe request.
+type Request struct {
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+	ID     int64    `json:"id"`
+}
+
+// Response is the Binance websocket response to a request, it has either a result or an error.
+type Response struct {
+	Result json.RawMessage `json:"result"`
+	ID     *int64          `json:"id"`
+	Error  *Error          `json:"error,omitempty"`
+	Code   int             `json:"code,omitempty"`
+	Msg    string          `json:"msg,omitempty"`
+}
+
+type Error struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("binance error %d: %s", e.Code, e.Msg)
+}
+
+// Err returns the error of the response, or nil when the request succeeded.
+func (r Response) Err() error {
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.Code != 0 {
+		return &Error{Code: r.Code, Msg: r.Msg}
+	}
+
+	return nil
+}
+
+// CombinedMessage is the envelope of the messages sent on the combined stream endpoint.
+type CombinedMessage struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// TradeEvent is the payload of both the trade and the aggTrade streams. The single letter keys only differ by case,
+// every key has its own property, so that the case-insensitive matching doesn't mix them up.
+type TradeEvent struct {
+	EventType    string     `json:"e"`
+	EventTime    int64      `json:"E"`
+	Symbol       string     `json:"s"`
+	TradeID      int64      `json:"t"`
+	AggTradeID   int64      `json:"a"`
+	BuyerOrderID int64      `json:"b"`
+	Price        *big.Float `json:"p"`
+	Quantity     *big.Float `json:"q"`
+	FirstTradeID int64      `json:"f"`
+	LastTradeID  int64      `json:"l"`
+	TradeTime    int64      `json:"T"`
+	BuyerIsMaker bool       `json:"m"`
+	Ignore       bool       `json:"M"`
+}
+
+// ID returns the id of the trade, or the id of the aggregate trade for the aggTrade events.
+func (e TradeEvent) ID() int64 {
+	if e.EventType == StreamTypeAggTrade {
+		return e.AggTradeID
+	}
+
+	return e.TradeID
+}
+
+// ToDataPoint maps the trade into the datapoint of the product's sliding window. The trade time is in milliseconds.
+func (e TradeEvent) ToDataPoint(productID string) vwap.DataPoint {
+	dataPoint := vwap.DataPoint{
+		Type:      e.EventType,
+		TradeID:   int(e.ID()),
+		Size:      e.Quantity,
+		Price:     e.Price,
+		ProductID: productID,
+		Venue:     instrument.VenueBinance,
+	}
+
+	if e.TradeTime != 0 {
+		dataPoint.Time = time.UnixMilli(e.TradeTime).UTC()
+	}
+
+	return dataPoint
+}
+
+// Symbol converts a product id such as BTC-USDT into the Binance symbol BTCUSDT.
+func Symbol(productID string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", "/", "", "_", "").Replace(productID))
+}
+
+// StreamName returns the name of the product's stream, e.g. btcusdt@trade.
+func StreamName(productID string, streamType string) string {
+	return strings.ToLower(Symbol(productID)) + "@" + streamType
+}
@@ -0,0 +1,146 @@
+//go:build all
+// +build all
+
+package binance
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDataDir = "../../../../tests/data"
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(testDataDir, name))
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", name, err)
+	}
+
+	return string(data)
+}
+
+func TestTradeEvent_ToDataPoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		fixture     string
+		productID   string
+		wantType    string
+		wantTradeID int
+		wantPrice   string
+		wantSize    string
+		wantSymbol  string
+		wantTime    int64
+	}{
+		// Add TestTradeEvent_ToDataPoint test cases.
+		{
+			name:        "TestTradeEvent_ToDataPoint trade",
+			fixture:     "message_feed_binance_trade.json",
+			productID:   "BTC-USDT",
+			wantType:    StreamTypeTrade,
+			wantTradeID: 1325432861,
+			wantPrice:   "40129.67",
+			wantSize:    "0.00245",
+			wantSymbol:  "BTCUSDT",
+			wantTime:    1649854532249,
+		},
+		{
+			name:        "TestTradeEvent_ToDataPoint aggTrade",
+			fixture:     "message_feed_binance_agg_trade.json",
+			productID:   "ETH-USDT",
+			wantType:    StreamTypeAggTrade,
+			wantTradeID: 812440135,
+			wantPrice:   "3019.21",
+			wantSize:    "0.510154",
+			wantSymbol:  "ETHUSDT",
+			wantTime:    1649854531666,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event TradeEvent
+			if err := json.Unmarshal([]byte(readFixture(t, tt.fixture)), &event); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			if event.Symbol != tt.wantSymbol || event.TradeTime != tt.wantTime {
+				t.Errorf("Unmarshal() symbol, time = %v, %v, want %v, %v",
+					event.Symbol, event.TradeTime, tt.wantSymbol, tt.wantTime)
+			}
+
+			got := event.ToDataPoint(tt.productID)
+			if got.Type != tt.wantType || got.TradeID != tt.wantTradeID || got.ProductID != tt.productID {
+				t.Errorf("ToDataPoint() = %v, want %v trade %v of %v", got, tt.wantType, tt.wantTradeID, tt.productID)
+			}
+
+			if got.Time.UnixMilli() != tt.wantTime {
+				t.Errorf("ToDataPoint() time = %v, want %v", got.Time.UnixMilli(), tt.wantTime)
+			}
+
+			if got.Price.Text('f', -1) != tt.wantPrice || got.Size.Text('f', -1) != tt.wantSize {
+				t.Errorf("ToDataPoint() price, size = %v, %v, want %v, %v",
+					got.Price.Text('f', -1), got.Size.Text('f', -1), tt.wantPrice, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestResponse_Err(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		wantErr bool
+	}{
+		// Add TestResponse_Err test cases.
+		{
+			name:    "TestResponse_Err result",
+			message: readFixture(t, "message_feed_binance_subscribe_response.json"),
+		},
+		{
+			name:    "TestResponse_Err error object",
+			message: readFixture(t, "message_feed_binance_subscribe_error.json"),
+			wantErr: true,
+		},
+		{
+			name:    "TestResponse_Err error code",
+			message: `{"code": 2, "msg": "Invalid request: property name must be a string"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response Response
+			if err := json.Unmarshal([]byte(tt.message), &response); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			if err := response.Err(); (err != nil) != tt.wantErr {
+				t.Errorf("Err() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamName(t *testing.T) {
+	tests := []struct {
+		name       string
+		productID  string
+		streamType string
+		want       string
+	}{
+		// Add TestStreamName test cases.
+		{name: "TestStreamName dash", productID: "BTC-USDT", streamType: StreamTypeTrade, want: "btcusdt@trade"},
+		{name: "TestStreamName slash", productID: "eth/btc", streamType: StreamTypeAggTrade, want: "ethbtc@aggTrade"},
+		{name: "TestStreamName symbol", productID: "BNBBTC", streamType: StreamTypeTrade, want: "bnbbtc@trade"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StreamName(tt.productID, tt.streamType); got != tt.want {
+				t.Errorf("StreamName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
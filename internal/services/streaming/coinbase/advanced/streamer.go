@@ -0,0 +1,266 @@
+package advanced
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWebSocketURL is the Advanced Trade websocket market data URL.
+const DefaultWebSocketURL = "wss://advanced-trade-ws.coinbase.com"
+
+// Streamer is a streaming service for the Coinbase Advanced Trade websocket API. It implements the streaming.Streamer
+// interface. It subscribes to the market_trades channel and pipes every trade as a normalized coinbase.Feed match, so
+// the same stream data handler can be used with both the Exchange and the Advanced Trade APIs.
+type Streamer struct {
+	ctx             context.Context
+	cancel          context.CancelFunc
+	wsURL           string
+	client          *wsclient.Client
+	productIds      []string
+	reconnector     *streaming.Reconnector
+	lastSequenceNum int64
+	err             error
+	mu              sync.Mutex
+	logger          *logrus.Logger
+}
+
+func NewStreamer(ctx context.Context, wsURL string, productIds []string) *Streamer {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Streamer{
+		ctx:         ctx,
+		cancel:      cancel,
+		wsURL:       wsURL,
+		client:      wsclient.NewClient(ctx, wsURL),
+		productIds:  productIds,
+		reconnector: streaming.NewReconnector(streaming.DefaultReconnectDelay, streaming.DefaultMaxReconnectDelay),
+		logger:      logrus.New(),
+	}
+}
+
+func (s *Streamer) SetLogger(logger *logrus.Logger) {
+	s.logger = logger
+	s.client.SetLogger(logger)
+}
+
+// SetReconnectDelay sets the initial and the maximum delay between the reconnect attempts, the delay doubles after
+// every failed attempt. A zero initial delay disables reconnecting.
+func (s *Streamer) SetReconnectDelay(delay time.Duration, maxDelay time.Duration) {
+	s.reconnector.Delay = delay
+	s.reconnector.MaxDelay = maxDelay
+}
+
+func (s *Streamer) GetClient() *wsclient.Client {
+	return s.client
+}
+
+func (s *Streamer) GetContext() context.Context {
+	return s.ctx
+}
+
+// Stream subscribes to the market_trades and heartbeats channels and pipes the normalized trades to the streamFeeds
+// channel. When the connection drops unexpectedly, the streamer reconnects and re-sends the subscriptions.
+func (s *Streamer) Stream(streamFeeds chan interface{}) error {
+	client := s.client
+
+	ctx := s.ctx
+
+	client.OnConnected = func(socket wsclient.Client) {
+		s.logger.Infoln("Connected to coinbase advanced trade server.")
+	}
+
+	client.OnConnectError = func(err error, socket wsclient.Client) {
+		s.logger.Infoln("Received connect error ", err)
+	}
+
+	client.OnReceivingMsg = func(message string, socket wsclient.Client) {
+		var m Message
+
+		err := json.Unmarshal([]byte(message), &m)
+		if err != nil {
+			s.logger.Errorf("Error unmarshalling message %s", err)
+			return
+		}
+
+		// Stop on subscribe errors.
+		if m.Type == MessageTypeError {
+			s.logger.Errorf("Received subscribe error: %v reason: %v", m.Message, m.Reason)
+			s.fail(fmt.Errorf("%w: %s %s", streaming.ErrSubscribeFailed, m.Message, m.Reason))
+			return
+		}
+
+		s.checkSequence(m.SequenceNum)
+
+		feeds := m.Feeds()
+		if len(feeds) == 0 {
+			return
+		}
+
+		// The feeds are piped in the order they're received, the handler relies on the increasing trade ids.
+		for _, feed := range feeds {
+			feed.ReceivedAt = socket.ReceivedAt()
+
+			select {
+			case streamFeeds <- feed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+
+	client.OnDisconnected = func(err error, socket wsclient.Client) {
+		if err == nil {
+			s.logger.Infoln("Disconnected from server")
+			return
+		}
+
+		s.logger.Errorf("Received disconnect error %s", err)
+		s.reconnector.Reconnect(ctx, s.client, s.subscribe, s.logger)
+	}
+
+	if !client.IsConnected() {
+		err := client.Connect()
+		if err != nil {
+			s.logger.Errorf("Error connecting to server %s", err)
+
+			return err
+		}
+	}
+
+	return s.subscribe()
+}
+
+// checkSequence warns about the messages missed between two consecutive messages, the sequence numbers restart from
+// zero on every connection.
+func (s *Streamer) checkSequence(sequenceNum int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sequenceNum > s.lastSequenceNum+1 {
+		s.logger.Warnf("Missed %d messages before sequence %d", sequenceNum-s.lastSequenceNum-1, sequenceNum)
+	}
+
+	s.lastSequenceNum = sequenceNum
+}
+
+func (s *Streamer) subscribe() error {
+	s.mu.Lock()
+	productIds := append([]string{}, s.productIds...)
+	s.lastSequenceNum = -1
+	s.mu.Unlock()
+
+	err := s.sendRequest(coinbase.RequestTypeSubscribe, ChannelHeartbeats, productIds)
+	if err != nil {
+		return err
+	}
+
+	return s.sendRequest(coinbase.RequestTypeSubscribe, ChannelMarketTrades, productIds)
+}
+
+// Subscribe subscribes the running stream to the market trades of additional products.
+func (s *Streamer) Subscribe(productIds []string) error {
+	s.mu.Lock()
+	for _, productID := range productIds {
+		if !contains(s.productIds, productID) {
+			s.productIds = append(s.productIds, productID)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.sendRequest(coinbase.RequestTypeSubscribe, ChannelMarketTrades, productIds)
+}
+
+// Unsubscribe unsubscribes the running stream from the market trades of the products.
+func (s *Streamer) Unsubscribe(productIds []string) error {
+	s.mu.Lock()
+	remaining := make([]string, 0, len(s.productIds))
+	for _, productID := range s.productIds {
+		if !contains(productIds, productID) {
+			remaining = append(remaining, productID)
+		}
+	}
+	s.productIds = remaining
+	s.mu.Unlock()
+
+	return s.sendRequest(coinbase.RequestTypeUnsubscribe, ChannelMarketTrades, productIds)
+}
+
+func (s *Streamer) sendRequest(requestType string, channel string, productIds []string) error {
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	request, err := json.Marshal(SubscribeRequest{
+		Type:       requestType,
+		ProductIds: productIds,
+		Channel:    channel,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.client.SendRequest(string(request))
+	if err != nil {
+		s.logger.Errorf("Error sending %s %s request %s", requestType, channel, err)
+
+		return err
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stop cancels the context of the streamer, so that the feed being piped is dropped rather than blocking the close,
+// then stops reconnecting and closes the connection.
+func (s *Streamer) Stop() {
+	s.cancelContext()
+	s.reconnector.Stop()
+
+	if s.client.IsConnected() {
+		s.client.Close()
+	}
+}
+
+// Err returns the fatal error that has ended the stream, e.g. a rejected subscription, if any.
+func (s *Streamer) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// fail ends the stream with a fatal error, the first one is kept. It's called from the client callbacks, so it only
+// cancels the context, which closes the connection.
+func (s *Streamer) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+
+	s.cancelContext()
+}
+
+// cancelContext cancels the context of the streamer, which stops piping the feeds and reconnecting.
+func (s *Streamer) cancelContext() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
@@ -0,0 +1,206 @@
+//go:build all
+// +build all
+
+package advanced
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// newFixtureServer starts a local websocket server answering the market_trades subscribe requests with the recorded
+// fixture messages, or with the subscribe error fixture for unknown products.
+func newFixtureServer(t *testing.T, requests chan<- SubscribeRequest) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	replies := []string{
+		readFixture(t, "message_feed_coinbase_advanced_subscriptions.json"),
+		readFixture(t, "message_feed_coinbase_advanced_market_trades_snapshot.json"),
+		readFixture(t, "message_feed_coinbase_advanced_heartbeats.json"),
+		readFixture(t, "message_feed_coinbase_advanced_market_trades_update.json"),
+	}
+	subscribeError := readFixture(t, "message_feed_coinbase_advanced_subscribe_error.json")
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var req SubscribeRequest
+			if json.Unmarshal(message, &req) != nil {
+				continue
+			}
+			requests <- req
+
+			if req.Channel != ChannelMarketTrades || req.Type != coinbase.RequestTypeSubscribe {
+				continue
+			}
+
+			if contains(req.ProductIds, "ETH-BT") {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(subscribeError))
+				continue
+			}
+
+			for _, reply := range replies {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(reply))
+			}
+		}
+	}))
+}
+
+func TestStreamer_Stream(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	tests := []struct {
+		name         string
+		productIds   []string
+		wantRequests []SubscribeRequest
+		wantTrades   []int
+		wantCancel   bool
+	}{
+		// Add TestStreamer_Stream test cases.
+		{
+			name:       "TestStreamer_Stream",
+			productIds: []string{"BTC-USD", "ETH-USD"},
+			wantRequests: []SubscribeRequest{
+				{Type: "subscribe", ProductIds: []string{"BTC-USD", "ETH-USD"}, Channel: ChannelHeartbeats},
+				{Type: "subscribe", ProductIds: []string{"BTC-USD", "ETH-USD"}, Channel: ChannelMarketTrades},
+			},
+			wantTrades: []int{314513776, 314513780, 256828273},
+		},
+		{
+			name:       "TestStreamer_Stream subscribe error",
+			productIds: []string{"ETH-BT"},
+			wantCancel: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := make(chan SubscribeRequest, 10)
+			server := newFixtureServer(t, requests)
+			defer server.Close()
+
+			s := NewStreamer(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), tt.productIds)
+			s.SetLogger(logger)
+			defer s.Stop()
+
+			streamFeeds := make(chan interface{})
+			if err := s.Stream(streamFeeds); err != nil {
+				t.Fatalf("Stream() error = %v", err)
+			}
+
+			if tt.wantCancel {
+				select {
+				case <-s.GetContext().Done():
+				case <-time.After(5 * time.Second):
+					t.Errorf("Stream() context not cancelled on subscribe error")
+				}
+
+				return
+			}
+
+			for _, want := range tt.wantRequests {
+				select {
+				case got := <-requests:
+					if got.Type != want.Type || got.Channel != want.Channel ||
+						strings.Join(got.ProductIds, ",") != strings.Join(want.ProductIds, ",") {
+						t.Errorf("Stream() request = %v, want %v", got, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Stream() request not received, want %v", want)
+				}
+			}
+
+			for _, want := range tt.wantTrades {
+				select {
+				case feed := <-streamFeeds:
+					f := feed.(coinbase.Feed)
+					if f.Type != coinbase.FeedTypeMatch || f.TradeID != want {
+						t.Errorf("Stream() feed = %v, want match %v", f, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Stream() feed not received, want trade %v", want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamer_Subscribe(t *testing.T) {
+	requests := make(chan SubscribeRequest, 10)
+	server := newFixtureServer(t, requests)
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	s := NewStreamer(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), []string{"BTC-USD"})
+	s.SetLogger(logger)
+	defer s.Stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	streamFeeds := make(chan interface{})
+	stop := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-streamFeeds:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(stop)
+
+	if err := s.Stream(streamFeeds); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+
+	if err := s.Subscribe([]string{"ETH-USD"}); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if err := s.Unsubscribe([]string{"BTC-USD"}); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+
+	want := []string{"heartbeats subscribe BTC-USD", "market_trades subscribe BTC-USD",
+		"market_trades subscribe ETH-USD", "market_trades unsubscribe BTC-USD"}
+	for _, w := range want {
+		select {
+		case got := <-requests:
+			if g := got.Channel + " " + got.Type + " " + strings.Join(got.ProductIds, ","); g != w {
+				t.Errorf("request = %v, want %v", g, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request not received, want %v", w)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.Join(s.productIds, ",") != "ETH-USD" {
+		t.Errorf("productIds = %v, want %v", s.productIds, []string{"ETH-USD"})
+	}
+}
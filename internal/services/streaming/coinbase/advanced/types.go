@@ -0,0 +1,102 @@
+package advanced
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+)
+
+const (
+	ChannelMarketTrades  = "market_trades"
+	ChannelTicker        = "ticker"
+	ChannelLevel2        = "level2"
+	ChannelHeartbeats    = "heartbeats"
+	ChannelSubscriptions = "subscriptions"
+
+	EventTypeSnapshot = "snapshot"
+	EventTypeUpdate   = "update"
+
+	MessageTypeError = "error"
+)
+
+// SubscribeRequest is the Advanced Trade subscribe or unsubscribe request, a request covers a single channel.
+type SubscribeRequest struct {
+	Type       string   `json:"type"`
+	ProductIds []string `json:"product_ids"`
+	Channel    string   `json:"channel"`
+	JWT        string   `json:"jwt,omitempty"`
+}
+
+// Message is the envelope of every Advanced Trade websocket message. Errors are sent without the envelope, only the
+// Type, Message and Reason properties are set on them.
+type Message struct {
+	Channel     string    `json:"channel"`
+	ClientID    string    `json:"client_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	SequenceNum int64     `json:"sequence_num"`
+	Events      []Event   `json:"events"`
+	Type        string    `json:"type,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// Event is an event of the market_trades channel, the other channels' event properties are ignored.
+type Event struct {
+	Type   string  `json:"type"`
+	Trades []Trade `json:"trades"`
+}
+
+type Trade struct {
+	TradeID   string     `json:"trade_id"`
+	ProductID string     `json:"product_id"`
+	Price     *big.Float `json:"price"`
+	Size      *big.Float `json:"size"`
+	Side      string     `json:"side"`
+	Time      time.Time  `json:"time"`
+}
+
+// ToFeed normalizes the trade into a match feed of the Exchange websocket API, so that it can be consumed by the
+// Coinbase stream data handler. Non-numeric trade ids are left as zero.
+func (t Trade) ToFeed() coinbase.Feed {
+	tradeID, _ := strconv.Atoi(t.TradeID)
+
+	return coinbase.Feed{
+		Type:      coinbase.FeedTypeMatch,
+		TradeID:   tradeID,
+		Side:      strings.ToLower(t.Side),
+		Size:      t.Size,
+		Price:     t.Price,
+		ProductID: t.ProductID,
+		Time:      t.Time,
+	}
+}
+
+// Feeds returns the normalized match feeds of all the trades in a market_trades message. The snapshot trades are
+// listed from the newest, they're returned in the chronological order like the updates.
+func (m Message) Feeds() []coinbase.Feed {
+	if m.Channel != ChannelMarketTrades {
+		return nil
+	}
+
+	feeds := make([]coinbase.Feed, 0)
+
+	for _, event := range m.Events {
+		trades := event.Trades
+		if event.Type == EventTypeSnapshot {
+			for i := len(trades) - 1; i >= 0; i-- {
+				feeds = append(feeds, trades[i].ToFeed())
+			}
+
+			continue
+		}
+
+		for _, trade := range trades {
+			feeds = append(feeds, trade.ToFeed())
+		}
+	}
+
+	return feeds
+}
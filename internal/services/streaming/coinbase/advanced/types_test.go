@@ -0,0 +1,151 @@
+//go:build all
+// +build all
+
+package advanced
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+)
+
+const testDataDir = "../../../../../tests/data"
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(testDataDir, name))
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", name, err)
+	}
+
+	return string(data)
+}
+
+func mustParseFloat(value string) *big.Float {
+	f, _, err := big.ParseFloat(value, 10, 0, big.ToNearestEven)
+	if err != nil {
+		panic(err)
+	}
+
+	return f
+}
+
+func mustParseTime(value string) time.Time {
+	tm, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		panic(err)
+	}
+
+	return tm
+}
+
+func TestMessage_Feeds(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		want    []coinbase.Feed
+	}{
+		// Add TestMessage_Feeds test cases.
+		{
+			name:    "TestMessage_Feeds snapshot",
+			fixture: "message_feed_coinbase_advanced_market_trades_snapshot.json",
+			want: []coinbase.Feed{
+				{
+					Type:      coinbase.FeedTypeMatch,
+					TradeID:   314513776,
+					Side:      "buy",
+					Size:      mustParseFloat("0.00130039"),
+					Price:     mustParseFloat("40128.62"),
+					ProductID: "BTC-USD",
+					Time:      mustParseTime("2022-04-13T12:55:32.062270Z"),
+				},
+				{
+					Type:      coinbase.FeedTypeMatch,
+					TradeID:   314513780,
+					Side:      "sell",
+					Size:      mustParseFloat("0.00002447"),
+					Price:     mustParseFloat("40129.67"),
+					ProductID: "BTC-USD",
+					Time:      mustParseTime("2022-04-13T12:55:32.249480Z"),
+				},
+			},
+		},
+		{
+			name:    "TestMessage_Feeds update",
+			fixture: "message_feed_coinbase_advanced_market_trades_update.json",
+			want: []coinbase.Feed{
+				{
+					Type:      coinbase.FeedTypeMatch,
+					TradeID:   256828273,
+					Side:      "sell",
+					Size:      mustParseFloat("0.00510154"),
+					Price:     mustParseFloat("3019.21"),
+					ProductID: "ETH-USD",
+					Time:      mustParseTime("2022-04-13T12:55:33.666539Z"),
+				},
+			},
+		},
+		{
+			name:    "TestMessage_Feeds subscriptions",
+			fixture: "message_feed_coinbase_advanced_subscriptions.json",
+			want:    nil,
+		},
+		{
+			name:    "TestMessage_Feeds heartbeats",
+			fixture: "message_feed_coinbase_advanced_heartbeats.json",
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Message
+			if err := json.Unmarshal([]byte(readFixture(t, tt.fixture)), &m); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			got := m.Feeds()
+			if len(got) != len(tt.want) {
+				t.Fatalf("Feeds() = %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if got[i].Price.Cmp(tt.want[i].Price) != 0 || got[i].Size.Cmp(tt.want[i].Size) != 0 {
+					t.Errorf("Feeds()[%d] price, size = %v, %v, want %v, %v",
+						i, got[i].Price, got[i].Size, tt.want[i].Price, tt.want[i].Size)
+				}
+
+				got[i].Price, got[i].Size = nil, nil
+				tt.want[i].Price, tt.want[i].Size = nil, nil
+				if !reflect.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("Feeds()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTrade_ToFeed(t *testing.T) {
+	tests := []struct {
+		name  string
+		trade Trade
+		want  int
+	}{
+		// Add TestTrade_ToFeed test cases for the trade id conversion.
+		{name: "TestTrade_ToFeed numeric trade id", trade: Trade{TradeID: "42"}, want: 42},
+		{name: "TestTrade_ToFeed uuid trade id", trade: Trade{TradeID: "34b080bf-fcfd-445a-832b-46b5ddc65601"}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.trade.ToFeed().TradeID; got != tt.want {
+				t.Errorf("ToFeed() TradeID = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
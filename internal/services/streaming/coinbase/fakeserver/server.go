@@ -0,0 +1,416 @@
+package fakeserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/protocol"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultInterval is the default interval between the matches of each subscribed product.
+const DefaultInterval = 100 * time.Millisecond
+
+// Config is the behaviour of the server, including the injected faults.
+type Config struct {
+	// Interval is the interval between the matches of each subscribed product.
+	Interval time.Duration
+	// MalformedEvery sends every nth match of a connection as a malformed message, 0 disables it.
+	MalformedEvery int
+	// DisconnectAfter drops a connection, without a close frame, after sending it that many matches, 0 disables it.
+	DisconnectAfter int
+	// SubscribeError fails every subscribe request with the reason when it's not empty.
+	SubscribeError string
+	// RejectedProducts fail the subscribe requests including them.
+	RejectedProducts []string
+}
+
+func NewConfig() Config {
+	return Config{Interval: DefaultInterval}
+}
+
+// Subscriptions is the message confirming the current subscriptions of a connection.
+type Subscriptions struct {
+	Type     string             `json:"type"`
+	Channels []protocol.Channel `json:"channels"`
+}
+
+// Error is the error message of a failed request.
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+// Server is a fake Coinbase websocket feed. It speaks the subscribe and unsubscribe protocol of the matches channel,
+// answers a subscription with the last_match of each new product, then sends a match of every subscribed product on
+// every interval, produced by the source, e.g. a synthetic.Generator. Disconnects, malformed messages and subscribe
+// errors can be injected by the configuration or at runtime. Server is a http.Handler, served by httptest in the tests
+// or by the fakefeed command.
+type Server struct {
+	source     Source
+	config     Config
+	upgrader   websocket.Upgrader
+	conns      map[*connection]bool
+	sequence   int64
+	subscribes int
+	closed     bool
+	mu         sync.Mutex
+	wg         sync.WaitGroup
+	logger     *logrus.Logger
+}
+
+func NewServer(source Source, config Config) *Server {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+
+	return &Server{
+		source: source,
+		config: config,
+		conns:  make(map[*connection]bool),
+		logger: logrus.New(),
+	}
+}
+
+func (s *Server) SetLogger(logger *logrus.Logger) {
+	s.logger = logger
+}
+
+// SetSubscribeError fails every following subscribe request with the reason, an empty reason stops failing them.
+func (s *Server) SetSubscribeError(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config.SubscribeError = reason
+}
+
+// RejectProducts fails the following subscribe requests including any of the products.
+func (s *Server) RejectProducts(productIds ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config.RejectedProducts = append(s.config.RejectedProducts, productIds...)
+}
+
+// DropConnections drops all the open connections without a close frame.
+func (s *Server) DropConnections() {
+	for _, c := range s.connections() {
+		c.drop()
+	}
+}
+
+// Broadcast sends the raw message to all the open connections, e.g. to inject a malformed message.
+func (s *Server) Broadcast(message string) {
+	for _, c := range s.connections() {
+		_ = c.write([]byte(message))
+	}
+}
+
+// Connections returns the number of the open connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// Subscribes returns the number of the subscribe requests received.
+func (s *Server) Subscribes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.subscribes
+}
+
+// Close closes all the connections and waits for their goroutines to return.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+func (s *Server) connections() []*connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conns := make([]*connection, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+
+	return conns
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Errorf("Error upgrading connection %s", err)
+		return
+	}
+
+	c := &connection{
+		ws:       ws,
+		products: make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = ws.Close()
+		return
+	}
+
+	s.conns[c] = true
+	s.wg.Add(2)
+	s.mu.Unlock()
+
+	go s.stream(c)
+
+	defer s.wg.Done()
+	defer func() {
+		c.drop()
+
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		s.handleRequest(c, message)
+	}
+}
+
+func (s *Server) handleRequest(c *connection, message []byte) {
+	var request protocol.SubscribeRequest
+
+	err := json.Unmarshal(message, &request)
+	if err != nil {
+		s.writeJSON(c, Error{Type: protocol.FeedTypeSubscribeError, Message: "Failed to parse request", Reason: err.Error()})
+		return
+	}
+
+	productIds := requestProducts(request)
+
+	switch request.Type {
+	case protocol.RequestTypeSubscribe:
+		s.mu.Lock()
+		s.subscribes++
+		reason := s.subscribeError(productIds)
+		s.mu.Unlock()
+
+		if reason != "" {
+			s.writeJSON(c, Error{Type: protocol.FeedTypeSubscribeError, Message: "Failed to subscribe", Reason: reason})
+			return
+		}
+
+		added := c.subscribe(productIds)
+		s.writeSubscriptions(c)
+
+		for _, productID := range added {
+			match, ok := s.source.Next(productID)
+			if ok {
+				match.Type = protocol.FeedTypeLastMatch
+				s.writeMatch(c, match)
+			}
+		}
+	case protocol.RequestTypeUnsubscribe:
+		c.unsubscribe(productIds)
+		s.writeSubscriptions(c)
+	default:
+		reason := request.Type + " is not a valid type"
+		s.writeJSON(c, Error{Type: protocol.FeedTypeSubscribeError, Message: "Failed to parse request", Reason: reason})
+	}
+}
+
+// subscribeError returns the reason the subscription fails, or an empty string.
+func (s *Server) subscribeError(productIds []string) string {
+	if s.config.SubscribeError != "" {
+		return s.config.SubscribeError
+	}
+
+	for _, rejected := range s.config.RejectedProducts {
+		for _, productID := range productIds {
+			if productID == rejected {
+				return productID + " is not a valid product"
+			}
+		}
+	}
+
+	return ""
+}
+
+// stream sends a match of every subscribed product on every interval.
+func (s *Server) stream(c *connection) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			for _, productID := range c.subscribed() {
+				match, ok := s.source.Next(productID)
+				if !ok {
+					continue
+				}
+
+				match.Type = protocol.FeedTypeMatch
+				if !s.writeMatch(c, match) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeMatch sends the match with the next sequence number and the time it's sent, applying the injected faults. It
+// returns false once the connection has been dropped.
+func (s *Server) writeMatch(c *connection, match protocol.Feed) bool {
+	s.mu.Lock()
+	s.sequence++
+	match.Sequence = s.sequence
+	malformedEvery, disconnectAfter := s.config.MalformedEvery, s.config.DisconnectAfter
+	s.mu.Unlock()
+
+	match.Time = time.Now().UTC()
+
+	sent := c.countMatch()
+
+	if malformedEvery > 0 && sent%malformedEvery == 0 {
+		_ = c.write([]byte(`{"type":"match","trade_id":`))
+	} else {
+		s.writeJSON(c, match)
+	}
+
+	if disconnectAfter > 0 && sent >= disconnectAfter {
+		c.drop()
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) writeSubscriptions(c *connection) {
+	s.writeJSON(c, Subscriptions{
+		Type:     protocol.FeedTypeSubscriptions,
+		Channels: []protocol.Channel{{Name: protocol.ChannelMatches, ProductIds: c.subscribed()}},
+	})
+}
+
+func (s *Server) writeJSON(c *connection, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		s.logger.Errorf("Error marshalling message %s", err)
+		return
+	}
+
+	_ = c.write(data)
+}
+
+// requestProducts returns the products of the request and of its channels.
+func requestProducts(request protocol.SubscribeRequest) []string {
+	productIds := append([]string{}, request.ProductIds...)
+
+	for _, channel := range request.Channels {
+		productIds = append(productIds, channel.ProductIds...)
+	}
+
+	return productIds
+}
+
+// WebSocketURL converts the http url of a server into its websocket url.
+func WebSocketURL(httpURL string) string {
+	return "ws" + strings.TrimPrefix(httpURL, "http")
+}
+
+// connection is a client connection and its subscribed products.
+type connection struct {
+	ws       *websocket.Conn
+	products map[string]bool
+	sent     int
+	done     chan struct{}
+	dropOnce sync.Once
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+}
+
+func (c *connection) write(message []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.ws.WriteMessage(websocket.TextMessage, message)
+}
+
+func (c *connection) drop() {
+	c.dropOnce.Do(func() {
+		close(c.done)
+		_ = c.ws.UnderlyingConn().Close()
+	})
+}
+
+func (c *connection) countMatch() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent++
+
+	return c.sent
+}
+
+// subscribe adds the products and returns the newly added ones.
+func (c *connection) subscribe(productIds []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	added := make([]string, 0, len(productIds))
+
+	for _, productID := range productIds {
+		if !c.products[productID] {
+			c.products[productID] = true
+			added = append(added, productID)
+		}
+	}
+
+	return added
+}
+
+func (c *connection) unsubscribe(productIds []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, productID := range productIds {
+		delete(c.products, productID)
+	}
+}
+
+func (c *connection) subscribed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	productIds := make([]string, 0, len(c.products))
+	for productID := range c.products {
+		productIds = append(productIds, productID)
+	}
+
+	sort.Strings(productIds)
+
+	return productIds
+}
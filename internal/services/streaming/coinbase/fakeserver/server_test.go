@@ -0,0 +1,290 @@
+//go:build all
+// +build all
+
+package fakeserver
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/protocol"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/synthetic"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/goleak"
+)
+
+const testFixturesDir = "../../../../../tests/data"
+
+type message struct {
+	Type      string             `json:"type"`
+	TradeID   int                `json:"trade_id"`
+	ProductID string             `json:"product_id"`
+	Sequence  int64              `json:"sequence"`
+	Reason    string             `json:"reason"`
+	Channels  []protocol.Channel `json:"channels"`
+}
+
+// newTestSource returns the synthetic matches of the test products.
+func newTestSource(t *testing.T) *synthetic.Generator {
+	t.Helper()
+
+	generator, err := synthetic.NewGenerator(synthetic.NewPriceConfig(1, map[string]float64{"BTC-USD": 40000, "ETH-USD": 3000}))
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+
+	return generator
+}
+
+// startServer starts the fake server and connects a client to it.
+func startServer(t *testing.T, source Source, config Config) (*Server, *websocket.Conn, func()) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	server := NewServer(source, config)
+	server.SetLogger(logger)
+
+	httpServer := httptest.NewServer(server)
+
+	conn, _, err := websocket.DefaultDialer.Dial(WebSocketURL(httpServer.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial failed %v", err)
+	}
+
+	return server, conn, func() {
+		_ = conn.Close()
+		server.Close()
+		httpServer.Close()
+	}
+}
+
+func send(t *testing.T, conn *websocket.Conn, requestType string, productIds ...string) {
+	t.Helper()
+
+	request := protocol.SubscribeRequest{
+		Type:     requestType,
+		Channels: []protocol.Channel{{Name: protocol.ChannelMatches, ProductIds: productIds}},
+	}
+	if err := conn.WriteJSON(request); err != nil {
+		t.Fatalf("WriteJSON failed %v", err)
+	}
+}
+
+// receive reads the next messages, the malformed ones are returned with the type "malformed".
+func receive(t *testing.T, conn *websocket.Conn, count int) []message {
+	t.Helper()
+
+	messages := make([]message, 0, count)
+
+	for len(messages) < count {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage failed after %d messages %v", len(messages), err)
+		}
+
+		var m message
+		if json.Unmarshal(data, &m) != nil {
+			m.Type = "malformed"
+		}
+
+		messages = append(messages, m)
+	}
+
+	return messages
+}
+
+func types(messages []message) []string {
+	got := make([]string, 0, len(messages))
+	for _, m := range messages {
+		got = append(got, m.Type)
+	}
+
+	return got
+}
+
+func TestServer_subscribe(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	_, conn, stop := startServer(t, newTestSource(t), Config{Interval: 10 * time.Millisecond})
+	defer stop()
+
+	send(t, conn, protocol.RequestTypeSubscribe, "BTC-USD")
+
+	messages := receive(t, conn, 4)
+	want := []string{
+		protocol.FeedTypeSubscriptions,
+		protocol.FeedTypeLastMatch,
+		protocol.FeedTypeMatch,
+		protocol.FeedTypeMatch,
+	}
+
+	if !reflect.DeepEqual(types(messages), want) {
+		t.Fatalf("subscribe messages = %v, want %v", types(messages), want)
+	}
+
+	if !reflect.DeepEqual(messages[0].Channels[0].ProductIds, []string{"BTC-USD"}) {
+		t.Errorf("subscriptions = %v, want BTC-USD", messages[0].Channels)
+	}
+
+	for i := 2; i < len(messages); i++ {
+		if messages[i].TradeID <= messages[i-1].TradeID || messages[i].Sequence <= messages[i-1].Sequence {
+			t.Errorf("match %d = %v, want increasing trade ids and sequences", i, messages[i])
+		}
+	}
+
+	send(t, conn, protocol.RequestTypeUnsubscribe, "BTC-USD")
+
+	// Skip the matches already sent before the unsubscribe was handled.
+	for {
+		m := receive(t, conn, 1)[0]
+		if m.Type == protocol.FeedTypeSubscriptions {
+			if len(m.Channels[0].ProductIds) != 0 {
+				t.Errorf("unsubscribe subscriptions = %v, want none", m.Channels)
+			}
+
+			break
+		}
+	}
+}
+
+func TestServer_faults(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	matches, err := LoadMatches(testFixturesDir + "/replay_coinbase.jsonl")
+	if err != nil {
+		t.Fatalf("LoadMatches() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		config    Config
+		products  []string
+		wantTypes []string
+		wantDrop  bool
+	}{
+		// Add TestServer_faults test cases.
+		{
+			name:      "TestServer_faults subscribe error",
+			config:    Config{SubscribeError: "maintenance"},
+			products:  []string{"BTC-USD"},
+			wantTypes: []string{protocol.FeedTypeSubscribeError},
+		},
+		{
+			name:      "TestServer_faults rejected product",
+			config:    Config{RejectedProducts: []string{"BTC-XYZ"}},
+			products:  []string{"BTC-USD", "BTC-XYZ"},
+			wantTypes: []string{protocol.FeedTypeSubscribeError},
+		},
+		{
+			name:      "TestServer_faults malformed",
+			config:    Config{Interval: 10 * time.Millisecond, MalformedEvery: 2},
+			products:  []string{"BTC-USD"},
+			wantTypes: []string{protocol.FeedTypeSubscriptions, protocol.FeedTypeLastMatch, "malformed", protocol.FeedTypeMatch},
+		},
+		{
+			name:      "TestServer_faults disconnect",
+			config:    Config{Interval: 10 * time.Millisecond, DisconnectAfter: 2},
+			products:  []string{"BTC-USD"},
+			wantTypes: []string{protocol.FeedTypeSubscriptions, protocol.FeedTypeLastMatch, protocol.FeedTypeMatch},
+			wantDrop:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, conn, stop := startServer(t, NewFixtureSource(matches), tt.config)
+			defer stop()
+
+			send(t, conn, protocol.RequestTypeSubscribe, tt.products...)
+
+			got := types(receive(t, conn, len(tt.wantTypes)))
+			if !reflect.DeepEqual(got, tt.wantTypes) {
+				t.Errorf("messages = %v, want %v", got, tt.wantTypes)
+			}
+
+			if tt.wantDrop {
+				_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if _, _, err := conn.ReadMessage(); err == nil {
+					t.Errorf("ReadMessage() expected the connection to be dropped")
+				}
+			}
+		})
+	}
+}
+
+func TestServer_DropConnections(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	server, conn, stop := startServer(t, newTestSource(t), NewConfig())
+	defer stop()
+
+	send(t, conn, protocol.RequestTypeSubscribe, "ETH-USD")
+	receive(t, conn, 1)
+
+	if server.Connections() != 1 || server.Subscribes() != 1 {
+		t.Errorf("Connections(), Subscribes() = %v, %v, want 1, 1", server.Connections(), server.Subscribes())
+	}
+
+	server.Broadcast("not json")
+
+	server.DropConnections()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+func TestLoadMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantLen  int
+		wantErr  bool
+		wantType string
+	}{
+		// Add TestLoadMatches test cases.
+		{
+			name:     "TestLoadMatches json lines",
+			path:     testFixturesDir + "/replay_coinbase.jsonl",
+			wantLen:  6,
+			wantType: protocol.FeedTypeLastMatch,
+		},
+		{
+			name:     "TestLoadMatches single message",
+			path:     testFixturesDir + "/message_feed_coinbase_match_BTC-USD.json",
+			wantLen:  1,
+			wantType: protocol.FeedTypeMatch,
+		},
+		{
+			name:    "TestLoadMatches missing file",
+			path:    testFixturesDir + "/missing.json",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadMatches(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadMatches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if len(got) != tt.wantLen || got[0].Type != tt.wantType {
+				t.Errorf("LoadMatches() = %v, want %d matches starting with %s", got, tt.wantLen, tt.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package fakeserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/protocol"
+)
+
+// Source produces the matches the server sends for each subscribed product.
+type Source interface {
+	// Next returns the next match of the product, or false when the product has no more matches.
+	Next(productID string) (protocol.Feed, bool)
+}
+
+// FixtureSource replays the recorded matches of each product in order, then runs dry.
+type FixtureSource struct {
+	matches map[string][]protocol.Feed
+	mu      sync.Mutex
+}
+
+func NewFixtureSource(matches []protocol.Feed) *FixtureSource {
+	s := &FixtureSource{matches: make(map[string][]protocol.Feed)}
+
+	for _, match := range matches {
+		if match.Type != protocol.FeedTypeMatch && match.Type != protocol.FeedTypeLastMatch {
+			continue
+		}
+
+		s.matches[match.ProductID] = append(s.matches[match.ProductID], match)
+	}
+
+	return s
+}
+
+func (s *FixtureSource) Next(productID string) (protocol.Feed, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := s.matches[productID]
+	if len(matches) == 0 {
+		return protocol.Feed{}, false
+	}
+
+	s.matches[productID] = matches[1:]
+
+	return matches[0], true
+}
+
+// LoadMatches loads the matches of a fixture file, either a single message, a JSON array of messages, or JSON lines
+// of messages. The messages other than the matches are skipped.
+func LoadMatches(path string) ([]protocol.Feed, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	matches := make([]protocol.Feed, 0)
+
+	for {
+		var raw json.RawMessage
+
+		err = decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+
+		batch := make([]protocol.Feed, 0, 1)
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			err = json.Unmarshal(raw, &batch)
+		} else {
+			var match protocol.Feed
+			err = json.Unmarshal(raw, &match)
+			batch = append(batch, match)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+
+		for _, match := range batch {
+			if match.Type == protocol.FeedTypeMatch || match.Type == protocol.FeedTypeLastMatch {
+				matches = append(matches, match)
+			}
+		}
+	}
+
+	return matches, nil
+}
@@ -1,22 +1,43 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
-	"encoding/json"
-	"fmt"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrDuplicateTrade is returned when a datapoint with an already processed trade id is received.
+var ErrDuplicateTrade = errors.New("duplicate trade")
+
+// TradeHistoryFetcher is the interface for fetching the recent trades of a product, it is used to backfill the
+// sliding windows before the live stream starts.
+type TradeHistoryFetcher interface {
+	GetTrades(productID string, count int) ([]coinbase.Feed, error)
+}
+
 // CoinbaseSteamDataHandler is the implementation of the streaming.DataHandler interface.
 // It is used to handle the incoming data from the Coinbase streaming API wrapped by streamer.
+// It implements the streaming.LifecycleHandler interface, so that it can be run by a streaming.Pipeline.
 type CoinbaseSteamDataHandler struct {
 	vwapMaxSize         int
 	vwapPairs           []string
 	vwapData            map[string]*vwap.SlidingWindow
+	trades              *TradeDeduper
 	MessagePipelineFunc func(s *vwap.SlidingWindow) error
 	streamer            streaming.Streamer
+	tradeHistory        TradeHistoryFetcher
+	registry            *instrument.Registry
+	latency             *LatencyTracker
+	done                chan struct{}
+	mu                  sync.Mutex
 	logger              *logrus.Logger
 }
 
@@ -25,6 +46,9 @@ func NewStreamDataHandler(maxSize int, pairs []string) *CoinbaseSteamDataHandler
 		vwapMaxSize: maxSize,
 		vwapPairs:   pairs,
 		vwapData:    make(map[string]*vwap.SlidingWindow),
+		trades:      NewTradeDeduper(),
+		registry:    instrument.NewRegistry(),
+		latency:     NewLatencyTracker(DefaultLatencyWindow),
 		logger:      logrus.New(),
 	}
 }
@@ -41,6 +65,28 @@ func (h *CoinbaseSteamDataHandler) GetStreamer() streaming.Streamer {
 	return h.streamer
 }
 
+// SetTradeHistoryFetcher sets the trade history fetcher used to backfill the sliding windows when Handle is called.
+func (h *CoinbaseSteamDataHandler) SetTradeHistoryFetcher(tradeHistory TradeHistoryFetcher) {
+	h.tradeHistory = tradeHistory
+}
+
+// SetRegistry sets the registry used to resolve the venue symbols of the datapoints into the canonical instruments
+// that key the sliding windows.
+func (h *CoinbaseSteamDataHandler) SetRegistry(registry *instrument.Registry) {
+	h.registry = registry
+}
+
+// SetLatencyTracker sets the tracker the latencies of the received messages are recorded to, nil disables recording.
+func (h *CoinbaseSteamDataHandler) SetLatencyTracker(latency *LatencyTracker) {
+	h.latency = latency
+}
+
+// Latencies returns the rolling latency percentiles of the messages of every product, keyed by the canonical
+// instrument like the sliding windows.
+func (h *CoinbaseSteamDataHandler) Latencies() []ProductLatency {
+	return h.latency.Latencies()
+}
+
 // SetMessageBlockerFunc SetMessagePipelineFunc sets the function that will be called when a new message is received.
 func (h *CoinbaseSteamDataHandler) SetMessageBlockerFunc(
 	msgBlockerFunc func(c *vwap.SlidingWindow) error,
@@ -49,11 +95,26 @@ func (h *CoinbaseSteamDataHandler) SetMessageBlockerFunc(
 }
 
 // Handle handles the incoming data from the streamer and pipes it to a MessagePipelineFunc
-// that can be implemented later.
+// that can be implemented later. It handles the data until the streamer's context is done, or the streamer has
+// finished on its own like the replay, then stops the streamer and closes Done.
 func (h *CoinbaseSteamDataHandler) Handle() error {
 	s := h.streamer
 	streamFeeds := make(chan interface{})
 	ctx := s.GetContext()
+	done := h.doneChannel()
+
+	var finished <-chan struct{}
+	if finisher, ok := s.(streaming.Finisher); ok {
+		finished = finisher.Done()
+	}
+
+	// The products failing to backfill start with an empty window rather than aborting the start.
+	if h.tradeHistory != nil {
+		err := h.Backfill(h.vwapPairs)
+		if err != nil {
+			h.logger.Errorf("Error backfilling trade history %s", err)
+		}
+	}
 
 	err := s.Stream(streamFeeds)
 	if err != nil {
@@ -62,41 +123,52 @@ func (h *CoinbaseSteamDataHandler) Handle() error {
 	}
 
 	go func() {
+		defer close(done)
+
+		// A feed is handled and delivered to the pipeline before the next select, so the feeds taken from the streamer
+		// are drained when it's stopped.
 		for {
 			select {
 			case <-ctx.Done():
-				close(streamFeeds)
-				s.GetClient().Close()
+				// The streamFeeds channel is left open, the streamer may still be delivering to it.
+				streaming.StopStreamer(s)
+				return
+			case <-finished:
+				streaming.StopStreamer(s)
 				return
 			case feed := <-streamFeeds:
-				f, err := InterfaceToFeedStruct(feed)
+				dataPoint, err := ToDataPoint(feed)
 
 				if err != nil {
 					h.logger.Errorf("Error converting interface to feed struct %s", err)
 					continue
 				}
 
-				dataPoint := vwap.DataPoint{
-					Type:      f.Type,
-					Size:      f.Size,
-					Price:     f.Price,
-					ProductID: f.ProductID,
-				}
+				dataPoint = h.normalize(dataPoint)
 
 				err = h.processVwapData(dataPoint)
+				if errors.Is(err, ErrDuplicateTrade) {
+					h.logger.Debugf("Skipping %s trade %d %s", dataPoint.ProductID, dataPoint.TradeID, err)
+					continue
+				}
+
 				if err != nil {
 					h.logger.Errorf("Error processing vwap data %s", err)
 					continue
 				}
 
+				h.recordLatency(dataPoint, LatencyUpdate)
+
 				// TODO: Implement message pipeline function to send it to the message blocker or DB.
 				if h.MessagePipelineFunc != nil {
-					err := h.MessagePipelineFunc(h.vwapData[dataPoint.ProductID])
+					err := h.MessagePipelineFunc(h.getSlidingWindow(dataPoint.ProductID))
 					if err != nil {
 						h.logger.Errorf("Error processing vwap data %s", err)
 						continue
 					}
 				}
+
+				h.recordLatency(dataPoint, LatencyDelivery)
 			}
 		}
 	}()
@@ -104,23 +176,198 @@ func (h *CoinbaseSteamDataHandler) Handle() error {
 	return nil
 }
 
+// Stop stops the streamer, the handler ends once the feed being handled has been delivered, and closes Done.
+func (h *CoinbaseSteamDataHandler) Stop() {
+	if h.streamer != nil {
+		streaming.StopStreamer(h.streamer)
+	}
+}
+
+// Done is closed once the handling started by Handle has ended.
+func (h *CoinbaseSteamDataHandler) Done() <-chan struct{} {
+	return h.doneChannel()
+}
+
+// Err returns the fatal error the streamer has ended with, if any.
+func (h *CoinbaseSteamDataHandler) Err() error {
+	if failer, ok := h.streamer.(interface{ Err() error }); ok {
+		return failer.Err()
+	}
+
+	return nil
+}
+
+func (h *CoinbaseSteamDataHandler) doneChannel() chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.done == nil {
+		h.done = make(chan struct{})
+	}
+
+	return h.done
+}
+
+// Backfill prefills the sliding windows of the given products with their most recent trades fetched by the trade
+// history fetcher, so that the VWAP is meaningful from the first streamed datapoint. The trade ids seen during the
+// backfill are remembered, and the same trades received later from the stream are skipped. A product failing to
+// backfill doesn't stop the others, its window starts empty, the first of the failures is returned.
+func (h *CoinbaseSteamDataHandler) Backfill(productIDs []string) error {
+	if h.tradeHistory == nil {
+		return nil
+	}
+
+	var backfillErr error
+
+	for _, productID := range productIDs {
+		trades, err := h.tradeHistory.GetTrades(productID, h.vwapMaxSize)
+		if err != nil {
+			h.logger.Errorf("Error backfilling %s, starting with an empty window %s", productID, err)
+
+			if backfillErr == nil {
+				backfillErr = fmt.Errorf("backfill %s: %w", productID, err)
+			}
+
+			continue
+		}
+
+		added := 0
+		for _, trade := range trades {
+			err = h.addVwapData(h.normalize(vwap.DataPoint{
+				Type:      trade.Type,
+				TradeID:   trade.TradeID,
+				Size:      trade.Size,
+				Price:     trade.Price,
+				ProductID: productID,
+				Venue:     instrument.VenueCoinbase,
+			}))
+			if err == nil {
+				added++
+			}
+		}
+
+		h.logger.Infof("Backfilled %d trades of %s", added, productID)
+	}
+
+	return backfillErr
+}
+
 // processVwapData processes the incoming feed data and updates the vwap data property.
 func (h *CoinbaseSteamDataHandler) processVwapData(dataPoint vwap.DataPoint) error {
-	if _, ok := h.vwapData[dataPoint.ProductID]; !ok {
-		h.vwapData[dataPoint.ProductID] = vwap.NewSlidingWindow(h.vwapMaxSize, dataPoint.ProductID)
+	dataPoint = h.normalize(dataPoint)
+
+	err := h.addVwapData(dataPoint)
+	if err != nil {
+		return err
 	}
 
-	h.vwapData[dataPoint.ProductID].Add(dataPoint)
+	window := h.getSlidingWindow(dataPoint.ProductID)
 
 	fmt.Printf(
 		"Windows Size: %v\t%v:%v\n",
-		h.vwapData[dataPoint.ProductID].Size(),
+		window.Size(),
 		dataPoint.ProductID,
-		h.vwapData[dataPoint.ProductID].GetCalculator().Avg().String())
+		window.GetCalculator().Avg().String())
 
 	return nil
 }
 
+// recordLatency records the latency of the stage since the datapoint was received, along with the exchange latency
+// once its VWAP is updated. The datapoints without a receive time, like the replayed ones, aren't measured.
+func (h *CoinbaseSteamDataHandler) recordLatency(dataPoint vwap.DataPoint, stage LatencyStage) {
+	if h.latency == nil || dataPoint.ReceivedAt.IsZero() {
+		return
+	}
+
+	if stage == LatencyUpdate && !dataPoint.Time.IsZero() {
+		h.latency.Record(dataPoint.ProductID, LatencyExchange, dataPoint.ReceivedAt.Sub(dataPoint.Time))
+	}
+
+	h.latency.Record(dataPoint.ProductID, stage, time.Since(dataPoint.ReceivedAt))
+}
+
+// normalize replaces the venue symbol of the datapoint with the name of its canonical instrument, so that the same
+// instrument shares one sliding window whatever the venue calls it, see Normalize.
+func (h *CoinbaseSteamDataHandler) normalize(dataPoint vwap.DataPoint) vwap.DataPoint {
+	return Normalize(h.registry, dataPoint)
+}
+
+// addVwapData adds the datapoint to the sliding window of its product, unless its trade has already been processed.
+func (h *CoinbaseSteamDataHandler) addVwapData(dataPoint vwap.DataPoint) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.trades == nil {
+		h.trades = NewTradeDeduper()
+	}
+
+	err := h.trades.Add(dataPoint)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := h.vwapData[dataPoint.ProductID]; !ok {
+		h.vwapData[dataPoint.ProductID] = vwap.NewSlidingWindow(h.vwapMaxSize, dataPoint.ProductID)
+	}
+
+	h.vwapData[dataPoint.ProductID].Add(dataPoint)
+
+	return nil
+}
+
+// RemoveProducts drops the sliding windows and the last trade ids of the products, e.g. once they're unsubscribed from.
+func (h *CoinbaseSteamDataHandler) RemoveProducts(productIDs []string) {
+	for _, productID := range productIDs {
+		dataPoint := h.normalize(vwap.DataPoint{ProductID: productID})
+
+		h.mu.Lock()
+		delete(h.vwapData, dataPoint.ProductID)
+		if h.trades != nil {
+			h.trades.Remove(dataPoint.Venue, dataPoint.ProductID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *CoinbaseSteamDataHandler) getSlidingWindow(productID string) *vwap.SlidingWindow {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.vwapData[productID]
+}
+
+// ToDataPoint converts a streamed feed into a datapoint. The streamers of other exchanges pipe the datapoints
+// directly, the Coinbase feeds are converted, and any other value is decoded as a Coinbase feed.
+func ToDataPoint(feed interface{}) (vwap.DataPoint, error) {
+	var (
+		f   coinbase.Feed
+		err error
+	)
+
+	switch v := feed.(type) {
+	case vwap.DataPoint:
+		return v, nil
+	case coinbase.Feed:
+		f = v
+	default:
+		f, err = InterfaceToFeedStruct(feed)
+		if err != nil {
+			return vwap.DataPoint{}, err
+		}
+	}
+
+	return vwap.DataPoint{
+		Type:       f.Type,
+		TradeID:    f.TradeID,
+		Size:       f.Size,
+		Price:      f.Price,
+		ProductID:  f.ProductID,
+		Venue:      instrument.VenueCoinbase,
+		Time:       f.Time,
+		ReceivedAt: f.ReceivedAt,
+	}, nil
+}
+
 func InterfaceToFeedStruct(anyData interface{}) (coinbase.Feed, error) {
 	bytes, err := json.Marshal(anyData)
 	if err != nil {
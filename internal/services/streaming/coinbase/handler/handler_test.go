@@ -6,12 +6,17 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"math/big"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/fakeserver"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/synthetic"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
 	"github.com/sirupsen/logrus"
 )
@@ -45,7 +50,27 @@ var (
 	logger    = logrus.New()
 )
 
+// newTestSource returns the synthetic matches of the test products.
+func newTestSource(t *testing.T) *synthetic.Generator {
+	t.Helper()
+
+	generator, err := synthetic.NewGenerator(synthetic.NewPriceConfig(1, map[string]float64{"BTC-USD": 40000, "ETH-USD": 3000, "ETH-BTC": 0.075, "LTC-USD": 70}))
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+
+	return generator
+}
+
 func TestCoinbaseSteamDataHandler_Handle(t *testing.T) {
+	// Stream from the local fake feed instead of the live one.
+	feed := fakeserver.NewServer(newTestSource(t), fakeserver.NewConfig())
+	feed.SetLogger(logger)
+
+	feedServer := httptest.NewServer(feed)
+	defer feedServer.Close()
+	defer feed.Close()
+
 	type fields struct {
 		vwapMaxSize         int
 		vwapPairs           []string
@@ -69,7 +94,7 @@ func TestCoinbaseSteamDataHandler_Handle(t *testing.T) {
 				messagePipelineFunc: func(s *vwap.SlidingWindow) error { return nil },
 				streamer: coinbase.NewStreamer(
 					ctx,
-					WsURLSandbox,
+					fakeserver.WebSocketURL(feedServer.URL),
 					ReqString,
 				),
 				logger: logger,
@@ -90,6 +115,10 @@ func TestCoinbaseSteamDataHandler_Handle(t *testing.T) {
 			if err := h.Handle(); (err != nil) != tt.wantErr {
 				t.Errorf("Handle() error = %v, wantErr %v", err, tt.wantErr)
 			}
+
+			if stopper, ok := h.streamer.(interface{ Stop() }); ok {
+				stopper.Stop()
+			}
 		})
 	}
 }
@@ -155,6 +184,165 @@ func TestCoinbaseSteamDataHandler_processVwapData(t *testing.T) {
 	}
 }
 
+// tradeHistoryStub returns the trades of a product from a predefined map.
+type tradeHistoryStub map[string][]coinbase.Feed
+
+func (s tradeHistoryStub) GetTrades(productID string, count int) ([]coinbase.Feed, error) {
+	trades, ok := s[productID]
+	if !ok {
+		return nil, errors.New("unknown product")
+	}
+
+	if len(trades) > count {
+		trades = trades[len(trades)-count:]
+	}
+
+	return trades, nil
+}
+
+func TestCoinbaseSteamDataHandler_Backfill(t *testing.T) {
+	trades := tradeHistoryStub{
+		"BTC-USD": {
+			{Type: "match", TradeID: 1, Price: big.NewFloat(100), Size: big.NewFloat(1), ProductID: "BTC-USD"},
+			{Type: "match", TradeID: 2, Price: big.NewFloat(200), Size: big.NewFloat(1), ProductID: "BTC-USD"},
+			{Type: "match", TradeID: 3, Price: big.NewFloat(300), Size: big.NewFloat(2), ProductID: "BTC-USD"},
+		},
+	}
+	type args struct {
+		productIDs []string
+		stream     []vwap.DataPoint
+	}
+	tests := []struct {
+		name       string
+		maxSize    int
+		args       args
+		wantErr    bool
+		wantLength int
+		wantAvg    string
+		wantDupes  int
+	}{
+		// Add TestCoinbaseSteamDataHandler_Backfill test cases.
+		{
+			name:       "TestCoinbaseSteamDataHandler_Backfill",
+			maxSize:    10,
+			args:       args{productIDs: []string{"BTC-USD"}},
+			wantLength: 3,
+			wantAvg:    "225",
+		},
+		{
+			name:    "TestCoinbaseSteamDataHandler_Backfill skips already backfilled trades",
+			maxSize: 10,
+			args: args{
+				productIDs: []string{"BTC-USD"},
+				stream: []vwap.DataPoint{
+					{Type: "last_match", TradeID: 3, Price: big.NewFloat(300), Size: big.NewFloat(2), ProductID: "BTC-USD"},
+					{Type: "match", TradeID: 4, Price: big.NewFloat(400), Size: big.NewFloat(4), ProductID: "BTC-USD"},
+				},
+			},
+			wantLength: 4,
+			wantAvg:    "312.5",
+			wantDupes:  1,
+		},
+		{
+			name:       "TestCoinbaseSteamDataHandler_Backfill limited by window size",
+			maxSize:    2,
+			args:       args{productIDs: []string{"BTC-USD"}},
+			wantLength: 2,
+			wantAvg:    "266.6666667",
+		},
+		{
+			name:    "TestCoinbaseSteamDataHandler_Backfill unknown product",
+			maxSize: 10,
+			args:    args{productIDs: []string{"BTC-US"}},
+			wantErr: true,
+		},
+		{
+			name:       "TestCoinbaseSteamDataHandler_Backfill unknown product among others",
+			maxSize:    10,
+			args:       args{productIDs: []string{"BTC-US", "BTC-USD"}},
+			wantErr:    true,
+			wantLength: 3,
+			wantAvg:    "225",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewStreamDataHandler(tt.maxSize, tt.args.productIDs)
+			h.SetLogger(logger)
+			h.SetTradeHistoryFetcher(trades)
+
+			if err := h.Backfill(tt.args.productIDs); (err != nil) != tt.wantErr {
+				t.Errorf("Backfill() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// The products that failed to backfill start with an empty window.
+			if tt.wantLength == 0 {
+				return
+			}
+
+			dupes := 0
+			for _, dataPoint := range tt.args.stream {
+				if err := h.processVwapData(dataPoint); errors.Is(err, ErrDuplicateTrade) {
+					dupes++
+				}
+			}
+
+			if dupes != tt.wantDupes {
+				t.Errorf("processVwapData() duplicates = %v, want %v", dupes, tt.wantDupes)
+			}
+
+			window := h.vwapData["BTC-USD"]
+			if got := window.Length(); got != tt.wantLength {
+				t.Errorf("Backfill() window length = %v, want %v", got, tt.wantLength)
+			}
+
+			if got := window.GetCalculator().Avg().Text('g', 10); got != tt.wantAvg {
+				t.Errorf("Backfill() window avg = %v, want %v", got, tt.wantAvg)
+			}
+		})
+	}
+}
+
+func TestCoinbaseSteamDataHandler_RemoveProducts(t *testing.T) {
+	h := NewStreamDataHandler(10, []string{"BTC-USD", "ETH-USD"})
+	h.SetLogger(logger)
+
+	for _, productID := range []string{"BTC-USD", "ETH-USD"} {
+		err := h.processVwapData(vwap.DataPoint{
+			Type:      "match",
+			TradeID:   1,
+			Price:     big.NewFloat(100),
+			Size:      big.NewFloat(1),
+			ProductID: productID,
+		})
+		if err != nil {
+			t.Fatalf("processVwapData() error = %v", err)
+		}
+	}
+
+	h.RemoveProducts([]string{"ETH-USD"})
+
+	if h.getSlidingWindow("ETH-USD") != nil {
+		t.Errorf("RemoveProducts() window of ETH-USD not dropped")
+	}
+
+	if h.getSlidingWindow("BTC-USD") == nil {
+		t.Errorf("RemoveProducts() window of BTC-USD dropped, want kept")
+	}
+
+	// The trade ids of a removed product are forgotten, so that it starts over once it's subscribed to again.
+	err := h.processVwapData(vwap.DataPoint{
+		Type:      "match",
+		TradeID:   1,
+		Price:     big.NewFloat(100),
+		Size:      big.NewFloat(1),
+		ProductID: "ETH-USD",
+	})
+	if err != nil {
+		t.Errorf("processVwapData() error = %v, want nil after RemoveProducts()", err)
+	}
+}
+
 func TestNewStreamDataHandler(t *testing.T) {
 	logger := logger
 	type args struct {
@@ -177,6 +365,9 @@ func TestNewStreamDataHandler(t *testing.T) {
 				vwapMaxSize: 5,
 				vwapPairs:   testPairs,
 				vwapData:    make(map[string]*vwap.SlidingWindow),
+				trades:      NewTradeDeduper(),
+				registry:    instrument.NewRegistry(),
+				latency:     NewLatencyTracker(DefaultLatencyWindow),
 				logger:      logger,
 			},
 		},
@@ -192,6 +383,71 @@ func TestNewStreamDataHandler(t *testing.T) {
 	}
 }
 
+func TestToDataPoint(t *testing.T) {
+	feed := coinbase.Feed{
+		Type:      "match",
+		TradeID:   256828273,
+		Side:      "sell",
+		Size:      big.NewFloat(0.01),
+		Price:     big.NewFloat(3005.71),
+		ProductID: "ETH-USD",
+	}
+	want := vwap.DataPoint{
+		Type:      "match",
+		TradeID:   256828273,
+		Size:      feed.Size,
+		Price:     feed.Price,
+		ProductID: "ETH-USD",
+		Venue:     instrument.VenueCoinbase,
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(`{"type":"match","trade_id":256828273,"product_id":"ETH-USD"}`), &decoded); err != nil {
+		t.Fatalf("Unmarshal failed %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		feed    interface{}
+		want    vwap.DataPoint
+		wantErr bool
+	}{
+		// Add TestToDataPoint test cases.
+		{
+			name: "TestToDataPoint datapoint",
+			feed: want,
+			want: want,
+		},
+		{
+			name: "TestToDataPoint coinbase feed",
+			feed: feed,
+			want: want,
+		},
+		{
+			name: "TestToDataPoint decoded feed",
+			feed: decoded,
+			want: vwap.DataPoint{Type: "match", TradeID: 256828273, ProductID: "ETH-USD", Venue: instrument.VenueCoinbase},
+		},
+		{
+			name:    "TestToDataPoint invalid feed",
+			feed:    make(chan int),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToDataPoint(tt.feed)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ToDataPoint() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ToDataPoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_interfaceToFeedStruct(t *testing.T) {
 	type args struct {
 		anyData interface{}
@@ -269,3 +525,46 @@ func Test_interfaceToFeedStruct(t *testing.T) {
 		})
 	}
 }
+
+func TestCoinbaseSteamDataHandler_normalize(t *testing.T) {
+	registry := instrument.NewRegistry()
+	registry.AddSymbol(instrument.VenueKraken, "XBTUSD.M", instrument.Instrument{Base: "BTC", Quote: "USD"})
+
+	tests := []struct {
+		name      string
+		dataPoint vwap.DataPoint
+		want      string
+	}{
+		// Add TestCoinbaseSteamDataHandler_normalize test cases.
+		{
+			name:      "TestCoinbaseSteamDataHandler_normalize coinbase",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD"},
+			want:      "BTC-USD",
+		},
+		{
+			name:      "TestCoinbaseSteamDataHandler_normalize binance",
+			dataPoint: vwap.DataPoint{ProductID: "BTCUSDT", Venue: instrument.VenueBinance},
+			want:      "BTC-USDT",
+		},
+		{
+			name:      "TestCoinbaseSteamDataHandler_normalize kraken alias",
+			dataPoint: vwap.DataPoint{ProductID: "XBT/USD", Venue: instrument.VenueKraken},
+			want:      "BTC-USD",
+		},
+		{
+			name:      "TestCoinbaseSteamDataHandler_normalize configured symbol",
+			dataPoint: vwap.DataPoint{ProductID: "XBTUSD.M", Venue: instrument.VenueKraken},
+			want:      "BTC-USD",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewStreamDataHandler(5, nil)
+			h.SetRegistry(registry)
+
+			if got := h.normalize(tt.dataPoint); got.ProductID != tt.want {
+				t.Errorf("normalize() = %v, want %v", got.ProductID, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyWindow is the default number of the most recent samples the latency percentiles are computed over.
+const DefaultLatencyWindow = 1000
+
+// LatencyStage is a stage of a message measured from the time it was received at, or from the exchange time of the
+// trade for LatencyExchange.
+type LatencyStage int
+
+const (
+	// LatencyExchange is the latency from the exchange time of the trade to receiving its message. It depends on the
+	// clock of the exchange, a skewed clock can make it negative.
+	LatencyExchange LatencyStage = iota
+	// LatencyUpdate is the latency from receiving the message to updating the VWAP of its product.
+	LatencyUpdate
+	// LatencyDelivery is the latency from receiving the message to delivering the updated VWAP to the sink, the
+	// MessagePipelineFunc of the handler.
+	LatencyDelivery
+	latencyStages
+)
+
+func (s LatencyStage) String() string {
+	switch s {
+	case LatencyExchange:
+		return "exchange"
+	case LatencyUpdate:
+		return "update"
+	case LatencyDelivery:
+		return "delivery"
+	default:
+		return "unknown"
+	}
+}
+
+// LatencyStats are the percentiles of the latency samples of a stage in the rolling window. Count is the number of
+// the samples in the window.
+type LatencyStats struct {
+	Count int
+	P50   time.Duration
+	P99   time.Duration
+}
+
+// ProductLatency are the latency percentiles of every stage of the messages of a product.
+type ProductLatency struct {
+	ProductID string
+	Exchange  LatencyStats
+	Update    LatencyStats
+	Delivery  LatencyStats
+}
+
+// latencySamples is a ring buffer of the most recent samples.
+type latencySamples struct {
+	samples []time.Duration
+	next    int
+}
+
+func (r *latencySamples) add(window int, sample time.Duration) {
+	if len(r.samples) < window {
+		r.samples = append(r.samples, sample)
+		return
+	}
+
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % window
+}
+
+func (r *latencySamples) stats() LatencyStats {
+	if len(r.samples) == 0 {
+		return LatencyStats{}
+	}
+
+	sorted := make([]time.Duration, len(r.samples))
+	copy(sorted, r.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return LatencyStats{
+		Count: len(sorted),
+		P50:   percentile(sorted, 0.5),
+		P99:   percentile(sorted, 0.99),
+	}
+}
+
+// percentile returns the nearest-rank percentile of the sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
+
+// LatencyTracker keeps the rolling latency percentiles of every stage per product, over the last window samples. A nil
+// tracker records nothing.
+type LatencyTracker struct {
+	window   int
+	products map[string]*[latencyStages]latencySamples
+	mu       sync.Mutex
+}
+
+func NewLatencyTracker(window int) *LatencyTracker {
+	if window <= 0 {
+		window = DefaultLatencyWindow
+	}
+
+	return &LatencyTracker{
+		window:   window,
+		products: make(map[string]*[latencyStages]latencySamples),
+	}
+}
+
+// Record adds a latency sample of the stage of the product's messages.
+func (t *LatencyTracker) Record(productID string, stage LatencyStage, latency time.Duration) {
+	if t == nil || stage < 0 || stage >= latencyStages {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples, ok := t.products[productID]
+	if !ok {
+		samples = &[latencyStages]latencySamples{}
+		t.products[productID] = samples
+	}
+
+	samples[stage].add(t.window, latency)
+}
+
+// Latency returns the latency percentiles of the product, false when none of its messages has been recorded.
+func (t *LatencyTracker) Latency(productID string) (ProductLatency, bool) {
+	if t == nil {
+		return ProductLatency{}, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples, ok := t.products[productID]
+	if !ok {
+		return ProductLatency{}, false
+	}
+
+	return ProductLatency{
+		ProductID: productID,
+		Exchange:  samples[LatencyExchange].stats(),
+		Update:    samples[LatencyUpdate].stats(),
+		Delivery:  samples[LatencyDelivery].stats(),
+	}, true
+}
+
+// Latencies returns the latency percentiles of every recorded product, sorted by the product id.
+func (t *LatencyTracker) Latencies() []ProductLatency {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	productIDs := make([]string, 0, len(t.products))
+	for productID := range t.products {
+		productIDs = append(productIDs, productID)
+	}
+	t.mu.Unlock()
+
+	sort.Strings(productIDs)
+
+	latencies := make([]ProductLatency, 0, len(productIDs))
+	for _, productID := range productIDs {
+		if latency, ok := t.Latency(productID); ok {
+			latencies = append(latencies, latency)
+		}
+	}
+
+	return latencies
+}
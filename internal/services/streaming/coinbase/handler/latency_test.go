@@ -0,0 +1,245 @@
+//go:build all
+// +build all
+
+package handler
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+	"github.com/sirupsen/logrus"
+)
+
+// channelStreamer is a streamer that pipes the feeds sent to its source until its context is done.
+type channelStreamer struct {
+	ctx    context.Context
+	source chan interface{}
+}
+
+func (s *channelStreamer) GetContext() context.Context     { return s.ctx }
+func (s *channelStreamer) GetClient() *wsclient.Client     { return nil }
+func (s *channelStreamer) SetLogger(logger *logrus.Logger) {}
+func (s *channelStreamer) Stop()                           {}
+func (s *channelStreamer) Stream(feeds chan interface{}) error {
+	go func() {
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case feed := <-s.source:
+				select {
+				case feeds <- feed:
+				case <-s.ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func TestLatencyTracker_Latency(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  int
+		samples []time.Duration
+		want    LatencyStats
+	}{
+		// Add TestLatencyTracker_Latency test cases.
+		{
+			name:    "TestLatencyTracker_Latency single sample",
+			window:  10,
+			samples: []time.Duration{5 * time.Millisecond},
+			want:    LatencyStats{Count: 1, P50: 5 * time.Millisecond, P99: 5 * time.Millisecond},
+		},
+		{
+			name:    "TestLatencyTracker_Latency unordered samples",
+			window:  10,
+			samples: []time.Duration{4, 1, 3, 2},
+			want:    LatencyStats{Count: 4, P50: 2, P99: 4},
+		},
+		{
+			name:    "TestLatencyTracker_Latency rolling window",
+			window:  3,
+			samples: []time.Duration{100, 200, 1, 2, 3},
+			want:    LatencyStats{Count: 3, P50: 2, P99: 3},
+		},
+		{
+			name:    "TestLatencyTracker_Latency default window",
+			samples: []time.Duration{-1, 1},
+			want:    LatencyStats{Count: 2, P50: -1, P99: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewLatencyTracker(tt.window)
+			for _, sample := range tt.samples {
+				tracker.Record("BTC-USD", LatencyUpdate, sample)
+			}
+
+			got, ok := tracker.Latency("BTC-USD")
+			if !ok {
+				t.Fatalf("Latency() not found")
+			}
+
+			if !reflect.DeepEqual(got.Update, tt.want) {
+				t.Errorf("Latency() update = %+v, want %+v", got.Update, tt.want)
+			}
+
+			if got.Exchange.Count != 0 || got.Delivery.Count != 0 {
+				t.Errorf("Latency() = %+v, want only the update samples", got)
+			}
+		})
+	}
+}
+
+func TestLatencyTracker_Latencies(t *testing.T) {
+	tracker := NewLatencyTracker(10)
+	tracker.Record("ETH-USD", LatencyDelivery, time.Millisecond)
+	tracker.Record("BTC-USD", LatencyExchange, time.Second)
+	tracker.Record("BTC-USD", latencyStages, time.Second)
+
+	got := tracker.Latencies()
+	want := []ProductLatency{
+		{ProductID: "BTC-USD", Exchange: LatencyStats{Count: 1, P50: time.Second, P99: time.Second}},
+		{ProductID: "ETH-USD", Delivery: LatencyStats{Count: 1, P50: time.Millisecond, P99: time.Millisecond}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Latencies() = %+v, want %+v", got, want)
+	}
+
+	if _, ok := tracker.Latency("LTC-USD"); ok {
+		t.Errorf("Latency() of an unrecorded product found")
+	}
+
+	var nilTracker *LatencyTracker
+	nilTracker.Record("BTC-USD", LatencyUpdate, time.Second)
+
+	if nilTracker.Latencies() != nil {
+		t.Errorf("Latencies() of a nil tracker = %v, want nil", nilTracker.Latencies())
+	}
+}
+
+func TestCoinbaseSteamDataHandler_recordLatency(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		dataPoint vwap.DataPoint
+		stage     LatencyStage
+		want      []int
+	}{
+		// Add TestCoinbaseSteamDataHandler_recordLatency test cases.
+		{
+			name:      "TestCoinbaseSteamDataHandler_recordLatency update",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD", Time: now.Add(-time.Second), ReceivedAt: now},
+			stage:     LatencyUpdate,
+			want:      []int{1, 1, 0},
+		},
+		{
+			name:      "TestCoinbaseSteamDataHandler_recordLatency delivery",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD", Time: now.Add(-time.Second), ReceivedAt: now},
+			stage:     LatencyDelivery,
+			want:      []int{0, 0, 1},
+		},
+		{
+			name:      "TestCoinbaseSteamDataHandler_recordLatency no exchange time",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD", ReceivedAt: now},
+			stage:     LatencyUpdate,
+			want:      []int{0, 1, 0},
+		},
+		{
+			name:      "TestCoinbaseSteamDataHandler_recordLatency not received",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD", Time: now},
+			stage:     LatencyUpdate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewStreamDataHandler(5, nil)
+			h.recordLatency(tt.dataPoint, tt.stage)
+
+			got, ok := h.latency.Latency("BTC-USD")
+			if ok != (tt.want != nil) {
+				t.Fatalf("Latency() found = %v, want %v", ok, tt.want != nil)
+			}
+
+			if !ok {
+				return
+			}
+
+			counts := []int{got.Exchange.Count, got.Update.Count, got.Delivery.Count}
+			if !reflect.DeepEqual(counts, tt.want) {
+				t.Errorf("Latency() counts = %v, want %v", counts, tt.want)
+			}
+
+			if got.Exchange.Count == 1 && got.Exchange.P50 != time.Second {
+				t.Errorf("Latency() exchange = %v, want %v", got.Exchange.P50, time.Second)
+			}
+		})
+	}
+}
+
+func TestCoinbaseSteamDataHandler_Handle_latency(t *testing.T) {
+	h := NewStreamDataHandler(5, nil)
+	h.SetLogger(logger)
+
+	delivered := make(chan struct{}, 1)
+	h.SetMessageBlockerFunc(func(s *vwap.SlidingWindow) error {
+		delivered <- struct{}{}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feeds := make(chan interface{}, 1)
+	h.SetStreamer(&channelStreamer{ctx: ctx, source: feeds})
+
+	if err := h.Handle(); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	now := time.Now()
+	feeds <- vwap.DataPoint{
+		Type:       "match",
+		TradeID:    1,
+		Size:       big.NewFloat(1),
+		Price:      big.NewFloat(100),
+		ProductID:  "BTC-USD",
+		Time:       now.Add(-time.Second),
+		ReceivedAt: now,
+	}
+
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatalf("MessagePipelineFunc() not called")
+	}
+
+	// The delivery is recorded right after the pipeline returns.
+	deadline := time.Now().Add(time.Second)
+	for {
+		latencies := h.Latencies()
+		if len(latencies) == 1 && latencies[0].Delivery.Count == 1 {
+			if latencies[0].ProductID != "BTC-USD" || latencies[0].Update.Count != 1 {
+				t.Errorf("Latencies() = %+v, want one BTC-USD update", latencies)
+			}
+
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Latencies() = %+v, want one BTC-USD delivery", latencies)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+)
+
+// Normalize replaces the venue symbol of the datapoint with the name of its canonical instrument in the registry, so
+// that the same instrument is handled as one whatever the venue calls it. The datapoints without a venue are Coinbase
+// ones. Normalizing an already normalized datapoint leaves it unchanged.
+func Normalize(registry *instrument.Registry, dataPoint vwap.DataPoint) vwap.DataPoint {
+	if dataPoint.Venue == "" {
+		dataPoint.Venue = instrument.VenueCoinbase
+	}
+
+	if registry == nil {
+		return dataPoint
+	}
+
+	dataPoint.ProductID = registry.ResolveOrRaw(dataPoint.Venue, dataPoint.ProductID).String()
+
+	return dataPoint
+}
+
+// TradeDeduper detects the datapoints of the already processed trades by the last trade id of every product of every
+// venue. It's not safe for concurrent use, the handlers call it under their own lock.
+type TradeDeduper struct {
+	lastTradeIDs map[string]int
+}
+
+func NewTradeDeduper() *TradeDeduper {
+	return &TradeDeduper{lastTradeIDs: make(map[string]int)}
+}
+
+// Add records the trade of a normalized datapoint, it returns ErrDuplicateTrade when the trade has already been
+// processed. Trade ids are increasing per product of a venue, anything at or below the last seen id is a duplicate.
+// The datapoints without a trade id are never duplicates.
+func (d *TradeDeduper) Add(dataPoint vwap.DataPoint) error {
+	if dataPoint.TradeID == 0 {
+		return nil
+	}
+
+	tradeKey := tradeKey(dataPoint.Venue, dataPoint.ProductID)
+	if dataPoint.TradeID <= d.lastTradeIDs[tradeKey] {
+		return ErrDuplicateTrade
+	}
+
+	d.lastTradeIDs[tradeKey] = dataPoint.TradeID
+
+	return nil
+}
+
+// Remove forgets the last trade id of a product of a venue.
+func (d *TradeDeduper) Remove(venue string, productID string) {
+	delete(d.lastTradeIDs, tradeKey(venue, productID))
+}
+
+func tradeKey(venue string, productID string) string {
+	return venue + ":" + productID
+}
@@ -0,0 +1,61 @@
+//go:build all
+// +build all
+
+package handler
+
+import (
+	"errors"
+	"testing"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+)
+
+func TestTradeDeduper_Add(t *testing.T) {
+	d := NewTradeDeduper()
+
+	tests := []struct {
+		name      string
+		dataPoint vwap.DataPoint
+		wantErr   error
+	}{
+		// Add TestTradeDeduper_Add test cases.
+		{
+			name:      "TestTradeDeduper_Add first",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD", Venue: instrument.VenueCoinbase, TradeID: 2},
+		},
+		{
+			name:      "TestTradeDeduper_Add same id",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD", Venue: instrument.VenueCoinbase, TradeID: 2},
+			wantErr:   ErrDuplicateTrade,
+		},
+		{
+			name:      "TestTradeDeduper_Add older id",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD", Venue: instrument.VenueCoinbase, TradeID: 1},
+			wantErr:   ErrDuplicateTrade,
+		},
+		{
+			name:      "TestTradeDeduper_Add other venue",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD", Venue: instrument.VenueKraken, TradeID: 1},
+		},
+		{
+			name:      "TestTradeDeduper_Add no trade id",
+			dataPoint: vwap.DataPoint{ProductID: "BTC-USD", Venue: instrument.VenueCoinbase},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := d.Add(tt.dataPoint); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A removed product starts over.
+	d.Remove(instrument.VenueCoinbase, "BTC-USD")
+
+	err := d.Add(vwap.DataPoint{ProductID: "BTC-USD", Venue: instrument.VenueCoinbase, TradeID: 1})
+	if err != nil {
+		t.Errorf("Add() after Remove() error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,117 @@
+package coinbase
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/rest"
+	"github.com/sirupsen/logrus"
+)
+
+// ProductStatusOnline is the status of a product that is currently trading.
+const ProductStatusOnline = "online"
+
+// Product is a trading pair listed on Coinbase, as returned by the /products endpoint.
+type Product struct {
+	ID              string `json:"id"`
+	BaseCurrency    string `json:"base_currency"`
+	QuoteCurrency   string `json:"quote_currency"`
+	Status          string `json:"status"`
+	TradingDisabled bool   `json:"trading_disabled"`
+}
+
+// IsOnline reports whether the product is online and open for trading.
+func (p Product) IsOnline() bool {
+	return p.Status == ProductStatusOnline && !p.TradingDisabled
+}
+
+// ProductLister is the interface for listing the products available for subscription.
+type ProductLister interface {
+	GetProducts() ([]Product, error)
+}
+
+// ProductCatalog lists the products from the Coinbase REST API. When a cache file is set, every successfully fetched
+// list is written to it, and the cached list is used when the REST API can't be reached.
+type ProductCatalog struct {
+	client    *rest.Client
+	cacheFile string
+	logger    *logrus.Logger
+}
+
+func NewProductCatalog(ctx context.Context, restURL string) *ProductCatalog {
+	return &ProductCatalog{
+		client: rest.NewClient(ctx, restURL),
+		logger: logrus.New(),
+	}
+}
+
+func (p *ProductCatalog) SetLogger(logger *logrus.Logger) {
+	p.logger = logger
+	p.client.SetLogger(logger)
+}
+
+func (p *ProductCatalog) SetCacheFile(cacheFile string) {
+	p.cacheFile = cacheFile
+}
+
+// GetProducts fetches the list of the products, it falls back to the cache file on request errors.
+func (p *ProductCatalog) GetProducts() ([]Product, error) {
+	var products []Product
+
+	_, err := p.client.Get("/products", nil, &products)
+	if err != nil {
+		if p.cacheFile == "" {
+			return nil, err
+		}
+
+		p.logger.Warnf("Error fetching products %s, using the cached products %s", err, p.cacheFile)
+
+		return ProductsFile(p.cacheFile).GetProducts()
+	}
+
+	if p.cacheFile != "" {
+		err = SaveProductsFile(p.cacheFile, products)
+		if err != nil {
+			p.logger.Errorf("Error caching products to %s: %s", p.cacheFile, err)
+		}
+	}
+
+	return products, nil
+}
+
+// ProductsFile lists the products from a JSON file in the same format as the /products endpoint response.
+type ProductsFile string
+
+func (f ProductsFile) GetProducts() ([]Product, error) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+
+	var products []Product
+
+	err = json.Unmarshal(data, &products)
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// SaveProductsFile writes the products to a JSON file that can be read by ProductsFile.
+func SaveProductsFile(path string, products []Product) error {
+	data, err := json.Marshal(products)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
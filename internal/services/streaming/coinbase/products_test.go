@@ -0,0 +1,80 @@
+//go:build all
+// +build all
+
+package coinbase
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProductCatalog_GetProducts(t *testing.T) {
+	online := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !online || r.URL.Path != "/products" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = w.Write([]byte(`[
+			{"id":"BTC-USD","base_currency":"BTC","quote_currency":"USD","status":"online","trading_disabled":false},
+			{"id":"ETH-BTC","base_currency":"ETH","quote_currency":"BTC","status":"online","trading_disabled":false}
+		]`))
+	}))
+	defer server.Close()
+
+	want := []Product{
+		{ID: "BTC-USD", BaseCurrency: "BTC", QuoteCurrency: "USD", Status: "online"},
+		{ID: "ETH-BTC", BaseCurrency: "ETH", QuoteCurrency: "BTC", Status: "online"},
+	}
+	cacheFile := filepath.Join(t.TempDir(), "products.json")
+
+	tests := []struct {
+		name      string
+		online    bool
+		cacheFile string
+		want      []Product
+		wantErr   bool
+	}{
+		// Add TestProductCatalog_GetProducts test cases, the cases share the same cache file in order.
+		{
+			name:    "TestProductCatalog_GetProducts offline without cache",
+			online:  false,
+			wantErr: true,
+		},
+		{
+			name:      "TestProductCatalog_GetProducts online",
+			online:    true,
+			cacheFile: cacheFile,
+			want:      want,
+		},
+		{
+			name:      "TestProductCatalog_GetProducts offline from cache",
+			online:    false,
+			cacheFile: cacheFile,
+			want:      want,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			online = tt.online
+
+			catalog := NewProductCatalog(context.Background(), server.URL)
+			catalog.SetCacheFile(tt.cacheFile)
+
+			got, err := catalog.GetProducts()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetProducts() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetProducts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
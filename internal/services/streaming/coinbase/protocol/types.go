@@ -0,0 +1,64 @@
+// Package protocol is the wire protocol of the Coinbase websocket feed, shared by the streamer and the fake server.
+package protocol
+
+import (
+	"math/big"
+	"time"
+)
+
+const (
+	RequestTypeSubscribe   = "subscribe"
+	RequestTypeUnsubscribe = "unsubscribe"
+	ChannelMatches         = "matches"
+)
+
+const (
+	FeedTypeMatch          = "match"
+	FeedTypeSubscribeError = "error"
+	FeedTypeLastMatch      = "last_match"
+	FeedTypeLevel2Snapshot = "l2update"
+	FeedTypeTicker         = "ticker"
+	FeedTypeSubscriptions  = "subscriptions"
+)
+
+type SubscribeRequest struct {
+	Type       string    `json:"type"`
+	ProductIds []string  `json:"product_ids"`
+	Channels   []Channel `json:"channels"`
+}
+
+type Channel struct {
+	Name       string   `json:"name"`
+	ProductIds []string `json:"product_ids"`
+}
+
+// Feed is a message of the matches channel. ReceivedAt is the time the message was received at, it isn't part of the
+// message.
+type Feed struct {
+	Type         string     `json:"type"`
+	TradeID      int        `json:"trade_id"`
+	MakerOrderID string     `json:"maker_order_id"`
+	TakerOrderID string     `json:"taker_order_id"`
+	Side         string     `json:"side"`
+	Size         *big.Float `json:"size"`
+	Price        *big.Float `json:"price"`
+	ProductID    string     `json:"product_id"`
+	Sequence     int64      `json:"sequence"`
+	Time         time.Time  `json:"time"`
+	Reason       string     `json:"reason,omitempty"`
+	ReceivedAt   time.Time  `json:"-"`
+}
+
+// NewMatchesRequest builds a subscribe or unsubscribe request of the matches channel for the given products.
+func NewMatchesRequest(requestType string, productIds []string) SubscribeRequest {
+	return SubscribeRequest{
+		Type:       requestType,
+		ProductIds: productIds,
+		Channels: []Channel{
+			{
+				Name:       ChannelMatches,
+				ProductIds: productIds,
+			},
+		},
+	}
+}
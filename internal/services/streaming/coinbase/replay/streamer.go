@@ -0,0 +1,290 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/recorder"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SpeedRealtime replays the messages at the pace they were sent.
+	SpeedRealtime = 1.0
+	// SpeedFastest replays the messages as fast as they're consumed.
+	SpeedFastest = 0.0
+	// MaxLineSize is the maximum size of a recorded message.
+	MaxLineSize = 16 * 1024 * 1024
+)
+
+// gzipMagic is the header of the gzip compressed files.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Streamer is a streaming service replaying the raw Coinbase messages recorded in JSON lines files, optionally gzip
+// compressed, such as the files written by the recorder, instead of streaming them from the websocket. It implements
+// the streaming.Streamer interface. The path is a file, a glob pattern, or a directory of the recorder's rotated
+// files, the files are replayed one after the other in the order of their names, see Files.
+// The matches are paced by their time field: at the recorded pace, accelerated by the speed factor, or as fast as
+// they're consumed with the speed SpeedFastest. Replaying the same files always pipes the same feeds in the same order.
+type Streamer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	path   string
+	speed  float64
+	done   chan struct{}
+	err    error
+	mu     sync.Mutex
+	logger *logrus.Logger
+}
+
+func NewStreamer(ctx context.Context, path string, speed float64) *Streamer {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Streamer{
+		ctx:    ctx,
+		cancel: cancel,
+		path:   path,
+		speed:  speed,
+		done:   make(chan struct{}),
+		logger: logrus.New(),
+	}
+}
+
+func (s *Streamer) SetLogger(logger *logrus.Logger) {
+	s.logger = logger
+}
+
+func (s *Streamer) GetContext() context.Context {
+	return s.ctx
+}
+
+// GetClient returns nil, the replay has no websocket client.
+func (s *Streamer) GetClient() *wsclient.Client {
+	return nil
+}
+
+// Done is closed once all the files have been replayed, or the replay has been stopped.
+func (s *Streamer) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that ended the replay early, if any.
+func (s *Streamer) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Stop stops the replay.
+func (s *Streamer) Stop() {
+	s.cancel()
+}
+
+// Stream resolves the recorded files and starts piping their matches to the streamFeeds channel.
+func (s *Streamer) Stream(streamFeeds chan interface{}) error {
+	files, err := Files(s.path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(s.done)
+
+		err := s.replayFiles(files, streamFeeds)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			s.logger.Errorf("Error replaying %s %s", s.path, err)
+
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+// replayFiles replays the files one after the other, the pace carries over from one file to the next.
+func (s *Streamer) replayFiles(files []string, streamFeeds chan interface{}) error {
+	var previous time.Time
+
+	for _, path := range files {
+		reader, closer, err := Open(path)
+		if err != nil {
+			return err
+		}
+
+		previous, err = s.replay(reader, streamFeeds, previous)
+		closer.Close()
+
+		if err != nil {
+			return fmt.Errorf("replay %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// replay pipes the matches of the reader, paced from the time of the previous match, and returns the time of its
+// last match.
+func (s *Streamer) replay(reader io.Reader, streamFeeds chan interface{}, previous time.Time) (time.Time, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+
+	var line int
+
+	for scanner.Scan() {
+		line++
+
+		message := bytes.TrimSpace(scanner.Bytes())
+		if len(message) == 0 {
+			continue
+		}
+
+		feed, err := DecodeMessage(message)
+		if err != nil {
+			s.logger.Errorf("Error unmarshalling line %d %s", line, err)
+			continue
+		}
+
+		if feed.Type != coinbase.FeedTypeMatch && feed.Type != coinbase.FeedTypeLastMatch {
+			continue
+		}
+
+		err = s.pace(previous, feed.Time)
+		if err != nil {
+			return previous, err
+		}
+
+		if !feed.Time.IsZero() {
+			previous = feed.Time
+		}
+
+		select {
+		case streamFeeds <- feed:
+		case <-s.ctx.Done():
+			return previous, s.ctx.Err()
+		}
+	}
+
+	return previous, scanner.Err()
+}
+
+// pace waits for the time between the previous and the current message divided by the speed.
+func (s *Streamer) pace(previous time.Time, current time.Time) error {
+	if s.speed <= SpeedFastest || previous.IsZero() || !current.After(previous) {
+		return s.ctx.Err()
+	}
+
+	timer := time.NewTimer(time.Duration(float64(current.Sub(previous)) / s.speed))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// DecodeMessage decodes a recorded raw Coinbase message, either as it was sent, or wrapped in the recorder's
+// envelope with its receive time.
+func DecodeMessage(message []byte) (coinbase.Feed, error) {
+	envelope := recorder.Envelope{}
+
+	err := json.Unmarshal(message, &envelope)
+	if err != nil {
+		return coinbase.Feed{}, err
+	}
+
+	if len(envelope.Message) > 0 && !envelope.Received.IsZero() {
+		message = envelope.Message
+	}
+
+	feed := coinbase.Feed{}
+
+	err = json.Unmarshal(message, &feed)
+	if err != nil {
+		return coinbase.Feed{}, err
+	}
+
+	return feed, nil
+}
+
+// Files returns the recorded files of the path, sorted by their names. The path is a single file, a glob pattern such
+// as "records/coinbase-*.jsonl.gz", or a directory whose recorder files are all returned. The names of the recorder's
+// rotated files sort in the order they were written.
+func Files(path string) ([]string, error) {
+	pattern := path
+
+	if !strings.ContainsAny(path, "*?[") {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			return []string{path}, nil
+		}
+
+		pattern = filepath.Join(path, "*"+recorder.FileExtension)
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recorded files match %s", pattern)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// Open opens a recorded file, the gzip compressed files are detected by their header and decompressed.
+func Open(path string) (io.Reader, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buffered := bufio.NewReader(file)
+
+	header, err := buffered.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		file.Close()
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(header, gzipMagic) {
+		return buffered, file, nil
+	}
+
+	gzipReader, err := gzip.NewReader(buffered)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("open gzip %s: %w", path, err)
+	}
+
+	// Reading the concatenated members lets the recorder append to a compressed file.
+	gzipReader.Multistream(true)
+
+	return gzipReader, file, nil
+}
@@ -0,0 +1,339 @@
+//go:build all
+// +build all
+
+package replay
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/handler"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/recorder"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/goleak"
+)
+
+const testReplayFile = "../../../../../tests/data/replay_coinbase.jsonl"
+
+// gzipFile compresses the file into the test's temp directory.
+func gzipFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed %v", err)
+	}
+
+	gzipPath := filepath.Join(t.TempDir(), filepath.Base(path)+".gz")
+
+	file, err := os.Create(gzipPath)
+	if err != nil {
+		t.Fatalf("Create failed %v", err)
+	}
+	defer file.Close()
+
+	writer := gzip.NewWriter(file)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("Write failed %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+
+	return gzipPath
+}
+
+// recordFile records the lines of the file with the recorder, and returns the recorded file.
+func recordFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed %v", err)
+	}
+
+	r, err := recorder.NewRecorder(recorder.NewConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		r.Record(line, time.Now())
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	return r.Files()[0]
+}
+
+// replayVwaps replays the file through a handler and returns the VWAPs of the products after each match, and how
+// long the replay took.
+func replayVwaps(t *testing.T, path string, speed float64, wantCount int) ([]string, time.Duration) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewStreamer(ctx, path, speed)
+	s.SetLogger(logger)
+
+	vwaps := make(chan string, wantCount)
+
+	h := handler.NewStreamDataHandler(5, nil)
+	h.SetLogger(logger)
+	h.SetStreamer(s)
+	h.SetMessageBlockerFunc(func(w *vwap.SlidingWindow) error {
+		vwaps <- w.CurrencyPair() + ":" + w.GetCalculator().Avg().Text('f', 8)
+		return nil
+	})
+
+	start := time.Now()
+	if err := h.Handle(); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	got := make([]string, 0, wantCount)
+	for len(got) < wantCount {
+		select {
+		case v := <-vwaps:
+			got = append(got, v)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Handle() timed out after %d vwaps", len(got))
+		}
+	}
+
+	elapsed := time.Since(start)
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stream() didn't finish")
+	}
+
+	if err := s.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+
+	return got, elapsed
+}
+
+func TestStreamer_Stream(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	want := []string{
+		"BTC-USD:40129.67000000",
+		"ETH-USD:3005.71000000",
+		"BTC-USD:40130.00933572",
+		"ETH-USD:3006.01392157",
+		"BTC-USD:40128.58894802",
+		"ETH-USD:3005.64892045",
+	}
+
+	type args struct {
+		path  string
+		speed float64
+	}
+
+	tests := []struct {
+		name        string
+		args        args
+		minDuration time.Duration
+	}{
+		// Add TestStreamer_Stream test cases.
+		{
+			name: "TestStreamer_Stream fastest",
+			args: args{path: testReplayFile, speed: SpeedFastest},
+		},
+		{
+			name: "TestStreamer_Stream gzip",
+			args: args{path: gzipFile(t, testReplayFile), speed: SpeedFastest},
+		},
+		{
+			name: "TestStreamer_Stream recorded",
+			args: args{path: recordFile(t, testReplayFile), speed: SpeedFastest},
+		},
+		{
+			name:        "TestStreamer_Stream realtime",
+			args:        args{path: testReplayFile, speed: SpeedRealtime},
+			minDuration: 40 * time.Millisecond,
+		},
+		{
+			name: "TestStreamer_Stream accelerated",
+			args: args{path: testReplayFile, speed: 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, elapsed := replayVwaps(t, tt.args.path, tt.args.speed, len(want))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Stream() = %v, want %v", got, want)
+			}
+
+			if elapsed < tt.minDuration {
+				t.Errorf("Stream() took %v, want at least %v", elapsed, tt.minDuration)
+			}
+		})
+	}
+}
+
+func TestStreamer_Stream_deterministic(t *testing.T) {
+	first, _ := replayVwaps(t, testReplayFile, SpeedFastest, 6)
+	second, _ := replayVwaps(t, testReplayFile, SpeedFastest, 6)
+
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Stream() = %v, then %v", first, second)
+	}
+}
+
+func TestStreamer_Stream_missingFile(t *testing.T) {
+	s := NewStreamer(context.Background(), "../../../../../tests/data/missing.jsonl", SpeedFastest)
+	if err := s.Stream(make(chan interface{})); err == nil {
+		t.Errorf("Stream() expected an error for a missing file")
+	}
+
+	s.Stop()
+}
+
+func TestStreamer_Stop(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	s := NewStreamer(context.Background(), testReplayFile, SpeedFastest)
+	if err := s.Stream(make(chan interface{})); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+
+	s.Stop()
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop() didn't stop the replay")
+	}
+
+	if err := s.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after Stop()", err)
+	}
+}
+
+// rotatedFiles splits the lines of the file into gzip compressed files named like the recorder's rotated files, and
+// returns their directory.
+func rotatedFiles(t *testing.T, path string, count int) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	dir := t.TempDir()
+	size := (len(lines) + count - 1) / count
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		name := fmt.Sprintf("%s-20220601T120000Z-%04d%s", recorder.DefaultPrefix, i+1, recorder.FileExtension)
+
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Create failed %v", err)
+		}
+
+		writer := gzip.NewWriter(file)
+		if _, err := writer.Write([]byte(strings.Join(lines[i*size:end], "\n") + "\n")); err != nil {
+			t.Fatalf("Write failed %v", err)
+		}
+
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Close failed %v", err)
+		}
+
+		file.Close()
+	}
+
+	return dir
+}
+
+func TestFiles(t *testing.T) {
+	dir := rotatedFiles(t, testReplayFile, 3)
+	want := []string{
+		filepath.Join(dir, recorder.DefaultPrefix+"-20220601T120000Z-0001"+recorder.FileExtension),
+		filepath.Join(dir, recorder.DefaultPrefix+"-20220601T120000Z-0002"+recorder.FileExtension),
+		filepath.Join(dir, recorder.DefaultPrefix+"-20220601T120000Z-0003"+recorder.FileExtension),
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		// Add TestFiles test cases.
+		{
+			name: "TestFiles file",
+			path: testReplayFile,
+			want: []string{testReplayFile},
+		},
+		{
+			name: "TestFiles directory",
+			path: dir,
+			want: want,
+		},
+		{
+			name: "TestFiles glob",
+			path: filepath.Join(dir, recorder.DefaultPrefix+"-*-000[23]"+recorder.FileExtension),
+			want: want[1:],
+		},
+		{
+			name:    "TestFiles missing file",
+			path:    filepath.Join(dir, "missing.jsonl"),
+			wantErr: true,
+		},
+		{
+			name:    "TestFiles no match",
+			path:    filepath.Join(dir, "kraken-*"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Files(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Files() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Files() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamer_Stream_rotated(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	want, _ := replayVwaps(t, testReplayFile, SpeedFastest, 6)
+
+	// The rotated files of a recording replay like the single file.
+	got, _ := replayVwaps(t, rotatedFiles(t, testReplayFile, 3), SpeedFastest, 6)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Stream() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package coinbase
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// ProductSelector selects the products to subscribe to from a list of products by a set of rules.
+// Include and Exclude hold product id patterns with the shell wildcards `*`, `?` and `[...]`, e.g. `*-USD` or `BTC-*`.
+// A product is selected when it's online, it matches at least one of the Include patterns (or Include is empty), its
+// quote currency is one of the Quotes (or Quotes is empty), and it doesn't match any of the Exclude patterns.
+type ProductSelector struct {
+	Include []string
+	Exclude []string
+	Quotes  []string
+}
+
+// IsPattern reports whether the product id contains any wildcard and needs to be resolved against a products list.
+func IsPattern(productID string) bool {
+	return strings.ContainsAny(productID, "*?[")
+}
+
+// Select returns the sorted ids of the selected products.
+func (ps ProductSelector) Select(products []Product) []string {
+	selected := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, product := range products {
+		if !product.IsOnline() || seen[product.ID] || !ps.Matches(product) {
+			continue
+		}
+
+		seen[product.ID] = true
+		selected = append(selected, product.ID)
+	}
+
+	sort.Strings(selected)
+
+	return selected
+}
+
+// Matches reports whether the product satisfies the include, exclude and quote rules, regardless of its status.
+func (ps ProductSelector) Matches(product Product) bool {
+	if len(ps.Include) > 0 && !matchAny(ps.Include, product.ID) {
+		return false
+	}
+
+	if len(ps.Quotes) > 0 && !containsFold(ps.Quotes, product.QuoteCurrency) {
+		return false
+	}
+
+	return !matchAny(ps.Exclude, product.ID)
+}
+
+func matchAny(patterns []string, productID string) bool {
+	productID = strings.ToUpper(productID)
+
+	for _, pattern := range patterns {
+		matched, err := path.Match(strings.ToUpper(strings.TrimSpace(pattern)), productID)
+		if err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DiffProducts compares the previous and the current product id sets, and returns the added and removed ids.
+func DiffProducts(previous []string, current []string) (added []string, removed []string) {
+	previousSet := make(map[string]bool, len(previous))
+	for _, productID := range previous {
+		previousSet[productID] = true
+	}
+
+	currentSet := make(map[string]bool, len(current))
+	for _, productID := range current {
+		currentSet[productID] = true
+		if !previousSet[productID] {
+			added = append(added, productID)
+		}
+	}
+
+	for _, productID := range previous {
+		if !currentSet[productID] {
+			removed = append(removed, productID)
+		}
+	}
+
+	return added, removed
+}
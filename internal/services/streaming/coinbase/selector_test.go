@@ -0,0 +1,123 @@
+//go:build all
+// +build all
+
+package coinbase
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testProducts = []Product{
+	{ID: "BTC-USD", BaseCurrency: "BTC", QuoteCurrency: "USD", Status: "online"},
+	{ID: "ETH-USD", BaseCurrency: "ETH", QuoteCurrency: "USD", Status: "online"},
+	{ID: "ETH-BTC", BaseCurrency: "ETH", QuoteCurrency: "BTC", Status: "online"},
+	{ID: "USDT-USD", BaseCurrency: "USDT", QuoteCurrency: "USD", Status: "online"},
+	{ID: "BTC-EUR", BaseCurrency: "BTC", QuoteCurrency: "EUR", Status: "online"},
+	{ID: "LUNA-USD", BaseCurrency: "LUNA", QuoteCurrency: "USD", Status: "delisted"},
+	{ID: "DOGE-USD", BaseCurrency: "DOGE", QuoteCurrency: "USD", Status: "online", TradingDisabled: true},
+}
+
+func TestProductSelector_Select(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector ProductSelector
+		want     []string
+	}{
+		// Add TestProductSelector_Select test cases.
+		{
+			name:     "TestProductSelector_Select wildcard",
+			selector: ProductSelector{Include: []string{"*-USD"}},
+			want:     []string{"BTC-USD", "ETH-USD", "USDT-USD"},
+		},
+		{
+			name:     "TestProductSelector_Select quote",
+			selector: ProductSelector{Quotes: []string{"usd", "EUR"}},
+			want:     []string{"BTC-EUR", "BTC-USD", "ETH-USD", "USDT-USD"},
+		},
+		{
+			name:     "TestProductSelector_Select exclude",
+			selector: ProductSelector{Include: []string{"*-USD", "eth-*"}, Exclude: []string{"USDT-*"}},
+			want:     []string{"BTC-USD", "ETH-BTC", "ETH-USD"},
+		},
+		{
+			name:     "TestProductSelector_Select include and quote",
+			selector: ProductSelector{Include: []string{"BTC-*"}, Quotes: []string{"EUR"}},
+			want:     []string{"BTC-EUR"},
+		},
+		{
+			name:     "TestProductSelector_Select exact ids",
+			selector: ProductSelector{Include: []string{"BTC-USD", "LUNA-USD"}},
+			want:     []string{"BTC-USD"},
+		},
+		{
+			name:     "TestProductSelector_Select no match",
+			selector: ProductSelector{Include: []string{"XRP-*"}},
+			want:     []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selector.Select(testProducts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPattern(t *testing.T) {
+	tests := []struct {
+		name      string
+		productID string
+		want      bool
+	}{
+		// Add TestIsPattern test cases.
+		{name: "TestIsPattern product id", productID: "BTC-USD", want: false},
+		{name: "TestIsPattern star", productID: "*-USD", want: true},
+		{name: "TestIsPattern question mark", productID: "BT?-USD", want: true},
+		{name: "TestIsPattern character class", productID: "[BE]TC-USD", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPattern(tt.productID); got != tt.want {
+				t.Errorf("IsPattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffProducts(t *testing.T) {
+	type args struct {
+		previous []string
+		current  []string
+	}
+	tests := []struct {
+		name        string
+		args        args
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		// Add TestDiffProducts test cases.
+		{
+			name: "TestDiffProducts unchanged",
+			args: args{previous: []string{"BTC-USD", "ETH-USD"}, current: []string{"BTC-USD", "ETH-USD"}},
+		},
+		{
+			name:        "TestDiffProducts added and removed",
+			args:        args{previous: []string{"BTC-USD", "LUNA-USD"}, current: []string{"BTC-USD", "SOL-USD"}},
+			wantAdded:   []string{"SOL-USD"},
+			wantRemoved: []string{"LUNA-USD"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAdded, gotRemoved := DiffProducts(tt.args.previous, tt.args.current)
+			if !reflect.DeepEqual(gotAdded, tt.wantAdded) {
+				t.Errorf("DiffProducts() added = %v, want %v", gotAdded, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(gotRemoved, tt.wantRemoved) {
+				t.Errorf("DiffProducts() removed = %v, want %v", gotRemoved, tt.wantRemoved)
+			}
+		})
+	}
+}
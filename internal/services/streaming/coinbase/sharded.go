@@ -0,0 +1,405 @@
+package coinbase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+// ShardedStreamer is a streaming service for Coinbase that spreads the products over multiple websocket connections.
+// It implements the streaming.Streamer interface. Every shard is a Streamer with its own connection that reconnects on
+// its own, and all the shards pipe their feeds to the same streamFeeds channel.
+type ShardedStreamer struct {
+	ctx                   context.Context
+	cancel                context.CancelFunc
+	wsURL                 string
+	productsPerConnection int
+	shards                []*Streamer
+	shardProducts         [][]string
+	streamFeeds           chan interface{}
+	recorder              streaming.MessageRecorder
+	configurers           []func(index int, client *wsclient.Client) error
+	mu                    sync.Mutex
+	logger                *logrus.Logger
+}
+
+// NewShardedStreamer creates a streamer with at least the given number of connections, more connections are added
+// when productsPerConnection is positive and the products don't fit into them.
+func NewShardedStreamer(
+	ctx context.Context,
+	wsURL string,
+	productIds []string,
+	connections int,
+	productsPerConnection int,
+) *ShardedStreamer {
+	ctx, cancel := context.WithCancel(ctx)
+
+	s := &ShardedStreamer{
+		ctx:                   ctx,
+		cancel:                cancel,
+		wsURL:                 wsURL,
+		productsPerConnection: productsPerConnection,
+		logger:                logrus.New(),
+	}
+
+	for _, products := range ShardProducts(productIds, connections, productsPerConnection) {
+		s.addShard(products)
+	}
+
+	return s
+}
+
+// ShardProducts splits the products into evenly sized shards. The number of shards is the given number of
+// connections, raised to fit the products when productsPerConnection is positive, and capped by the product count.
+func ShardProducts(productIds []string, connections int, productsPerConnection int) [][]string {
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	count := connections
+	if count < 1 {
+		count = 1
+	}
+
+	if productsPerConnection > 0 {
+		needed := (len(productIds) + productsPerConnection - 1) / productsPerConnection
+		if needed > count {
+			count = needed
+		}
+	}
+
+	if count > len(productIds) {
+		count = len(productIds)
+	}
+
+	shards := make([][]string, count)
+	for i, productID := range productIds {
+		shards[i%count] = append(shards[i%count], productID)
+	}
+
+	return shards
+}
+
+func (s *ShardedStreamer) addShard(productIds []string) *Streamer {
+	request, _ := json.Marshal(NewMatchesRequest(RequestTypeSubscribe, productIds))
+
+	shard := NewStreamer(s.ctx, s.wsURL, string(request))
+	shard.SetLogger(s.logger)
+	shard.SetRecorder(s.recorder)
+
+	s.shards = append(s.shards, shard)
+	s.shardProducts = append(s.shardProducts, append([]string{}, productIds...))
+
+	return shard
+}
+
+func (s *ShardedStreamer) SetLogger(logger *logrus.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logger = logger
+	for _, shard := range s.shards {
+		shard.SetLogger(logger)
+	}
+}
+
+// SetRecorder sets the recorder of the raw messages received by all the shards, it must be set before Stream.
+func (s *ShardedStreamer) SetRecorder(recorder streaming.MessageRecorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recorder = recorder
+	for _, shard := range s.shards {
+		shard.SetRecorder(recorder)
+	}
+}
+
+// ConfigureClients calls configure with the websocket client of every shard and the index of the shard, and keeps it
+// to configure the clients of the shards added later. It must be called before Stream.
+func (s *ShardedStreamer) ConfigureClients(configure func(index int, client *wsclient.Client) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, shard := range s.shards {
+		err := configure(i, shard.GetClient())
+		if err != nil {
+			return err
+		}
+	}
+
+	s.configurers = append(s.configurers, configure)
+
+	return nil
+}
+
+// configureShard configures the client of the shard at the index with all the configurers.
+func (s *ShardedStreamer) configureShard(index int) error {
+	for _, configure := range s.configurers {
+		err := configure(index, s.shards[index].GetClient())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetClient returns the websocket client of the first shard, use GetClients to get the clients of all the shards.
+func (s *ShardedStreamer) GetClient() *wsclient.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.shards) == 0 {
+		return nil
+	}
+
+	return s.shards[0].GetClient()
+}
+
+// GetClients returns the websocket clients of all the shards.
+func (s *ShardedStreamer) GetClients() []*wsclient.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clients := make([]*wsclient.Client, 0, len(s.shards))
+	for _, shard := range s.shards {
+		clients = append(clients, shard.GetClient())
+	}
+
+	return clients
+}
+
+// GetShardProducts returns the products subscribed by every shard.
+func (s *ShardedStreamer) GetShardProducts() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shardProducts := make([][]string, 0, len(s.shardProducts))
+	for _, products := range s.shardProducts {
+		shardProducts = append(shardProducts, append([]string{}, products...))
+	}
+
+	return shardProducts
+}
+
+func (s *ShardedStreamer) GetContext() context.Context {
+	return s.ctx
+}
+
+// Stream starts streaming of every shard into the streamFeeds channel. If any of the shards fails to start, the
+// started shards are stopped. When any shard stops on a fatal error, e.g. a subscribe error, the whole streamer is
+// cancelled, a shard ending otherwise leaves the other shards running and reconnecting on their own.
+func (s *ShardedStreamer) Stream(streamFeeds chan interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.shards) == 0 {
+		return errors.New("no products to stream")
+	}
+
+	s.streamFeeds = streamFeeds
+
+	for i, shard := range s.shards {
+		err := s.startShard(shard)
+		if err != nil {
+			s.logger.Errorf("Error starting shard %d %s", i, err)
+
+			for _, started := range s.shards[:i] {
+				started.Stop()
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *ShardedStreamer) startShard(shard *Streamer) error {
+	err := shard.Stream(s.streamFeeds)
+	if err != nil {
+		return err
+	}
+
+	shardCtx := shard.GetContext()
+
+	go func() {
+		select {
+		case <-shardCtx.Done():
+		case <-s.ctx.Done():
+			return
+		}
+
+		err := shard.Err()
+		if err == nil {
+			return
+		}
+
+		s.logger.Errorf("Shard failed, stopping all the shards %s", err)
+		s.cancel()
+	}()
+
+	return nil
+}
+
+// Err returns the fatal error of a shard that has ended the stream, e.g. a rejected subscription, if any.
+func (s *ShardedStreamer) Err() error {
+	s.mu.Lock()
+	shards := append([]*Streamer{}, s.shards...)
+	s.mu.Unlock()
+
+	for _, shard := range shards {
+		if err := shard.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Subscribe subscribes to additional products, every product is assigned to the least loaded shard. When all the
+// shards are full, a new shard is started.
+func (s *ShardedStreamer) Subscribe(productIds []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assigned := make(map[int][]string)
+	newProducts := make([]string, 0)
+
+	for _, productID := range productIds {
+		if s.shardOf(productID) >= 0 {
+			continue
+		}
+
+		index := s.leastLoadedShard()
+		if index < 0 {
+			newProducts = append(newProducts, productID)
+			continue
+		}
+
+		assigned[index] = append(assigned[index], productID)
+		s.shardProducts[index] = append(s.shardProducts[index], productID)
+	}
+
+	for index, products := range assigned {
+		err := s.shards[index].Subscribe(products)
+		if err != nil {
+			return err
+		}
+	}
+
+	perShard := s.productsPerConnection
+	if perShard <= 0 {
+		perShard = len(newProducts)
+	}
+
+	for start := 0; start < len(newProducts); start += perShard {
+		end := start + perShard
+		if end > len(newProducts) {
+			end = len(newProducts)
+		}
+
+		shard := s.addShard(newProducts[start:end])
+
+		err := s.configureShard(len(s.shards) - 1)
+		if err != nil {
+			return err
+		}
+
+		if s.streamFeeds == nil {
+			continue
+		}
+
+		err = s.startShard(shard)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Unsubscribe unsubscribes from the products on the shards they're assigned to.
+func (s *ShardedStreamer) Unsubscribe(productIds []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assigned := make(map[int][]string)
+
+	for _, productID := range productIds {
+		index := s.shardOf(productID)
+		if index < 0 {
+			continue
+		}
+
+		assigned[index] = append(assigned[index], productID)
+		s.shardProducts[index] = removeProduct(s.shardProducts[index], productID)
+	}
+
+	for index, products := range assigned {
+		err := s.shards[index].Unsubscribe(products)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *ShardedStreamer) shardOf(productID string) int {
+	for index, products := range s.shardProducts {
+		for _, p := range products {
+			if p == productID {
+				return index
+			}
+		}
+	}
+
+	return -1
+}
+
+// leastLoadedShard returns the index of the shard with the fewest products that still has room, or -1.
+func (s *ShardedStreamer) leastLoadedShard() int {
+	index := -1
+
+	for i, products := range s.shardProducts {
+		if s.productsPerConnection > 0 && len(products) >= s.productsPerConnection {
+			continue
+		}
+
+		if index < 0 || len(products) < len(s.shardProducts[index]) {
+			index = i
+		}
+	}
+
+	return index
+}
+
+func removeProduct(productIds []string, productID string) []string {
+	result := make([]string, 0, len(productIds))
+	for _, p := range productIds {
+		if p != productID {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+// Stop stops all the shards.
+func (s *ShardedStreamer) Stop() {
+	s.mu.Lock()
+	shards := append([]*Streamer{}, s.shards...)
+	s.mu.Unlock()
+
+	for _, shard := range shards {
+		shard.Stop()
+	}
+
+	s.cancel()
+}
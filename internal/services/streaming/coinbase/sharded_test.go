@@ -0,0 +1,341 @@
+//go:build all
+// +build all
+
+package coinbase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/fakeserver"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// matchesServer is a local websocket server that answers every subscribe request with a match of each product.
+type matchesServer struct {
+	*httptest.Server
+	mu          sync.Mutex
+	conns       []*websocket.Conn
+	subscribes  [][]string
+	tradeID     int
+	connections int
+}
+
+func newMatchesServer() *matchesServer {
+	ms := &matchesServer{}
+	upgrader := websocket.Upgrader{}
+
+	ms.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		ms.mu.Lock()
+		ms.conns = append(ms.conns, conn)
+		ms.connections++
+		ms.mu.Unlock()
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var req SubscribeRequest
+			if json.Unmarshal(message, &req) != nil || req.Type != RequestTypeSubscribe {
+				continue
+			}
+
+			ms.mu.Lock()
+			ms.subscribes = append(ms.subscribes, req.ProductIds)
+			for _, productID := range req.ProductIds {
+				ms.tradeID++
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(
+					`{"type":"match","trade_id":%d,"size":"1","price":"100","product_id":"%s"}`,
+					ms.tradeID,
+					productID,
+				)))
+			}
+			ms.mu.Unlock()
+		}
+	}))
+
+	return ms
+}
+
+func (ms *matchesServer) wsURL() string {
+	return "ws" + strings.TrimPrefix(ms.URL, "http")
+}
+
+// dropConnections closes all the server side connections without a close frame.
+func (ms *matchesServer) dropConnections() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, conn := range ms.conns {
+		_ = conn.UnderlyingConn().Close()
+	}
+	ms.conns = nil
+}
+
+func (ms *matchesServer) stats() (int, int) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.connections, len(ms.subscribes)
+}
+
+func receiveProducts(t *testing.T, streamFeeds chan interface{}, count int) []string {
+	t.Helper()
+
+	products := make([]string, 0, count)
+	for len(products) < count {
+		select {
+		case feed := <-streamFeeds:
+			products = append(products, feed.(Feed).ProductID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d feeds %v, want %d", len(products), products, count)
+		}
+	}
+
+	sort.Strings(products)
+
+	return products
+}
+
+func TestShardProducts(t *testing.T) {
+	type args struct {
+		productIds            []string
+		connections           int
+		productsPerConnection int
+	}
+	tests := []struct {
+		name string
+		args args
+		want [][]string
+	}{
+		// Add TestShardProducts test cases.
+		{
+			name: "TestShardProducts single connection",
+			args: args{productIds: []string{"BTC-USD", "ETH-USD", "ETH-BTC"}, connections: 1},
+			want: [][]string{{"BTC-USD", "ETH-USD", "ETH-BTC"}},
+		},
+		{
+			name: "TestShardProducts connections",
+			args: args{productIds: []string{"BTC-USD", "ETH-USD", "ETH-BTC"}, connections: 2},
+			want: [][]string{{"BTC-USD", "ETH-BTC"}, {"ETH-USD"}},
+		},
+		{
+			name: "TestShardProducts products per connection",
+			args: args{productIds: []string{"A-USD", "B-USD", "C-USD", "D-USD", "E-USD"}, productsPerConnection: 2},
+			want: [][]string{{"A-USD", "D-USD"}, {"B-USD", "E-USD"}, {"C-USD"}},
+		},
+		{
+			name: "TestShardProducts more connections than products",
+			args: args{productIds: []string{"BTC-USD", "ETH-USD"}, connections: 5},
+			want: [][]string{{"BTC-USD"}, {"ETH-USD"}},
+		},
+		{
+			name: "TestShardProducts no products",
+			args: args{connections: 2},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ShardProducts(tt.args.productIds, tt.args.connections, tt.args.productsPerConnection)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ShardProducts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardedStreamer_Stream(t *testing.T) {
+	server := newMatchesServer()
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	productIds := []string{"BTC-USD", "ETH-USD", "ETH-BTC", "LTC-USD", "SOL-USD"}
+
+	s := NewShardedStreamer(context.Background(), server.wsURL(), productIds, 1, 2)
+	s.SetLogger(logger)
+	for _, shard := range s.shards {
+		shard.SetReconnectDelay(10*time.Millisecond, 50*time.Millisecond)
+	}
+	defer s.Stop()
+
+	if got := len(s.GetClients()); got != 3 {
+		t.Fatalf("GetClients() len = %v, want %v", got, 3)
+	}
+
+	streamFeeds := make(chan interface{})
+	if err := s.Stream(streamFeeds); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+
+	want := append([]string{}, productIds...)
+	sort.Strings(want)
+
+	if got := receiveProducts(t, streamFeeds, len(productIds)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stream() products = %v, want %v", got, want)
+	}
+
+	// Every shard reconnects and re-subscribes on its own after the connections are dropped.
+	server.dropConnections()
+
+	if got := receiveProducts(t, streamFeeds, len(productIds)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stream() products after reconnect = %v, want %v", got, want)
+	}
+
+	if connections, subscribes := server.stats(); connections != 6 || subscribes != 6 {
+		t.Errorf("Stream() connections = %v subscribes = %v, want %v and %v", connections, subscribes, 6, 6)
+	}
+
+	// New products fill the shards with room first, then go to a new shard once the existing ones are full.
+	if err := s.Subscribe([]string{"ADA-USD", "XRP-USD", "BTC-USD"}); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	if got := receiveProducts(t, streamFeeds, 2); !reflect.DeepEqual(got, []string{"ADA-USD", "XRP-USD"}) {
+		t.Errorf("Subscribe() products = %v, want %v", got, []string{"ADA-USD", "XRP-USD"})
+	}
+
+	wantShards := [][]string{{"BTC-USD", "LTC-USD"}, {"ETH-USD", "SOL-USD"}, {"ETH-BTC", "ADA-USD"}, {"XRP-USD"}}
+	if got := s.GetShardProducts(); !reflect.DeepEqual(got, wantShards) {
+		t.Errorf("GetShardProducts() = %v, want %v", got, wantShards)
+	}
+
+	if err := s.Unsubscribe([]string{"ETH-USD"}); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+
+	wantShards[1] = []string{"SOL-USD"}
+	if got := s.GetShardProducts(); !reflect.DeepEqual(got, wantShards) {
+		t.Errorf("GetShardProducts() = %v, want %v", got, wantShards)
+	}
+}
+
+func TestShardedStreamer_ConfigureClients(t *testing.T) {
+	s := NewShardedStreamer(context.Background(), WsURLSandbox, []string{"BTC-USD", "ETH-USD"}, 2, 0)
+	defer s.Stop()
+
+	errConfigure := errors.New("configure")
+	if err := s.ConfigureClients(func(index int, client *wsclient.Client) error {
+		return errConfigure
+	}); !errors.Is(err, errConfigure) {
+		t.Errorf("ConfigureClients() error = %v, want %v", err, errConfigure)
+	}
+
+	configured := make(map[*wsclient.Client]int)
+	if err := s.ConfigureClients(func(index int, client *wsclient.Client) error {
+		configured[client] = index
+		return nil
+	}); err != nil {
+		t.Fatalf("ConfigureClients() error = %v", err)
+	}
+
+	// The shards added later are configured too.
+	s.addShard([]string{"LTC-USD"})
+	if err := s.configureShard(2); err != nil {
+		t.Fatalf("configureShard() error = %v", err)
+	}
+
+	for i, client := range s.GetClients() {
+		if index, ok := configured[client]; !ok || index != i {
+			t.Errorf("ConfigureClients() client %d configured = %v with index %v, want %v", i, ok, index, i)
+		}
+	}
+}
+
+func TestShardedStreamer_Stream_isolation(t *testing.T) {
+	server := newMatchesServer()
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	s := NewShardedStreamer(context.Background(), server.wsURL(), []string{"BTC-USD", "ETH-USD"}, 2, 0)
+	s.SetLogger(logger)
+	for _, shard := range s.shards {
+		shard.SetReconnectDelay(10*time.Millisecond, 50*time.Millisecond)
+	}
+	defer s.Stop()
+
+	streamFeeds := make(chan interface{})
+	if err := s.Stream(streamFeeds); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+
+	receiveProducts(t, streamFeeds, 2)
+
+	// A shard ending without a fatal error doesn't stop the others.
+	s.shards[0].Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	if s.GetContext().Err() != nil {
+		t.Fatalf("Stream() context cancelled by a stopped shard")
+	}
+
+	server.dropConnections()
+
+	if got := receiveProducts(t, streamFeeds, 1); !reflect.DeepEqual(got, []string{"ETH-USD"}) {
+		t.Errorf("Stream() products after reconnect = %v, want %v", got, []string{"ETH-USD"})
+	}
+}
+
+func TestShardedStreamer_Stream_fatal(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	config := fakeserver.NewConfig()
+	config.RejectedProducts = []string{"ETH-USD"}
+
+	feed := fakeserver.NewServer(newTestSource(t), config)
+	feed.SetLogger(logger)
+
+	feedServer := httptest.NewServer(feed)
+	defer feedServer.Close()
+	defer feed.Close()
+
+	s := NewShardedStreamer(
+		context.Background(),
+		fakeserver.WebSocketURL(feedServer.URL),
+		[]string{"BTC-USD", "ETH-USD"},
+		2,
+		0,
+	)
+	s.SetLogger(logger)
+	defer s.Stop()
+
+	if err := s.Stream(make(chan interface{})); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+
+	// The rejected subscription of a shard stops all the shards.
+	select {
+	case <-s.GetContext().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stream() context not cancelled on the fatal error of a shard")
+	}
+
+	if !errors.Is(s.Err(), streaming.ErrSubscribeFailed) {
+		t.Errorf("Err() = %v, want %v", s.Err(), streaming.ErrSubscribeFailed)
+	}
+}
@@ -1,39 +1,46 @@
 package coinbase
 
 import (
-	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
-	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
 	"context"
 	"encoding/json"
-	"github.com/sirupsen/logrus"
-)
+	"fmt"
+	"sync"
+	"time"
 
-const (
-	FeedTypeMatch          = "match"
-	FeedTypeSubscribeError = "error"
-	FeedTypeLastMatch      = "last_match"
-	FeedTypeLevel2Snapshot = "l2update"
-	FeedTypeTicker         = "ticker"
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"github.com/sirupsen/logrus"
 )
 
 // Streamer is a streaming service for Coinbase. It implements the streaming.Streamer interface.
 // It consists of a websocket client and a message handler streamDataHandler.
 type Streamer struct {
 	ctx               context.Context
+	cancel            context.CancelFunc
 	wsURL             string
 	client            *wsclient.Client
 	request           string
 	streamDataHandler streaming.StreamDataHandler
+	reconnector       *streaming.Reconnector
+	subscriptions     map[string]bool
+	recorder          streaming.MessageRecorder
+	err               error
+	mu                sync.Mutex
 	logger            *logrus.Logger
 }
 
 func NewStreamer(ctx context.Context, wsURL string, request string) *Streamer {
+	ctx, cancel := context.WithCancel(ctx)
+
 	return &Streamer{
-		ctx:     ctx,
-		wsURL:   wsURL,
-		client:  wsclient.NewClient(ctx, wsURL),
-		request: request,
-		logger:  logrus.New(),
+		ctx:           ctx,
+		cancel:        cancel,
+		wsURL:         wsURL,
+		client:        wsclient.NewClient(ctx, wsURL),
+		request:       request,
+		reconnector:   streaming.NewReconnector(streaming.DefaultReconnectDelay, streaming.DefaultMaxReconnectDelay),
+		subscriptions: make(map[string]bool),
+		logger:        logrus.New(),
 	}
 }
 
@@ -42,10 +49,23 @@ func (s *Streamer) SetLogger(logger *logrus.Logger) {
 	s.client.SetLogger(logger)
 }
 
+// SetRecorder sets the recorder the raw messages are recorded to as they're received, before they're decoded. It
+// must be set before Stream.
+func (s *Streamer) SetRecorder(recorder streaming.MessageRecorder) {
+	s.recorder = recorder
+}
+
 func (s *Streamer) SetStreamDataHandler(streamDataHandler streaming.StreamDataHandler) {
 	s.streamDataHandler = streamDataHandler
 }
 
+// SetReconnectDelay sets the initial and the maximum delay between the reconnect attempts, the delay doubles after
+// every failed attempt. A zero initial delay disables reconnecting.
+func (s *Streamer) SetReconnectDelay(delay time.Duration, maxDelay time.Duration) {
+	s.reconnector.Delay = delay
+	s.reconnector.MaxDelay = maxDelay
+}
+
 func (s *Streamer) GetClient() *wsclient.Client {
 	return s.client
 }
@@ -56,13 +76,13 @@ func (s *Streamer) GetContext() context.Context {
 
 // Stream starts the process of subscribing to a channel and streaming feeds from Coinbase, the datapoint is passed to
 // a streamFeeds channel that can be further passed to the stream data handler.
+// When the connection drops unexpectedly, the streamer reconnects and re-sends the subscriptions.
 func (s *Streamer) Stream(
 	streamFeeds chan interface{},
 ) error {
 	client := s.client
 
-	var cancel context.CancelFunc
-	s.ctx, cancel = context.WithCancel(s.GetContext())
+	ctx := s.ctx
 
 	client.OnConnected = func(socket wsclient.Client) {
 		s.logger.Infoln("Connected to coinbase server.")
@@ -72,7 +92,13 @@ func (s *Streamer) Stream(
 		s.logger.Infoln("Received connect error ", err)
 	}
 
+	recorder := s.recorder
+
 	client.OnReceivingMsg = func(message string, socket wsclient.Client) {
+		if recorder != nil {
+			recorder.Record(message, socket.ReceivedAt())
+		}
+
 		var m = Feed{}
 
 		err := json.Unmarshal([]byte(message), &m)
@@ -81,25 +107,29 @@ func (s *Streamer) Stream(
 			return
 		}
 
+		m.ReceivedAt = socket.ReceivedAt()
+
 		// Stop on subscribe errors.
 		if m.Type == FeedTypeSubscribeError {
 			s.logger.Errorf("Received subscribe error type: %v error: %v", m.Type, m)
 			s.logger.Errorf("Reason: %v", m.Reason)
-			cancel()
+			s.fail(fmt.Errorf("%w: %s", streaming.ErrSubscribeFailed, m.Reason))
 			return
 		}
 
-		go func() {
-			// This is to prevent race condition upon connection error or closed connection.
-			if !client.IsConnected || client.OnConnected == nil {
-				cancel()
-				return
-			}
+		// This is to prevent race condition upon connection error or closed connection.
+		if !socket.IsConnected() || socket.OnConnected == nil {
+			s.cancelContext()
+			return
+		}
 
-			if m.Type == FeedTypeMatch || m.Type == FeedTypeLastMatch {
-				streamFeeds <- m
+		// The feeds are piped in the order they're received, the handler relies on the increasing trade ids.
+		if m.Type == FeedTypeMatch || m.Type == FeedTypeLastMatch {
+			select {
+			case streamFeeds <- m:
+			case <-ctx.Done():
 			}
-		}()
+		}
 	}
 
 	client.OnDisconnected = func(err error, socket wsclient.Client) {
@@ -108,9 +138,14 @@ func (s *Streamer) Stream(
 		} else {
 			s.logger.Infoln("Disconnected from server")
 		}
+
+		// A nil error means the connection was closed on purpose.
+		if err != nil {
+			s.reconnector.Reconnect(ctx, s.client, s.resubscribe, s.logger)
+		}
 	}
 
-	if !client.IsConnected {
+	if !client.IsConnected() {
 		err := client.Connect()
 		if err != nil {
 			s.logger.Errorf("Error connecting to server %s", err)
@@ -119,7 +154,7 @@ func (s *Streamer) Stream(
 		}
 	}
 
-	if client.IsConnected {
+	if client.IsConnected() {
 		err := client.SendRequest(s.request)
 		if err != nil {
 			s.logger.Errorf("Error sending request %s", err)
@@ -131,12 +166,114 @@ func (s *Streamer) Stream(
 	return nil
 }
 
+// resubscribe re-sends the initial request followed by the subscriptions changed at runtime.
+func (s *Streamer) resubscribe() error {
+	err := s.client.SendRequest(s.request)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	subscribed := make([]string, 0)
+	unsubscribed := make([]string, 0)
+	for productID, ok := range s.subscriptions {
+		if ok {
+			subscribed = append(subscribed, productID)
+		} else {
+			unsubscribed = append(unsubscribed, productID)
+		}
+	}
+	s.mu.Unlock()
+
+	err = s.sendMatchesRequest(RequestTypeSubscribe, subscribed)
+	if err != nil {
+		return err
+	}
+
+	return s.sendMatchesRequest(RequestTypeUnsubscribe, unsubscribed)
+}
+
+// Subscribe subscribes the running stream to the matches channel of additional products.
+func (s *Streamer) Subscribe(productIds []string) error {
+	s.trackSubscriptions(productIds, true)
+
+	return s.sendMatchesRequest(RequestTypeSubscribe, productIds)
+}
+
+// Unsubscribe unsubscribes the running stream from the matches channel of the products.
+func (s *Streamer) Unsubscribe(productIds []string) error {
+	s.trackSubscriptions(productIds, false)
+
+	return s.sendMatchesRequest(RequestTypeUnsubscribe, productIds)
+}
+
+func (s *Streamer) trackSubscriptions(productIds []string, subscribed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[string]bool)
+	}
+
+	for _, productID := range productIds {
+		s.subscriptions[productID] = subscribed
+	}
+}
+
+func (s *Streamer) sendMatchesRequest(requestType string, productIds []string) error {
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	request, err := json.Marshal(NewMatchesRequest(requestType, productIds))
+	if err != nil {
+		return err
+	}
+
+	err = s.client.SendRequest(string(request))
+	if err != nil {
+		s.logger.Errorf("Error sending %s request %s", requestType, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Stop cancels the context of the streamer, so that the feed being piped is dropped rather than blocking the close,
+// then stops reconnecting and closes the connection.
 func (s *Streamer) Stop() {
-	var cancel context.CancelFunc
-	s.ctx, cancel = context.WithCancel(s.ctx)
-	defer cancel()
+	s.cancelContext()
+	s.reconnector.Stop()
 
-	if s.client.IsConnected {
+	if s.client.IsConnected() {
 		s.client.Close()
 	}
 }
+
+// Err returns the fatal error that has ended the stream, e.g. a rejected subscription, if any.
+func (s *Streamer) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// fail ends the stream with a fatal error, the first one is kept. It's called from the client callbacks, so it only
+// cancels the context, which closes the connection.
+func (s *Streamer) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+
+	s.cancelContext()
+}
+
+// cancelContext cancels the context of the streamer, which stops piping the feeds and reconnecting.
+func (s *Streamer) cancelContext() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
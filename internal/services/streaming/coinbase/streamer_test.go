@@ -6,12 +6,17 @@ package coinbase
 import (
 	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/fakeserver"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/synthetic"
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/sirupsen/logrus"
 	"go.uber.org/goleak"
+	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const (
@@ -58,11 +63,16 @@ func TestNewStreamer(t *testing.T) {
 				request: ReqString,
 			},
 			want: &Streamer{
-				ctx:     ctx,
+				ctx:     streamer.GetContext(),
 				wsURL:   WsURLSandbox,
 				client:  wsClient,
 				request: ReqString,
-				logger:  logger,
+				reconnector: streaming.NewReconnector(
+					streaming.DefaultReconnectDelay,
+					streaming.DefaultMaxReconnectDelay,
+				),
+				subscriptions: make(map[string]bool),
+				logger:        logger,
 			},
 		},
 	}
@@ -70,6 +80,13 @@ func TestNewStreamer(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			got := streamer
 			got.SetLogger(logger)
+
+			// The streamer's context is cancelled by Stop, the cancel funcs can't be compared.
+			if got.cancel == nil || got.GetContext().Err() != nil {
+				t.Fatalf("NewStreamer() ctx = %v, want a cancellable context", got.GetContext())
+			}
+			got.cancel = nil
+
 			if reflect.DeepEqual(got, tt.want) != true {
 				t.Errorf("NewStreamer() = %v, \n want %v", got, tt.want)
 			}
@@ -169,10 +186,10 @@ func TestStreamer_Stop(t *testing.T) {
 				logger:            tt.fields.logger,
 			}
 			s.Stop()
-			if s.GetClient().IsConnected {
+			if s.GetClient().IsConnected() {
 				t.Errorf(
-					"Streamer.Stop() client.IsConnected = %v, want %v",
-					s.GetClient().IsConnected,
+					"Streamer.Stop() client.IsConnected() = %v, want %v",
+					s.GetClient().IsConnected(),
 					false,
 				)
 			}
@@ -180,6 +197,18 @@ func TestStreamer_Stop(t *testing.T) {
 	}
 }
 
+// newTestSource returns the synthetic matches of the test products.
+func newTestSource(t *testing.T) *synthetic.Generator {
+	t.Helper()
+
+	generator, err := synthetic.NewGenerator(synthetic.NewPriceConfig(1, map[string]float64{"BTC-USD": 40000, "ETH-USD": 3000}))
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+
+	return generator
+}
+
 func TestStreamer_Stream(t *testing.T) {
 	defer goleak.VerifyNone(t)
 	type fields struct {
@@ -194,7 +223,17 @@ func TestStreamer_Stream(t *testing.T) {
 	ctx := context.Background()
 	logger := logrus.New()
 
-	client := wsclient.NewClient(ctx, WsURLSandbox)
+	// Stream from the local fake feed instead of the live one.
+	feed := fakeserver.NewServer(newTestSource(t), fakeserver.Config{Interval: 10 * time.Millisecond})
+	feed.SetLogger(logger)
+
+	feedServer := httptest.NewServer(feed)
+	defer feedServer.Close()
+	defer feed.Close()
+
+	wsURL := fakeserver.WebSocketURL(feedServer.URL)
+
+	client := wsclient.NewClient(ctx, wsURL)
 	type args struct {
 		streamFeeds chan interface{}
 	}
@@ -209,7 +248,7 @@ func TestStreamer_Stream(t *testing.T) {
 			name: "TestStreamer_Stream",
 			fields: fields{
 				ctx:               context.Background(),
-				wsURL:             WsURLSandbox,
+				wsURL:             wsURL,
 				client:            client,
 				request:           ReqString,
 				streamDataHandler: nil,
@@ -251,6 +290,9 @@ func TestStreamer_Stream(t *testing.T) {
 					t.Errorf("Streamer.Stream() context.Done()")
 					return
 				case feed := <-tt.args.streamFeeds:
+					if m, ok := feed.(Feed); !ok || m.ReceivedAt.IsZero() {
+						t.Errorf("Streamer.Stream() feed = %v, want a feed with the receive time", feed)
+					}
 
 					f, err := interfaceToFeedStruct(feed)
 					if err != nil {
@@ -299,3 +341,103 @@ var interfaceToFeedStruct = func(anyData interface{}) (Feed, error) {
 
 	return feed, nil
 }
+
+func TestStreamer_Stream_faults(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	feed := fakeserver.NewServer(newTestSource(t), fakeserver.Config{Interval: 5 * time.Millisecond})
+	feed.SetLogger(logger)
+
+	feedServer := httptest.NewServer(feed)
+	defer feedServer.Close()
+	defer feed.Close()
+
+	faults, err := wsclient.NewFaultInjector(wsclient.FaultConfig{
+		Seed:        1,
+		Duplicate:   0.2,
+		Disconnects: []wsclient.Duration{wsclient.Duration(50 * time.Millisecond)},
+	})
+	if err != nil {
+		t.Fatalf("NewFaultInjector() error = %v", err)
+	}
+
+	s := NewStreamer(context.Background(), fakeserver.WebSocketURL(feedServer.URL), ReqString)
+	s.SetLogger(logger)
+	s.SetReconnectDelay(10*time.Millisecond, 50*time.Millisecond)
+	s.GetClient().Faults = faults
+
+	streamFeeds := make(chan interface{})
+	if err := s.Stream(streamFeeds); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	defer s.Stop()
+
+	// The streamer reconnects and resubscribes after the injected disconnect, and keeps streaming.
+	deadline := time.After(5 * time.Second)
+	for faults.Stats().Disconnected == 0 || feed.Subscribes() < 2 {
+		select {
+		case <-streamFeeds:
+		case <-deadline:
+			t.Fatalf("Stream() disconnects = %v subscribes = %v, want a resubscribe after the injected disconnect",
+				faults.Stats().Disconnected, feed.Subscribes())
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-streamFeeds:
+		case <-deadline:
+			t.Fatalf("Stream() no feeds after reconnecting")
+		}
+	}
+
+	if faults.Stats().Duplicated == 0 {
+		t.Errorf("Stats() = %+v, want duplicated messages", faults.Stats())
+	}
+}
+
+func TestStreamer_Stop_context(t *testing.T) {
+	s := NewStreamer(context.Background(), WsURLSandbox, ReqString)
+	s.Stop()
+
+	if s.GetContext().Err() == nil {
+		t.Errorf("Streamer.Stop() context not cancelled")
+	}
+
+	if s.Err() != nil {
+		t.Errorf("Streamer.Err() = %v, want nil after Stop", s.Err())
+	}
+}
+
+func TestStreamer_Stream_subscribeError(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	feed := fakeserver.NewServer(newTestSource(t), fakeserver.Config{SubscribeError: "maintenance"})
+	feed.SetLogger(logger)
+
+	feedServer := httptest.NewServer(feed)
+	defer feedServer.Close()
+	defer feed.Close()
+
+	s := NewStreamer(context.Background(), fakeserver.WebSocketURL(feedServer.URL), ReqString)
+	s.SetLogger(logger)
+	defer s.Stop()
+
+	if err := s.Stream(make(chan interface{})); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+
+	select {
+	case <-s.GetContext().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stream() context not cancelled on the subscribe error")
+	}
+
+	if !errors.Is(s.Err(), streaming.ErrSubscribeFailed) {
+		t.Errorf("Err() = %v, want %v", s.Err(), streaming.ErrSubscribeFailed)
+	}
+}
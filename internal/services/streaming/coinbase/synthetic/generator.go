@@ -0,0 +1,412 @@
+package synthetic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/protocol"
+)
+
+const (
+	// ModelRandomWalk moves the price by normally distributed steps proportional to the initial price.
+	ModelRandomWalk = "random_walk"
+	// ModelGBM moves the price by a geometric Brownian motion.
+	ModelGBM = "gbm"
+
+	// EventFlashCrash drops the price by the magnitude, as a fraction, halfway through the event, then recovers it.
+	EventFlashCrash = "flash_crash"
+	// EventVolumeSpike multiplies the trade rate and the trade sizes by the magnitude.
+	EventVolumeSpike = "volume_spike"
+	// EventSilence stops the trades.
+	EventSilence = "silence"
+
+	DefaultRate      = 1.0
+	DefaultMinSize   = 0.001
+	DefaultTailIndex = 1.5
+	// DefaultVolatility is the volatility of the products of NewPriceConfig.
+	DefaultVolatility = 0.0005
+)
+
+// ProductConfig is the price model, trade rate and size distribution of a product.
+type ProductConfig struct {
+	ProductID string  `json:"product_id"`
+	Price     float64 `json:"price"`
+	Model     string  `json:"model"`
+	// Drift is the drift of the price per second, as a fraction.
+	Drift float64 `json:"drift"`
+	// Volatility is the standard deviation of the price per square root of a second, as a fraction.
+	Volatility float64 `json:"volatility"`
+	// Rate is the average number of trades per second, the trades arrive as a Poisson process.
+	Rate float64 `json:"rate"`
+	// MinSize, TailIndex and MaxSize are the scale, shape and cap of the Pareto distribution of the trade sizes, the
+	// lower the tail index the heavier the tail.
+	MinSize   float64 `json:"min_size"`
+	TailIndex float64 `json:"tail_index"`
+	MaxSize   float64 `json:"max_size"`
+}
+
+// Event is a scripted event of a product, or of all the products when ProductID is empty, starting at an offset from
+// the generator start.
+type Event struct {
+	Kind      string            `json:"kind"`
+	ProductID string            `json:"product_id"`
+	Start     wsclient.Duration `json:"start"`
+	Duration  wsclient.Duration `json:"duration"`
+	Magnitude float64           `json:"magnitude"`
+}
+
+// Config is the scenario of a generator.
+type Config struct {
+	Seed     int64           `json:"seed"`
+	Start    time.Time       `json:"start"`
+	Products []ProductConfig `json:"products"`
+	Events   []Event         `json:"events"`
+}
+
+// NewPriceConfig is the scenario of the products starting at the prices, moving by a GBM of the default volatility
+// at the default rate.
+func NewPriceConfig(seed int64, prices map[string]float64) Config {
+	productIds := make([]string, 0, len(prices))
+	for productID := range prices {
+		productIds = append(productIds, productID)
+	}
+
+	sort.Strings(productIds)
+
+	config := Config{Seed: seed}
+	for _, productID := range productIds {
+		config.Products = append(config.Products, ProductConfig{
+			ProductID:  productID,
+			Price:      prices[productID],
+			Volatility: DefaultVolatility,
+		})
+	}
+
+	return config
+}
+
+// LoadConfig loads a scenario from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return Config{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// product is the state of a product's generated trades.
+type product struct {
+	config  ProductConfig
+	price   float64
+	elapsed time.Duration
+	tradeID int
+}
+
+// Generator generates realistic synthetic match streams of the products from a seeded scenario, the same scenario
+// always generates the same matches. The matches are Coinbase feeds timed on a virtual clock starting at the scenario
+// start, so they can be written for the replay streamer. The generator is also a source of the fake server, which
+// sends the matches at its own pace.
+type Generator struct {
+	start    time.Time
+	products map[string]*product
+	order    []string
+	events   []Event
+	pending  map[string]*protocol.Feed
+	sequence int64
+	random   *rand.Rand
+	mu       sync.Mutex
+}
+
+func NewGenerator(config Config) (*Generator, error) {
+	start := config.Start
+	if start.IsZero() {
+		start = time.Unix(0, 0).UTC()
+	}
+
+	g := &Generator{
+		start:    start,
+		products: make(map[string]*product),
+		events:   config.Events,
+		pending:  make(map[string]*protocol.Feed),
+		random:   rand.New(rand.NewSource(config.Seed)),
+	}
+
+	for _, productConfig := range config.Products {
+		if productConfig.ProductID == "" || productConfig.Price <= 0 {
+			return nil, fmt.Errorf("product %v must have an id and a positive price", productConfig)
+		}
+
+		if productConfig.Model == "" {
+			productConfig.Model = ModelGBM
+		}
+
+		if productConfig.Model != ModelGBM && productConfig.Model != ModelRandomWalk {
+			return nil, fmt.Errorf("unknown model %s of %s", productConfig.Model, productConfig.ProductID)
+		}
+
+		if productConfig.Rate <= 0 {
+			productConfig.Rate = DefaultRate
+		}
+
+		if productConfig.MinSize <= 0 {
+			productConfig.MinSize = DefaultMinSize
+		}
+
+		if productConfig.TailIndex <= 0 {
+			productConfig.TailIndex = DefaultTailIndex
+		}
+
+		g.products[productConfig.ProductID] = &product{config: productConfig, price: productConfig.Price}
+		g.order = append(g.order, productConfig.ProductID)
+	}
+
+	for _, event := range config.Events {
+		switch event.Kind {
+		case EventFlashCrash, EventVolumeSpike, EventSilence:
+		default:
+			return nil, fmt.Errorf("unknown event %s", event.Kind)
+		}
+	}
+
+	return g, nil
+}
+
+// Next returns the next match of a product.
+func (g *Generator) Next(productID string) (protocol.Feed, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if feed, ok := g.pending[productID]; ok {
+		delete(g.pending, productID)
+		return *feed, true
+	}
+
+	return g.generate(productID)
+}
+
+// NextFeed returns the next match of all the products, in time order.
+func (g *Generator) NextFeed() (protocol.Feed, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var next string
+
+	for _, productID := range g.order {
+		if _, ok := g.pending[productID]; !ok {
+			feed, ok := g.generate(productID)
+			if !ok {
+				continue
+			}
+
+			g.pending[productID] = &feed
+		}
+
+		if next == "" || g.pending[productID].Time.Before(g.pending[next].Time) {
+			next = productID
+		}
+	}
+
+	if next == "" {
+		return protocol.Feed{}, false
+	}
+
+	feed := *g.pending[next]
+	delete(g.pending, next)
+
+	return feed, true
+}
+
+// Generate returns the matches of all the products, in time order, for the duration from the scenario start.
+func (g *Generator) Generate(duration time.Duration) []protocol.Feed {
+	feeds := make([]protocol.Feed, 0)
+	end := g.start.Add(duration)
+
+	for {
+		feed, ok := g.NextFeed()
+		if !ok || feed.Time.After(end) {
+			return feeds
+		}
+
+		feeds = append(feeds, feed)
+	}
+}
+
+// generate advances the product's clock to its next trade, skipping the silences, and moves its price.
+func (g *Generator) generate(productID string) (protocol.Feed, bool) {
+	p, ok := g.products[productID]
+	if !ok {
+		return protocol.Feed{}, false
+	}
+
+	rateMultiplier, _ := g.volumeSpike(productID, p.elapsed)
+	wait := time.Duration(g.random.ExpFloat64() / (p.config.Rate * rateMultiplier) * float64(time.Second))
+
+	if wait < time.Microsecond {
+		wait = time.Microsecond
+	}
+
+	g.move(p, wait)
+	p.elapsed += wait
+
+	if silenceEnd, ok := g.silence(productID, p.elapsed); ok {
+		g.move(p, silenceEnd-p.elapsed)
+		p.elapsed = silenceEnd
+	}
+
+	_, sizeMultiplier := g.volumeSpike(productID, p.elapsed)
+	size := g.size(p.config) * sizeMultiplier
+
+	price := p.price * g.crashMultiplier(productID, p.elapsed)
+
+	p.tradeID++
+	g.sequence++
+
+	side := "buy"
+	if g.random.Intn(2) == 0 {
+		side = "sell"
+	}
+
+	return protocol.Feed{
+		Type:      protocol.FeedTypeMatch,
+		TradeID:   p.tradeID,
+		Side:      side,
+		Size:      big.NewFloat(roundTo(size, 8)),
+		Price:     big.NewFloat(roundTo(price, 8)),
+		ProductID: productID,
+		Sequence:  g.sequence,
+		Time:      g.start.Add(p.elapsed),
+	}, true
+}
+
+// move moves the product's price by its model over the time step.
+func (g *Generator) move(p *product, step time.Duration) {
+	dt := step.Seconds()
+	if dt <= 0 {
+		return
+	}
+
+	z := g.random.NormFloat64()
+	sigma := p.config.Volatility
+
+	switch p.config.Model {
+	case ModelRandomWalk:
+		next := p.price + p.config.Price*(p.config.Drift*dt+sigma*math.Sqrt(dt)*z)
+		if next > 0 {
+			p.price = next
+		}
+	default:
+		p.price *= math.Exp((p.config.Drift-sigma*sigma/2)*dt + sigma*math.Sqrt(dt)*z)
+	}
+}
+
+// size draws a trade size from the capped Pareto distribution.
+func (g *Generator) size(config ProductConfig) float64 {
+	size := config.MinSize * math.Pow(1-g.random.Float64(), -1/config.TailIndex)
+	if config.MaxSize > 0 && size > config.MaxSize {
+		size = config.MaxSize
+	}
+
+	return size
+}
+
+// activeEvents returns the events of a kind of the product active at the offset.
+func (g *Generator) activeEvents(kind string, productID string, offset time.Duration) []Event {
+	active := make([]Event, 0)
+
+	for _, event := range g.events {
+		if event.Kind != kind || (event.ProductID != "" && event.ProductID != productID) {
+			continue
+		}
+
+		start := time.Duration(event.Start)
+		if offset >= start && offset < start+time.Duration(event.Duration) {
+			active = append(active, event)
+		}
+	}
+
+	return active
+}
+
+// silence returns the end of the silence the product is in at the offset.
+func (g *Generator) silence(productID string, offset time.Duration) (time.Duration, bool) {
+	silenced := false
+
+	for {
+		events := g.activeEvents(EventSilence, productID, offset)
+		if len(events) == 0 {
+			return offset, silenced
+		}
+
+		for _, event := range events {
+			if end := time.Duration(event.Start + event.Duration); end > offset {
+				offset = end
+			}
+		}
+
+		silenced = true
+	}
+}
+
+// volumeSpike returns the rate and the size multipliers at the offset.
+func (g *Generator) volumeSpike(productID string, offset time.Duration) (float64, float64) {
+	multiplier := 1.0
+
+	for _, event := range g.activeEvents(EventVolumeSpike, productID, offset) {
+		if event.Magnitude > 0 {
+			multiplier *= event.Magnitude
+		}
+	}
+
+	return multiplier, multiplier
+}
+
+// crashMultiplier returns the price multiplier at the offset, falling linearly to 1 - magnitude halfway through the
+// flash crash, then recovering linearly.
+func (g *Generator) crashMultiplier(productID string, offset time.Duration) float64 {
+	multiplier := 1.0
+
+	for _, event := range g.activeEvents(EventFlashCrash, productID, offset) {
+		progress := float64(offset-time.Duration(event.Start)) / float64(event.Duration)
+		depth := 1 - math.Abs(2*progress-1)
+		multiplier *= 1 - event.Magnitude*depth
+	}
+
+	return multiplier
+}
+
+func roundTo(value float64, decimals int) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'f', decimals, 64), 64)
+	return rounded
+}
+
+// WriteFeeds writes the feeds as JSON lines, readable by the replay streamer.
+func WriteFeeds(w io.Writer, feeds []protocol.Feed) error {
+	encoder := json.NewEncoder(w)
+
+	for _, feed := range feeds {
+		err := encoder.Encode(feed)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+//go:build all
+// +build all
+
+package synthetic
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/replay"
+)
+
+const testScenarioFile = "../../../../../tests/data/synthetic_scenario.json"
+
+func newTestGenerator(t *testing.T) (*Generator, Config) {
+	t.Helper()
+
+	config, err := LoadConfig(testScenarioFile)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	g, err := NewGenerator(config)
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+
+	return g, config
+}
+
+// window returns the feeds of the product between the offsets from the start.
+func window(feeds []coinbase.Feed, start time.Time, productID string, from time.Duration, to time.Duration) []coinbase.Feed {
+	selected := make([]coinbase.Feed, 0)
+
+	for _, feed := range feeds {
+		offset := feed.Time.Sub(start)
+		if feed.ProductID == productID && offset >= from && offset < to {
+			selected = append(selected, feed)
+		}
+	}
+
+	return selected
+}
+
+func TestGenerator_Generate(t *testing.T) {
+	g, config := newTestGenerator(t)
+	feeds := g.Generate(time.Minute)
+
+	other, _ := newTestGenerator(t)
+	if !reflect.DeepEqual(feeds, other.Generate(time.Minute)) {
+		t.Fatalf("Generate() isn't reproducible with the same seed")
+	}
+
+	lastTradeIDs := make(map[string]int)
+
+	for i, feed := range feeds {
+		if i > 0 && feed.Time.Before(feeds[i-1].Time) {
+			t.Fatalf("Generate() feed %d at %v is before %v", i, feed.Time, feeds[i-1].Time)
+		}
+
+		if feed.TradeID <= lastTradeIDs[feed.ProductID] {
+			t.Fatalf("Generate() feed %d trade id %d isn't increasing", i, feed.TradeID)
+		}
+
+		lastTradeIDs[feed.ProductID] = feed.TradeID
+	}
+
+	tests := []struct {
+		name      string
+		productID string
+		from      time.Duration
+		to        time.Duration
+		check     func(t *testing.T, feeds []coinbase.Feed)
+	}{
+		// Add TestGenerator_Generate test cases.
+		{
+			name:      "TestGenerator_Generate rate",
+			productID: "BTC-USD",
+			from:      0,
+			to:        10 * time.Second,
+			check: func(t *testing.T, feeds []coinbase.Feed) {
+				if len(feeds) < 120 || len(feeds) > 280 {
+					t.Errorf("rate = %d trades in 10s, want about 200", len(feeds))
+				}
+			},
+		},
+		{
+			name:      "TestGenerator_Generate flash crash",
+			productID: "BTC-USD",
+			from:      14 * time.Second,
+			to:        16 * time.Second,
+			check: func(t *testing.T, feeds []coinbase.Feed) {
+				for _, feed := range feeds {
+					if price, _ := feed.Price.Float64(); price > 30000*0.95 {
+						t.Errorf("flash crash price = %v at %v, want below %v", price, feed.Time, 30000*0.95)
+					}
+				}
+			},
+		},
+		{
+			name:      "TestGenerator_Generate volume spike",
+			productID: "ETH-USD",
+			from:      30 * time.Second,
+			to:        40 * time.Second,
+			check: func(t *testing.T, spike []coinbase.Feed) {
+				before := window(feeds, config.Start, "ETH-USD", 20*time.Second, 30*time.Second)
+				if len(spike) < 3*len(before) {
+					t.Errorf("volume spike = %d trades, want at least 3 times %d", len(spike), len(before))
+				}
+			},
+		},
+		{
+			name:      "TestGenerator_Generate silence",
+			productID: "BTC-USD",
+			from:      50 * time.Second,
+			to:        55 * time.Second,
+			check: func(t *testing.T, feeds []coinbase.Feed) {
+				if len(feeds) != 0 {
+					t.Errorf("silence = %d trades, want none", len(feeds))
+				}
+			},
+		},
+		{
+			name:      "TestGenerator_Generate sizes",
+			productID: "ETH-USD",
+			from:      0,
+			to:        time.Minute,
+			check: func(t *testing.T, feeds []coinbase.Feed) {
+				for _, feed := range feeds {
+					if size, _ := feed.Size.Float64(); size < 0.01 || size > 500*5 {
+						t.Errorf("size = %v, want between the min size and the spiked max size", size)
+					}
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, window(feeds, config.Start, tt.productID, tt.from, tt.to))
+		})
+	}
+}
+
+func TestNewGenerator(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		// Add TestNewGenerator test cases.
+		{
+			name:   "TestNewGenerator defaults",
+			config: Config{Products: []ProductConfig{{ProductID: "BTC-USD", Price: 100}}},
+		},
+		{
+			name:    "TestNewGenerator missing price",
+			config:  Config{Products: []ProductConfig{{ProductID: "BTC-USD"}}},
+			wantErr: true,
+		},
+		{
+			name:    "TestNewGenerator unknown model",
+			config:  Config{Products: []ProductConfig{{ProductID: "BTC-USD", Price: 100, Model: "heston"}}},
+			wantErr: true,
+		},
+		{
+			name: "TestNewGenerator unknown event",
+			config: Config{
+				Products: []ProductConfig{{ProductID: "BTC-USD", Price: 100}},
+				Events:   []Event{{Kind: "halving"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewGenerator(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("NewGenerator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteFeeds(t *testing.T) {
+	g, _ := newTestGenerator(t)
+	feeds := g.Generate(5 * time.Second)
+
+	var buffer bytes.Buffer
+	if err := WriteFeeds(&buffer, feeds); err != nil {
+		t.Fatalf("WriteFeeds() error = %v", err)
+	}
+
+	// The written feeds are readable by the replay streamer.
+	scanner := bufio.NewScanner(&buffer)
+	for i := 0; scanner.Scan(); i++ {
+		feed, err := replay.DecodeMessage(scanner.Bytes())
+		if err != nil {
+			t.Fatalf("DecodeMessage() error = %v", err)
+		}
+
+		if feed.TradeID != feeds[i].TradeID || feed.Price.Text('f', 8) != feeds[i].Price.Text('f', 8) {
+			t.Errorf("DecodeMessage() = %v, want %v", feed, feeds[i])
+		}
+	}
+}
+
+func TestNewPriceConfig(t *testing.T) {
+	g, err := NewGenerator(NewPriceConfig(1, map[string]float64{"ETH-USD": 3000, "BTC-USD": 40000}))
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+
+	first, ok := g.Next("BTC-USD")
+	second, _ := g.Next("BTC-USD")
+
+	if !ok || first.Type != coinbase.FeedTypeMatch || second.TradeID != first.TradeID+1 || first.Price == nil {
+		t.Errorf("Next() = %v, %v", first, second)
+	}
+
+	if price, _ := second.Price.Float64(); price == 40000 || math.Abs(price/40000-1) > 0.01 {
+		t.Errorf("Next() price = %v, want a move close to 40000", price)
+	}
+
+	if _, ok := g.Next("SOL-USD"); ok {
+		t.Errorf("Next() expected no matches of an unknown product")
+	}
+
+	if !reflect.DeepEqual(g.order, []string{"BTC-USD", "ETH-USD"}) {
+		t.Errorf("NewPriceConfig() products = %v, want them sorted", g.order)
+	}
+}
@@ -0,0 +1,97 @@
+package coinbase
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/rest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultRestURL is the default Coinbase Exchange REST API URL.
+	DefaultRestURL = "https://api.exchange.coinbase.com"
+	// MaxTradesPageSize is the maximum number of trades the trades endpoint returns in a single page.
+	MaxTradesPageSize = 1000
+	// HeaderPageAfter is the response header holding the cursor of the next (older) page.
+	HeaderPageAfter = "CB-AFTER"
+)
+
+// TradeHistory fetches the recent trades of the products from the Coinbase REST API.
+type TradeHistory struct {
+	client *rest.Client
+	logger *logrus.Logger
+}
+
+func NewTradeHistory(ctx context.Context, restURL string) *TradeHistory {
+	return &TradeHistory{
+		client: rest.NewClient(ctx, restURL),
+		logger: logrus.New(),
+	}
+}
+
+func (t *TradeHistory) SetLogger(logger *logrus.Logger) {
+	t.logger = logger
+	t.client.SetLogger(logger)
+}
+
+func (t *TradeHistory) GetClient() *rest.Client {
+	return t.client
+}
+
+// GetTrades fetches up to count most recent trades of a product by following the trades endpoint pagination.
+// The trades are returned as match feeds ordered from the oldest to the newest, so they can be replayed into a
+// sliding window in the same order as the live stream would have delivered them.
+func (t *TradeHistory) GetTrades(productID string, count int) ([]Feed, error) {
+	trades := make([]Feed, 0, count)
+	after := ""
+
+	for len(trades) < count {
+		limit := count - len(trades)
+		if limit > MaxTradesPageSize {
+			limit = MaxTradesPageSize
+		}
+
+		query := url.Values{}
+		query.Set("limit", strconv.Itoa(limit))
+		if after != "" {
+			query.Set("after", after)
+		}
+
+		var page []Feed
+
+		header, err := t.client.Get(fmt.Sprintf("/products/%s/trades", url.PathEscape(productID)), query, &page)
+		if err != nil {
+			t.logger.Errorf("Error fetching trades of %s: %s", productID, err)
+
+			return nil, err
+		}
+
+		trades = append(trades, page...)
+
+		after = header.Get(HeaderPageAfter)
+		if len(page) == 0 || after == "" {
+			break
+		}
+	}
+
+	if len(trades) > count {
+		trades = trades[:count]
+	}
+
+	// The endpoint returns the newest trades first, reverse them to get the chronological order.
+	for i, j := 0, len(trades)-1; i < j; i, j = i+1, j-1 {
+		trades[i], trades[j] = trades[j], trades[i]
+	}
+
+	for i := range trades {
+		trades[i].Type = FeedTypeMatch
+		trades[i].ProductID = productID
+	}
+
+	t.logger.Debugf("Fetched %d trades of %s", len(trades), productID)
+
+	return trades, nil
+}
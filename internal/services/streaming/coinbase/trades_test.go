@@ -0,0 +1,136 @@
+//go:build all
+// +build all
+
+package coinbase
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// newTradesServer serves total trades of BTC-USD with descending trade ids, paginated by the after cursor.
+func newTradesServer(total int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/BTC-USD/trades" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		newest := total
+		if after := r.URL.Query().Get("after"); after != "" {
+			newest, _ = strconv.Atoi(after)
+			newest--
+		}
+
+		body := "["
+		last := newest
+		for id := newest; id > 0 && id > newest-limit; id-- {
+			if id != newest {
+				body += ","
+			}
+			body += fmt.Sprintf(
+				`{"time":"2022-04-13T12:55:32.249480Z","trade_id":%d,"price":"%d.5","size":"0.1","side":"buy"}`,
+				id,
+				40000+id,
+			)
+			last = id
+		}
+		body += "]"
+
+		if last > 1 {
+			w.Header().Set(HeaderPageAfter, strconv.Itoa(last))
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+}
+
+func TestTradeHistory_GetTrades(t *testing.T) {
+	type args struct {
+		productID string
+		count     int
+	}
+	tests := []struct {
+		name        string
+		total       int
+		args        args
+		wantLen     int
+		wantFirstID int
+		wantLastID  int
+		wantErr     bool
+	}{
+		// Add TestTradeHistory_GetTrades test cases.
+		{
+			name:        "TestTradeHistory_GetTrades single page",
+			total:       50,
+			args:        args{productID: "BTC-USD", count: 10},
+			wantLen:     10,
+			wantFirstID: 41,
+			wantLastID:  50,
+		},
+		{
+			name:        "TestTradeHistory_GetTrades multiple pages",
+			total:       2500,
+			args:        args{productID: "BTC-USD", count: 2200},
+			wantLen:     2200,
+			wantFirstID: 301,
+			wantLastID:  2500,
+		},
+		{
+			name:        "TestTradeHistory_GetTrades fewer trades than requested",
+			total:       5,
+			args:        args{productID: "BTC-USD", count: 10},
+			wantLen:     5,
+			wantFirstID: 1,
+			wantLastID:  5,
+		},
+		{
+			name:    "TestTradeHistory_GetTrades unknown product",
+			total:   5,
+			args:    args{productID: "BTC-US", count: 10},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTradesServer(tt.total)
+			defer server.Close()
+
+			th := NewTradeHistory(context.Background(), server.URL)
+
+			got, err := th.GetTrades(tt.args.productID, tt.args.count)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetTrades() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if len(got) != tt.wantLen {
+				t.Fatalf("GetTrades() len = %v, want %v", len(got), tt.wantLen)
+			}
+
+			if got[0].TradeID != tt.wantFirstID || got[len(got)-1].TradeID != tt.wantLastID {
+				t.Errorf(
+					"GetTrades() trade ids = %v..%v, want %v..%v",
+					got[0].TradeID,
+					got[len(got)-1].TradeID,
+					tt.wantFirstID,
+					tt.wantLastID,
+				)
+			}
+
+			for _, trade := range got {
+				if trade.Type != FeedTypeMatch || trade.ProductID != tt.args.productID || trade.Price == nil {
+					t.Errorf("GetTrades() trade = %v, want a %v feed of %v", trade, FeedTypeMatch, tt.args.productID)
+				}
+			}
+		})
+	}
+}
@@ -1,31 +1,33 @@
 package coinbase
 
 import (
-	"math/big"
-	"time"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/protocol"
 )
 
-type SubscribeRequest struct {
-	Type       string    `json:"type"`
-	ProductIds []string  `json:"product_ids"`
-	Channels   []Channel `json:"channels"`
-}
+// The protocol types live in the protocol package, so that the fake server used by the tests of this package can
+// share them.
+const (
+	RequestTypeSubscribe   = protocol.RequestTypeSubscribe
+	RequestTypeUnsubscribe = protocol.RequestTypeUnsubscribe
+	ChannelMatches         = protocol.ChannelMatches
+)
 
-type Channel struct {
-	Name       string   `json:"name"`
-	ProductIds []string `json:"product_ids"`
-}
+const (
+	FeedTypeMatch          = protocol.FeedTypeMatch
+	FeedTypeSubscribeError = protocol.FeedTypeSubscribeError
+	FeedTypeLastMatch      = protocol.FeedTypeLastMatch
+	FeedTypeLevel2Snapshot = protocol.FeedTypeLevel2Snapshot
+	FeedTypeTicker         = protocol.FeedTypeTicker
+)
+
+type SubscribeRequest = protocol.SubscribeRequest
+
+type Channel = protocol.Channel
+
+// Feed is a message of the matches channel.
+type Feed = protocol.Feed
 
-type Feed struct {
-	Type         string     `json:"type"`
-	TradeID      int        `json:"trade_id"`
-	MakerOrderID string     `json:"maker_order_id"`
-	TakerOrderID string     `json:"taker_order_id"`
-	Side         string     `json:"side"`
-	Size         *big.Float `json:"size"`
-	Price        *big.Float `json:"price"`
-	ProductID    string     `json:"product_id"`
-	Sequence     int64      `json:"sequence"`
-	Time         time.Time  `json:"time"`
-	Reason       string     `json:"reason,omitempty"`
+// NewMatchesRequest builds a subscribe or unsubscribe request of the matches channel for the given products.
+func NewMatchesRequest(requestType string, productIds []string) SubscribeRequest {
+	return protocol.NewMatchesRequest(requestType, productIds)
 }
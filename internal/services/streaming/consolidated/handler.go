@@ -0,0 +1,369 @@
+package consolidated
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/handler"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWeight is the weight of the venues without an explicitly set weight.
+const DefaultWeight = 1.0
+
+// ErrNoStreamer is returned by Handle when no streamer has been added.
+var ErrNoStreamer = errors.New("no streamer to handle")
+
+// Contribution is the share of a venue in the consolidated VWAP of an instrument.
+type Contribution struct {
+	Venue    string
+	VWAP     *big.Float
+	Volume   *big.Float
+	Trades   int
+	Weight   float64
+	Excluded bool
+	Updated  time.Time
+}
+
+// Snapshot is the consolidated VWAP of an instrument over the sliding windows of all its venues.
+type Snapshot struct {
+	Instrument    string
+	VWAP          *big.Float
+	Volume        *big.Float
+	Contributions []Contribution
+}
+
+// String formats the snapshot with the contribution of each venue.
+func (s Snapshot) String() string {
+	venues := make([]string, 0, len(s.Contributions))
+
+	for _, c := range s.Contributions {
+		venue := fmt.Sprintf("%s:%s(w=%g)", c.Venue, c.VWAP.Text('f', 8), c.Weight)
+		if c.Excluded {
+			venue += "(excluded)"
+		}
+
+		venues = append(venues, venue)
+	}
+
+	return fmt.Sprintf("%s:%s\t%s", s.Instrument, s.VWAP.Text('f', 8), strings.Join(venues, "\t"))
+}
+
+// Handler consolidates the trades of several streamers, one per venue, into a VWAP per canonical instrument. Each
+// venue keeps its own sliding window of the instrument, and the consolidated VWAP is the sum of the venues' weighted
+// traded values over the sum of their weighted volumes, so a venue's weight scales its volume.
+// It implements the streaming.LifecycleHandler interface, a fatal error of any venue stops all of them.
+type Handler struct {
+	windowSize   int
+	streamers    []streaming.Streamer
+	registry     *instrument.Registry
+	weights      map[string]float64
+	excluded     map[string]bool
+	windows      map[string]map[string]*vwap.SlidingWindow
+	trades       *handler.TradeDeduper
+	updated      map[string]map[string]time.Time
+	now          func() time.Time
+	SnapshotFunc func(s Snapshot) error
+	consumers    sync.WaitGroup
+	done         chan struct{}
+	mu           sync.Mutex
+	logger       *logrus.Logger
+}
+
+func NewHandler(windowSize int) *Handler {
+	return &Handler{
+		windowSize: windowSize,
+		registry:   instrument.NewRegistry(),
+		weights:    make(map[string]float64),
+		excluded:   make(map[string]bool),
+		windows:    make(map[string]map[string]*vwap.SlidingWindow),
+		trades:     handler.NewTradeDeduper(),
+		updated:    make(map[string]map[string]time.Time),
+		now:        time.Now,
+		logger:     logrus.New(),
+	}
+}
+
+func (h *Handler) SetLogger(logger *logrus.Logger) {
+	h.logger = logger
+}
+
+// SetStreamer replaces the streamers with the given one, AddStreamer adds the streamers of the other venues.
+func (h *Handler) SetStreamer(streamer streaming.Streamer) {
+	h.streamers = []streaming.Streamer{streamer}
+}
+
+// AddStreamer adds a streamer whose feeds are consolidated with the other streamers' feeds.
+func (h *Handler) AddStreamer(streamer streaming.Streamer) {
+	h.streamers = append(h.streamers, streamer)
+}
+
+func (h *Handler) GetStreamers() []streaming.Streamer {
+	return h.streamers
+}
+
+// SetRegistry sets the registry used to resolve the venue symbols into the canonical instruments.
+func (h *Handler) SetRegistry(registry *instrument.Registry) {
+	h.registry = registry
+}
+
+// SetWeight sets the weight of a venue in the consolidated VWAP.
+func (h *Handler) SetWeight(venue string, weight float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.weights[strings.ToLower(venue)] = weight
+}
+
+// Exclude excludes a venue from the consolidated VWAP, its contribution is still reported.
+func (h *Handler) Exclude(venue string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.excluded[strings.ToLower(venue)] = true
+}
+
+// Include includes back an excluded venue.
+func (h *Handler) Include(venue string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.excluded, strings.ToLower(venue))
+}
+
+// Handle starts all the streamers and consolidates their feeds until each streamer's context is done, then closes
+// Done. If any of the streamers fails to start, the started ones are stopped.
+func (h *Handler) Handle() error {
+	if len(h.streamers) == 0 {
+		return ErrNoStreamer
+	}
+
+	done := h.doneChannel()
+
+	for i, s := range h.streamers {
+		streamFeeds := make(chan interface{})
+
+		err := s.Stream(streamFeeds)
+		if err != nil {
+			h.logger.Errorf("Error starting stream %s", err)
+
+			for _, started := range h.streamers[:i] {
+				streaming.StopStreamer(started)
+			}
+
+			return err
+		}
+
+		h.consumers.Add(1)
+		go h.consume(s, streamFeeds)
+	}
+
+	go func() {
+		h.consumers.Wait()
+		close(done)
+	}()
+
+	return nil
+}
+
+// Stop stops all the streamers, the handler ends once the feeds being consolidated have been delivered.
+func (h *Handler) Stop() {
+	for _, s := range h.streamers {
+		streaming.StopStreamer(s)
+	}
+}
+
+// Done is closed once the consolidation started by Handle has ended for all the streamers.
+func (h *Handler) Done() <-chan struct{} {
+	return h.doneChannel()
+}
+
+// Err returns the first fatal error a streamer has ended with, if any.
+func (h *Handler) Err() error {
+	for _, s := range h.streamers {
+		if failer, ok := s.(interface{ Err() error }); ok && failer.Err() != nil {
+			return failer.Err()
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) doneChannel() chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.done == nil {
+		h.done = make(chan struct{})
+	}
+
+	return h.done
+}
+
+func (h *Handler) consume(s streaming.Streamer, streamFeeds chan interface{}) {
+	defer h.consumers.Done()
+
+	ctx := s.GetContext()
+
+	for {
+		select {
+		case <-ctx.Done():
+			streaming.StopStreamer(s)
+
+			// A fatal error of a venue ends the consolidation of all of them.
+			if failer, ok := s.(interface{ Err() error }); ok && failer.Err() != nil {
+				h.Stop()
+			}
+
+			return
+		case feed := <-streamFeeds:
+			dataPoint, err := handler.ToDataPoint(feed)
+			if err != nil {
+				h.logger.Errorf("Error converting interface to feed struct %s", err)
+				continue
+			}
+
+			snapshot, err := h.Add(dataPoint)
+			if errors.Is(err, handler.ErrDuplicateTrade) {
+				h.logger.Debugf("Skipping %s %s trade %d %s", dataPoint.Venue, dataPoint.ProductID, dataPoint.TradeID, err)
+				continue
+			}
+
+			if err != nil {
+				h.logger.Errorf("Error processing vwap data %s", err)
+				continue
+			}
+
+			if h.SnapshotFunc == nil {
+				fmt.Printf("Windows Size: %v\t%v\n", h.windowSize, snapshot)
+				continue
+			}
+
+			err = h.SnapshotFunc(snapshot)
+			if err != nil {
+				h.logger.Errorf("Error processing consolidated vwap %s", err)
+			}
+		}
+	}
+}
+
+// Add adds the datapoint to its venue's sliding window of the canonical instrument, and returns the instrument's
+// updated consolidated snapshot.
+func (h *Handler) Add(dataPoint vwap.DataPoint) (Snapshot, error) {
+	dataPoint = handler.Normalize(h.registry, dataPoint)
+	inst := dataPoint.ProductID
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.trades.Add(dataPoint)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if h.windows[inst] == nil {
+		h.windows[inst] = make(map[string]*vwap.SlidingWindow)
+		h.updated[inst] = make(map[string]time.Time)
+	}
+
+	h.updated[inst][dataPoint.Venue] = h.now()
+
+	window, ok := h.windows[inst][dataPoint.Venue]
+	if !ok {
+		window = vwap.NewSlidingWindow(h.windowSize, inst)
+		h.windows[inst][dataPoint.Venue] = window
+	}
+
+	window.Add(dataPoint)
+
+	return h.snapshot(inst), nil
+}
+
+// Snapshot returns the consolidated snapshot of a canonical instrument.
+func (h *Handler) Snapshot(inst string) Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.snapshot(inst)
+}
+
+// Instruments returns the canonical instruments that have received datapoints.
+func (h *Handler) Instruments() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	instruments := make([]string, 0, len(h.windows))
+	for inst := range h.windows {
+		instruments = append(instruments, inst)
+	}
+
+	sort.Strings(instruments)
+
+	return instruments
+}
+
+// Window returns a venue's sliding window of a canonical instrument, or nil if the venue hasn't traded it.
+func (h *Handler) Window(inst string, venue string) *vwap.SlidingWindow {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.windows[inst][venue]
+}
+
+func (h *Handler) snapshot(inst string) Snapshot {
+	snapshot := Snapshot{
+		Instrument:    inst,
+		VWAP:          big.NewFloat(0),
+		Volume:        big.NewFloat(0),
+		Contributions: make([]Contribution, 0, len(h.windows[inst])),
+	}
+
+	value := big.NewFloat(0)
+
+	for venue, window := range h.windows[inst] {
+		calculator := window.GetCalculator()
+
+		weight, ok := h.weights[venue]
+		if !ok {
+			weight = DefaultWeight
+		}
+
+		contribution := Contribution{
+			Venue:    venue,
+			VWAP:     calculator.Avg(),
+			Volume:   new(big.Float).Set(calculator.VolumeSum),
+			Trades:   window.Length(),
+			Weight:   weight,
+			Excluded: h.excluded[venue] || weight <= 0,
+			Updated:  h.updated[inst][venue],
+		}
+
+		snapshot.Contributions = append(snapshot.Contributions, contribution)
+
+		if contribution.Excluded {
+			continue
+		}
+
+		w := big.NewFloat(weight)
+		value.Add(value, new(big.Float).Mul(calculator.ValueSum, w))
+		snapshot.Volume.Add(snapshot.Volume, new(big.Float).Mul(calculator.VolumeSum, w))
+	}
+
+	sort.Slice(snapshot.Contributions, func(i, j int) bool {
+		return snapshot.Contributions[i].Venue < snapshot.Contributions[j].Venue
+	})
+
+	if snapshot.Volume.Sign() > 0 {
+		snapshot.VWAP.Quo(value, snapshot.Volume)
+	}
+
+	return snapshot
+}
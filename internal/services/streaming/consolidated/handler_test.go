@@ -0,0 +1,222 @@
+//go:build all
+// +build all
+
+package consolidated
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming/coinbase/handler"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+	"github.com/sirupsen/logrus"
+)
+
+// feedStreamer is a streamer that pipes the given feeds once streamed.
+type feedStreamer struct {
+	ctx     context.Context
+	feeds   []interface{}
+	stopped chan struct{}
+}
+
+func (s *feedStreamer) GetContext() context.Context     { return s.ctx }
+func (s *feedStreamer) GetClient() *wsclient.Client     { return nil }
+func (s *feedStreamer) SetLogger(logger *logrus.Logger) {}
+func (s *feedStreamer) Stop()                           { close(s.stopped) }
+func (s *feedStreamer) Stream(feeds chan interface{}) error {
+	go func() {
+		for _, feed := range s.feeds {
+			select {
+			case feeds <- feed:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func dataPoint(venue string, productID string, tradeID int, price float64, size float64) vwap.DataPoint {
+	return vwap.DataPoint{
+		Type:      "trade",
+		TradeID:   tradeID,
+		Price:     big.NewFloat(price),
+		Size:      big.NewFloat(size),
+		ProductID: productID,
+		Venue:     venue,
+	}
+}
+
+func TestHandler_Add(t *testing.T) {
+	dataPoints := []vwap.DataPoint{
+		dataPoint(instrument.VenueCoinbase, "BTC-USD", 1, 100, 1),
+		dataPoint(instrument.VenueKraken, "XBT/USD", 1, 110, 1),
+		dataPoint(instrument.VenueBinance, "BTCUSD", 1, 130, 2),
+	}
+
+	tests := []struct {
+		name      string
+		weights   map[string]float64
+		excluded  []string
+		wantVWAP  float64
+		wantCount int
+	}{
+		// Add TestHandler_Add test cases.
+		{
+			name:      "TestHandler_Add equal weights",
+			wantVWAP:  (100 + 110 + 130*2) / 4.0,
+			wantCount: 3,
+		},
+		{
+			name:      "TestHandler_Add weighted",
+			weights:   map[string]float64{instrument.VenueBinance: 0.5},
+			wantVWAP:  (100 + 110 + 130) / 3.0,
+			wantCount: 3,
+		},
+		{
+			name:      "TestHandler_Add excluded",
+			excluded:  []string{instrument.VenueBinance},
+			wantVWAP:  105,
+			wantCount: 3,
+		},
+		{
+			name:      "TestHandler_Add zero weight",
+			weights:   map[string]float64{instrument.VenueKraken: 0, instrument.VenueBinance: 0},
+			wantVWAP:  100,
+			wantCount: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(5)
+			for venue, weight := range tt.weights {
+				h.SetWeight(venue, weight)
+			}
+
+			for _, venue := range tt.excluded {
+				h.Exclude(venue)
+			}
+
+			var (
+				snapshot Snapshot
+				err      error
+			)
+
+			for _, dp := range dataPoints {
+				snapshot, err = h.Add(dp)
+				if err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+
+			if snapshot.Instrument != "BTC-USD" {
+				t.Errorf("Add() instrument = %v, want BTC-USD", snapshot.Instrument)
+			}
+
+			got, _ := snapshot.VWAP.Float64()
+			if diff := got - tt.wantVWAP; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("Add() vwap = %v, want %v", got, tt.wantVWAP)
+			}
+
+			if len(snapshot.Contributions) != tt.wantCount {
+				t.Errorf("Add() contributions = %v, want %v", len(snapshot.Contributions), tt.wantCount)
+			}
+
+			for _, c := range snapshot.Contributions {
+				wantExcluded := c.Weight <= 0
+				for _, venue := range tt.excluded {
+					wantExcluded = wantExcluded || venue == c.Venue
+				}
+
+				if c.Excluded != wantExcluded {
+					t.Errorf("Add() %s excluded = %v, want %v", c.Venue, c.Excluded, wantExcluded)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_Add_duplicate(t *testing.T) {
+	h := NewHandler(5)
+
+	if _, err := h.Add(dataPoint(instrument.VenueKraken, "BTC/USD", 7, 100, 1)); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	// The same trade id on another venue is a different trade.
+	if _, err := h.Add(dataPoint(instrument.VenueBinance, "BTCUSD", 7, 100, 1)); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	if _, err := h.Add(dataPoint(instrument.VenueKraken, "XBT/USD", 7, 100, 1)); !errors.Is(err, handler.ErrDuplicateTrade) {
+		t.Errorf("Add() error = %v, want %v", err, handler.ErrDuplicateTrade)
+	}
+}
+
+func TestHandler_Handle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	coinbaseStreamer := &feedStreamer{
+		ctx: ctx,
+		feeds: []interface{}{
+			coinbase.Feed{Type: "match", TradeID: 1, Price: big.NewFloat(100), Size: big.NewFloat(1), ProductID: "BTC-USD"},
+		},
+		stopped: make(chan struct{}),
+	}
+	krakenStreamer := &feedStreamer{
+		ctx:     ctx,
+		feeds:   []interface{}{dataPoint(instrument.VenueKraken, "XBT/USD", 1, 120, 1)},
+		stopped: make(chan struct{}),
+	}
+
+	snapshots := make(chan Snapshot, 2)
+
+	h := NewHandler(5)
+	h.SetStreamer(coinbaseStreamer)
+	h.AddStreamer(krakenStreamer)
+	h.SnapshotFunc = func(s Snapshot) error {
+		snapshots <- s
+		return nil
+	}
+
+	if err := h.Handle(); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-snapshots:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Handle() timed out waiting for snapshot %d", i)
+		}
+	}
+
+	got, _ := h.Snapshot("BTC-USD").VWAP.Float64()
+	if got != 110 {
+		t.Errorf("Snapshot() vwap = %v, want 110", got)
+	}
+
+	cancel()
+
+	for _, s := range []*feedStreamer{coinbaseStreamer, krakenStreamer} {
+		select {
+		case <-s.stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Handle() didn't stop the streamers")
+		}
+	}
+}
+
+func TestHandler_Handle_noStreamer(t *testing.T) {
+	if err := NewHandler(5).Handle(); !errors.Is(err, ErrNoStreamer) {
+		t.Errorf("Handle() error = %v, want %v", err, ErrNoStreamer)
+	}
+}
@@ -0,0 +1,261 @@
+package consolidated
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxDeviation is the default maximum deviation of a venue's VWAP from the median, as a fraction.
+	DefaultMaxDeviation = 0.02
+	// DefaultMaxStaleness is the default maximum time since a venue's last trade.
+	DefaultMaxStaleness = time.Minute
+	// DefaultMinVenues is the default minimum number of included venues to publish an index value.
+	DefaultMinVenues = 1
+
+	ReasonIncluded  = "included"
+	ReasonExcluded  = "excluded"
+	ReasonNoVolume  = "no volume"
+	ReasonStale     = "stale"
+	ReasonDeviation = "deviation"
+)
+
+// ErrNotEnoughVenues is returned when fewer venues than the minimum pass the index rules.
+var ErrNotEnoughVenues = errors.New("not enough venues")
+
+// IndexConfig is the set of rules selecting the venues of the composite index price.
+type IndexConfig struct {
+	// MaxDeviation is the maximum deviation of a venue's VWAP from the median of the fresh venues, as a fraction,
+	// 0 disables the rule.
+	MaxDeviation float64
+	// MaxStaleness is the maximum time since a venue's last trade, 0 disables the rule.
+	MaxStaleness time.Duration
+	// MinVenues is the minimum number of included venues to publish an index value.
+	MinVenues int
+}
+
+func NewIndexConfig() IndexConfig {
+	return IndexConfig{
+		MaxDeviation: DefaultMaxDeviation,
+		MaxStaleness: DefaultMaxStaleness,
+		MinVenues:    DefaultMinVenues,
+	}
+}
+
+// VenueDecision records whether a venue was included in an index value, and why.
+type VenueDecision struct {
+	Venue     string     `json:"venue"`
+	VWAP      *big.Float `json:"vwap"`
+	Volume    *big.Float `json:"volume"`
+	Weight    float64    `json:"weight"`
+	Updated   time.Time  `json:"updated"`
+	Deviation float64    `json:"deviation"`
+	Included  bool       `json:"included"`
+	Reason    string     `json:"reason"`
+}
+
+// IndexValue is a composite index price with its audit trail. An index value that couldn't be published, e.g. with
+// too few venues, has no price, and Rejection is the reason.
+type IndexValue struct {
+	Instrument   string          `json:"instrument"`
+	Price        *big.Float      `json:"price"`
+	Median       *big.Float      `json:"median"`
+	Time         time.Time       `json:"time"`
+	MaxDeviation float64         `json:"max_deviation"`
+	MaxStaleness time.Duration   `json:"max_staleness"`
+	Venues       []VenueDecision `json:"venues"`
+	Published    bool            `json:"published"`
+	Rejection    string          `json:"rejection,omitempty"`
+}
+
+// IncludedVenues returns the venues the index value was calculated from.
+func (v IndexValue) IncludedVenues() []string {
+	venues := make([]string, 0, len(v.Venues))
+
+	for _, decision := range v.Venues {
+		if decision.Included {
+			venues = append(venues, decision.Venue)
+		}
+	}
+
+	return venues
+}
+
+// AuditLog records every index decision, the published index values and the rejected ones.
+type AuditLog interface {
+	Record(value IndexValue) error
+}
+
+// JSONAuditLog writes the index values as JSON lines.
+type JSONAuditLog struct {
+	writer io.Writer
+	mu     sync.Mutex
+}
+
+func NewJSONAuditLog(writer io.Writer) *JSONAuditLog {
+	return &JSONAuditLog{writer: writer}
+}
+
+func (l *JSONAuditLog) Record(value IndexValue) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.writer.Write(append(line, '\n'))
+
+	return err
+}
+
+// Index calculates the composite index price of an instrument from the per-venue VWAPs of a consolidated snapshot.
+// The venues that are excluded, have no volume or have gone stale are dropped first, then the venues deviating from
+// the median VWAP of the remaining ones by more than the maximum deviation, a fraction of the median, e.g. 0.02 for
+// 2%. The index price is the VWAP of the remaining venues weighted by their volume and venue weight.
+type Index struct {
+	config   IndexConfig
+	auditLog AuditLog
+	now      func() time.Time
+}
+
+func NewIndex(config IndexConfig) *Index {
+	return &Index{
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// SetAuditLog sets the audit log every index decision is recorded to.
+func (i *Index) SetAuditLog(auditLog AuditLog) {
+	i.auditLog = auditLog
+}
+
+// Publish calculates the index value of the snapshot and records it to the audit log. The values rejected with
+// ErrNotEnoughVenues are recorded too, along with their venue decisions, and the error is returned.
+func (i *Index) Publish(snapshot Snapshot) (IndexValue, error) {
+	value, err := i.Calculate(snapshot)
+	if err != nil && !errors.Is(err, ErrNotEnoughVenues) {
+		return value, err
+	}
+
+	value.Published = err == nil
+	if err != nil {
+		value.Rejection = err.Error()
+	}
+
+	if i.auditLog != nil {
+		recordErr := i.auditLog.Record(value)
+		if recordErr != nil {
+			return value, fmt.Errorf("record index value: %w", recordErr)
+		}
+	}
+
+	return value, err
+}
+
+// Calculate calculates the index value of the snapshot, it returns ErrNotEnoughVenues with the venue decisions when
+// too few venues are included.
+func (i *Index) Calculate(snapshot Snapshot) (IndexValue, error) {
+	now := i.now()
+
+	value := IndexValue{
+		Instrument:   snapshot.Instrument,
+		Price:        big.NewFloat(0),
+		Median:       big.NewFloat(0),
+		Time:         now,
+		MaxDeviation: i.config.MaxDeviation,
+		MaxStaleness: i.config.MaxStaleness,
+		Venues:       make([]VenueDecision, 0, len(snapshot.Contributions)),
+	}
+
+	fresh := make([]float64, 0, len(snapshot.Contributions))
+
+	for _, c := range snapshot.Contributions {
+		decision := VenueDecision{
+			Venue:   c.Venue,
+			VWAP:    c.VWAP,
+			Volume:  c.Volume,
+			Weight:  c.Weight,
+			Updated: c.Updated,
+			Reason:  ReasonIncluded,
+		}
+
+		switch {
+		case c.Excluded:
+			decision.Reason = ReasonExcluded
+		case c.Volume == nil || c.Volume.Sign() <= 0:
+			decision.Reason = ReasonNoVolume
+		case i.config.MaxStaleness > 0 && now.Sub(c.Updated) > i.config.MaxStaleness:
+			decision.Reason = ReasonStale
+		default:
+			price, _ := c.VWAP.Float64()
+			fresh = append(fresh, price)
+		}
+
+		value.Venues = append(value.Venues, decision)
+	}
+
+	if len(fresh) == 0 {
+		return value, ErrNotEnoughVenues
+	}
+
+	median := medianOf(fresh)
+	value.Median.SetFloat64(median)
+
+	totalValue := big.NewFloat(0)
+	totalVolume := big.NewFloat(0)
+	included := 0
+
+	for j := range value.Venues {
+		decision := &value.Venues[j]
+		if decision.Reason != ReasonIncluded {
+			continue
+		}
+
+		price, _ := decision.VWAP.Float64()
+		if median != 0 {
+			decision.Deviation = math.Abs(price-median) / median
+		}
+
+		if i.config.MaxDeviation > 0 && decision.Deviation > i.config.MaxDeviation {
+			decision.Reason = ReasonDeviation
+			continue
+		}
+
+		decision.Included = true
+		included++
+
+		weightedVolume := new(big.Float).Mul(decision.Volume, big.NewFloat(decision.Weight))
+		totalVolume.Add(totalVolume, weightedVolume)
+		totalValue.Add(totalValue, new(big.Float).Mul(decision.VWAP, weightedVolume))
+	}
+
+	if included < i.config.MinVenues || included == 0 || totalVolume.Sign() <= 0 {
+		return value, fmt.Errorf("%w: %d of %d included", ErrNotEnoughVenues, included, len(value.Venues))
+	}
+
+	value.Price.Quo(totalValue, totalVolume)
+
+	return value, nil
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
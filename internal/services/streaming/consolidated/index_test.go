@@ -0,0 +1,182 @@
+//go:build all
+// +build all
+
+package consolidated
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIndex_Calculate(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	contribution := func(venue string, vwap float64, volume float64, age time.Duration) Contribution {
+		return Contribution{
+			Venue:   venue,
+			VWAP:    big.NewFloat(vwap),
+			Volume:  big.NewFloat(volume),
+			Weight:  DefaultWeight,
+			Updated: now.Add(-age),
+		}
+	}
+
+	tests := []struct {
+		name          string
+		config        IndexConfig
+		contributions []Contribution
+		wantPrice     float64
+		wantReasons   []string
+		wantErr       error
+	}{
+		// Add TestIndex_Calculate test cases.
+		{
+			name:   "TestIndex_Calculate all included",
+			config: NewIndexConfig(),
+			contributions: []Contribution{
+				contribution("binance", 100, 1, time.Second),
+				contribution("coinbase", 101, 3, time.Second),
+			},
+			wantPrice:   100.75,
+			wantReasons: []string{ReasonIncluded, ReasonIncluded},
+		},
+		{
+			name:   "TestIndex_Calculate outlier",
+			config: NewIndexConfig(),
+			contributions: []Contribution{
+				contribution("binance", 100, 1, time.Second),
+				contribution("coinbase", 101, 1, time.Second),
+				contribution("kraken", 110, 1, time.Second),
+			},
+			wantPrice:   100.5,
+			wantReasons: []string{ReasonIncluded, ReasonIncluded, ReasonDeviation},
+		},
+		{
+			name:   "TestIndex_Calculate stale and excluded",
+			config: NewIndexConfig(),
+			contributions: []Contribution{
+				contribution("binance", 100, 1, time.Hour),
+				{Venue: "coinbase", VWAP: big.NewFloat(90), Volume: big.NewFloat(1), Excluded: true, Updated: now},
+				contribution("kraken", 102, 1, time.Second),
+			},
+			wantPrice:   102,
+			wantReasons: []string{ReasonStale, ReasonExcluded, ReasonIncluded},
+		},
+		{
+			name:   "TestIndex_Calculate not enough venues",
+			config: IndexConfig{MaxDeviation: 0.01, MinVenues: 2},
+			contributions: []Contribution{
+				contribution("binance", 100, 1, time.Second),
+				contribution("kraken", 0, 0, time.Second),
+			},
+			wantReasons: []string{ReasonIncluded, ReasonNoVolume},
+			wantErr:     ErrNotEnoughVenues,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := NewIndex(tt.config)
+			index.now = func() time.Time { return now }
+
+			got, err := index.Calculate(Snapshot{Instrument: "BTC-USD", Contributions: tt.contributions})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Calculate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			reasons := make([]string, 0, len(got.Venues))
+			for _, decision := range got.Venues {
+				reasons = append(reasons, decision.Reason)
+			}
+
+			if !reflect.DeepEqual(reasons, tt.wantReasons) {
+				t.Errorf("Calculate() reasons = %v, want %v", reasons, tt.wantReasons)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			price, _ := got.Price.Float64()
+			if diff := price - tt.wantPrice; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("Calculate() price = %v, want %v", price, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestIndex_Publish(t *testing.T) {
+	var buffer bytes.Buffer
+
+	index := NewIndex(NewIndexConfig())
+	index.SetAuditLog(NewJSONAuditLog(&buffer))
+
+	snapshot := Snapshot{
+		Instrument: "ETH-USD",
+		Contributions: []Contribution{
+			{Venue: "coinbase", VWAP: big.NewFloat(3000), Volume: big.NewFloat(2), Weight: 1, Updated: time.Now()},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := index.Publish(snapshot); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("Publish() audit lines = %d, want 2", len(lines))
+	}
+
+	var value IndexValue
+	if err := json.Unmarshal(lines[0], &value); err != nil {
+		t.Fatalf("Unmarshal audit line failed %v", err)
+	}
+
+	included := value.IncludedVenues()
+	if value.Instrument != "ETH-USD" || !value.Published || !reflect.DeepEqual(included, []string{"coinbase"}) {
+		t.Errorf("Publish() audit = %v", string(lines[0]))
+	}
+}
+
+func TestIndex_Publish_rejected(t *testing.T) {
+	var buffer bytes.Buffer
+
+	config := NewIndexConfig()
+	config.MinVenues = 2
+
+	index := NewIndex(config)
+	index.SetAuditLog(NewJSONAuditLog(&buffer))
+
+	snapshot := Snapshot{
+		Instrument: "ETH-USD",
+		Contributions: []Contribution{
+			{Venue: "coinbase", VWAP: big.NewFloat(3000), Volume: big.NewFloat(2), Weight: 1, Updated: time.Now()},
+			{Venue: "kraken", VWAP: big.NewFloat(3000), Volume: big.NewFloat(0), Weight: 1, Updated: time.Now()},
+		},
+	}
+
+	if _, err := index.Publish(snapshot); !errors.Is(err, ErrNotEnoughVenues) {
+		t.Fatalf("Publish() error = %v, want %v", err, ErrNotEnoughVenues)
+	}
+
+	// The rejected decision is audited with the reasons of its venues.
+	var value IndexValue
+	if err := json.Unmarshal(bytes.TrimSpace(buffer.Bytes()), &value); err != nil {
+		t.Fatalf("Unmarshal audit line failed %v", err)
+	}
+
+	if value.Published || value.Rejection == "" {
+		t.Errorf("Publish() audit published = %v rejection = %q, want a rejection", value.Published, value.Rejection)
+	}
+
+	reasons := []string{value.Venues[0].Reason, value.Venues[1].Reason}
+	if want := []string{ReasonIncluded, ReasonNoVolume}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Publish() audit reasons = %v, want %v", reasons, want)
+	}
+}
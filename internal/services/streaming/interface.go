@@ -2,11 +2,16 @@ package streaming
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrSubscribeFailed is the fatal error of the streamers whose subscription has been rejected.
+var ErrSubscribeFailed = errors.New("subscribe failed")
+
 // StreamDataHandler is the interface for implementing incoming data processing handler.
 type StreamDataHandler interface {
 	SetStreamer(streamer Streamer)
@@ -21,3 +26,27 @@ type Streamer interface {
 	SetLogger(logger *logrus.Logger)
 	Stream(streamFeeds chan interface{}) error
 }
+
+// Stopper is implemented by the streamers and the handlers that can be stopped.
+type Stopper interface {
+	Stop()
+}
+
+// Finisher is implemented by the streamers and the handlers that end, on their own or once stopped. Done is closed
+// once they have ended, and Err returns the fatal error they have ended with, if any.
+type Finisher interface {
+	Done() <-chan struct{}
+	Err() error
+}
+
+// LifecycleHandler is a stream data handler that can be stopped and reports when it has ended, it's run by a Pipeline.
+type LifecycleHandler interface {
+	StreamDataHandler
+	Stopper
+	Finisher
+}
+
+// MessageRecorder is the interface for recording the raw messages with the time they were received at.
+type MessageRecorder interface {
+	Record(message string, receivedAt time.Time)
+}
@@ -0,0 +1,287 @@
+package kraken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/services/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWebSocketURL is the Kraken v2 public websocket URL.
+const DefaultWebSocketURL = "wss://ws.kraken.com/v2"
+
+// Streamer is a streaming service for Kraken. It implements the streaming.Streamer interface.
+// It subscribes to the v2 trade channel of the products and pipes every trade as a vwap.DataPoint keyed by the
+// product id it was subscribed with, both the BTC/USD and the legacy XBT/USD symbols map to the same product.
+type Streamer struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wsURL       string
+	client      *wsclient.Client
+	products    map[string]string
+	requestID   int64
+	reconnector *streaming.Reconnector
+	err         error
+	mu          sync.Mutex
+	logger      *logrus.Logger
+}
+
+func NewStreamer(ctx context.Context, wsURL string, productIds []string) *Streamer {
+	ctx, cancel := context.WithCancel(ctx)
+
+	products := make(map[string]string, len(productIds))
+	for _, productID := range productIds {
+		products[Symbol(productID)] = productID
+	}
+
+	return &Streamer{
+		ctx:         ctx,
+		cancel:      cancel,
+		wsURL:       wsURL,
+		client:      wsclient.NewClient(ctx, wsURL),
+		products:    products,
+		reconnector: streaming.NewReconnector(streaming.DefaultReconnectDelay, streaming.DefaultMaxReconnectDelay),
+		logger:      logrus.New(),
+	}
+}
+
+func (s *Streamer) SetLogger(logger *logrus.Logger) {
+	s.logger = logger
+	s.client.SetLogger(logger)
+}
+
+// SetReconnectDelay sets the initial and the maximum delay between the reconnect attempts, the delay doubles after
+// every failed attempt. A zero initial delay disables reconnecting.
+func (s *Streamer) SetReconnectDelay(delay time.Duration, maxDelay time.Duration) {
+	s.reconnector.Delay = delay
+	s.reconnector.MaxDelay = maxDelay
+}
+
+func (s *Streamer) GetClient() *wsclient.Client {
+	return s.client
+}
+
+func (s *Streamer) GetContext() context.Context {
+	return s.ctx
+}
+
+// Stream subscribes to the trade channel of the products and pipes the trades to the streamFeeds channel. When the
+// connection drops unexpectedly, the streamer reconnects and re-sends the subscriptions.
+func (s *Streamer) Stream(streamFeeds chan interface{}) error {
+	client := s.client
+
+	ctx := s.ctx
+
+	client.OnConnected = func(socket wsclient.Client) {
+		s.logger.Infoln("Connected to kraken server.")
+	}
+
+	client.OnConnectError = func(err error, socket wsclient.Client) {
+		s.logger.Infoln("Received connect error ", err)
+	}
+
+	client.OnReceivingMsg = func(message string, socket wsclient.Client) {
+		var m Message
+
+		err := json.Unmarshal([]byte(message), &m)
+		if err != nil {
+			s.logger.Errorf("Error unmarshalling message %s", err)
+			return
+		}
+
+		// Acknowledgements of the requests have no channel.
+		if m.Channel == "" {
+			s.handleResponse([]byte(message))
+			return
+		}
+
+		trades, err := m.Trades()
+		if err != nil {
+			s.logger.Errorf("Error unmarshalling trades %s", err)
+			return
+		}
+
+		for _, trade := range trades {
+			s.mu.Lock()
+			productID, ok := s.products[Symbol(trade.Symbol)]
+			s.mu.Unlock()
+
+			if !ok {
+				s.logger.Warnf("Received trade of unknown symbol %s", trade.Symbol)
+				continue
+			}
+
+			dataPoint, err := trade.ToDataPoint(productID)
+			if err != nil {
+				s.logger.Errorf("Error converting trade %d %s", trade.TradeID, err)
+				continue
+			}
+
+			dataPoint.ReceivedAt = socket.ReceivedAt()
+
+			// The datapoints are piped in the order they're received, the handler relies on the increasing trade ids.
+			select {
+			case streamFeeds <- dataPoint:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+
+	client.OnDisconnected = func(err error, socket wsclient.Client) {
+		if err == nil {
+			s.logger.Infoln("Disconnected from server")
+			return
+		}
+
+		s.logger.Errorf("Received disconnect error %s", err)
+		s.reconnector.Reconnect(ctx, s.client, s.subscribe, s.logger)
+	}
+
+	if !client.IsConnected() {
+		err := client.Connect()
+		if err != nil {
+			s.logger.Errorf("Error connecting to server %s", err)
+
+			return err
+		}
+	}
+
+	return s.subscribe()
+}
+
+// handleResponse stops the stream on subscribe errors.
+func (s *Streamer) handleResponse(message []byte) {
+	var response Response
+
+	err := json.Unmarshal(message, &response)
+	if err != nil {
+		s.logger.Errorf("Error unmarshalling response %s", err)
+		return
+	}
+
+	if !response.Success {
+		s.logger.Errorf("Received %s error: %v", response.Method, errors.New(response.Error))
+		if response.Method == MethodSubscribe {
+			s.fail(fmt.Errorf("%w: %s", streaming.ErrSubscribeFailed, response.Error))
+		}
+
+		return
+	}
+
+	s.logger.Debugf("Request %d %s succeeded", response.ReqID, response.Method)
+}
+
+func (s *Streamer) subscribe() error {
+	s.mu.Lock()
+	productIds := make([]string, 0, len(s.products))
+	for _, productID := range s.products {
+		productIds = append(productIds, productID)
+	}
+	s.mu.Unlock()
+
+	sort.Strings(productIds)
+
+	return s.sendRequest(MethodSubscribe, productIds, true)
+}
+
+// Subscribe subscribes the running stream to the trades of additional products.
+func (s *Streamer) Subscribe(productIds []string) error {
+	s.mu.Lock()
+	for _, productID := range productIds {
+		s.products[Symbol(productID)] = productID
+	}
+	s.mu.Unlock()
+
+	return s.sendRequest(MethodSubscribe, productIds, true)
+}
+
+// Unsubscribe unsubscribes the running stream from the trades of the products.
+func (s *Streamer) Unsubscribe(productIds []string) error {
+	s.mu.Lock()
+	for _, productID := range productIds {
+		delete(s.products, Symbol(productID))
+	}
+	s.mu.Unlock()
+
+	return s.sendRequest(MethodUnsubscribe, productIds, false)
+}
+
+func (s *Streamer) sendRequest(method string, productIds []string, snapshot bool) error {
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	symbols := make([]string, 0, len(productIds))
+	for _, productID := range productIds {
+		symbols = append(symbols, Symbol(productID))
+	}
+
+	s.mu.Lock()
+	s.requestID++
+	id := s.requestID
+	s.mu.Unlock()
+
+	request, err := json.Marshal(Request{
+		Method: method,
+		Params: RequestParams{Channel: ChannelTrade, Symbol: symbols, Snapshot: snapshot},
+		ReqID:  id,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.client.SendRequest(string(request))
+	if err != nil {
+		s.logger.Errorf("Error sending %s request %s", method, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Stop cancels the context of the streamer, so that the feed being piped is dropped rather than blocking the close,
+// then stops reconnecting and closes the connection.
+func (s *Streamer) Stop() {
+	s.cancelContext()
+	s.reconnector.Stop()
+
+	if s.client.IsConnected() {
+		s.client.Close()
+	}
+}
+
+// Err returns the fatal error that has ended the stream, e.g. a rejected subscription, if any.
+func (s *Streamer) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// fail ends the stream with a fatal error, the first one is kept. It's called from the client callbacks, so it only
+// cancels the context, which closes the connection.
+func (s *Streamer) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+
+	s.cancelContext()
+}
+
+// cancelContext cancels the context of the streamer, which stops piping the feeds and reconnecting.
+func (s *Streamer) cancelContext() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
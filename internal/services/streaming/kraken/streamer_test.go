@@ -0,0 +1,158 @@
+//go:build all
+// +build all
+
+package kraken
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// newFakeServer starts a local websocket server speaking the Kraken v2 subscribe protocol. It greets with a status
+// message, acknowledges the subscribe requests and replies with the trade fixtures, the requests of unsupported
+// symbols get the error fixture.
+func newFakeServer(t *testing.T, requests chan<- Request) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	status := readFixture(t, "message_feed_kraken_status.json")
+	replies := []string{
+		readFixture(t, "message_feed_kraken_subscribe_ack.json"),
+		readFixture(t, "message_feed_kraken_trade_snapshot.json"),
+		readFixture(t, "message_feed_kraken_heartbeat.json"),
+		readFixture(t, "message_feed_kraken_trade_update.json"),
+	}
+	subscribeError := readFixture(t, "message_feed_kraken_subscribe_error.json")
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(status))
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var req Request
+			if json.Unmarshal(message, &req) != nil {
+				continue
+			}
+			requests <- req
+
+			if unsupportedSymbol(req.Params.Symbol) {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(subscribeError))
+				continue
+			}
+
+			if req.Method != MethodSubscribe {
+				continue
+			}
+
+			for _, reply := range replies {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(reply))
+			}
+		}
+	}))
+}
+
+func unsupportedSymbol(symbols []string) bool {
+	for _, symbol := range symbols {
+		if strings.HasSuffix(symbol, "/US") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestStreamer_Stream(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	tests := []struct {
+		name        string
+		productIds  []string
+		wantSymbols []string
+		want        []vwap.DataPoint
+		wantCancel  bool
+	}{
+		// Add TestStreamer_Stream test cases.
+		{
+			name:        "TestStreamer_Stream",
+			productIds:  []string{"XBT-USD", "ETH-USD"},
+			wantSymbols: []string{"ETH/USD", "BTC/USD"},
+			want: []vwap.DataPoint{
+				{Type: ChannelTrade, TradeID: 41823012, ProductID: "XBT-USD"},
+				{Type: ChannelTrade, TradeID: 41823013, ProductID: "XBT-USD"},
+				{Type: ChannelTrade, TradeID: 20932841, ProductID: "ETH-USD"},
+			},
+		},
+		{
+			name:        "TestStreamer_Stream subscribe error",
+			productIds:  []string{"XBT-US"},
+			wantSymbols: []string{"BTC/US"},
+			wantCancel:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := make(chan Request, 10)
+			server := newFakeServer(t, requests)
+			defer server.Close()
+
+			s := NewStreamer(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), tt.productIds)
+			s.SetLogger(logger)
+			defer s.Stop()
+
+			streamFeeds := make(chan interface{})
+			if err := s.Stream(streamFeeds); err != nil {
+				t.Fatalf("Stream() error = %v", err)
+			}
+
+			select {
+			case got := <-requests:
+				if got.Method != MethodSubscribe || got.Params.Channel != ChannelTrade || !got.Params.Snapshot ||
+					strings.Join(got.Params.Symbol, ",") != strings.Join(tt.wantSymbols, ",") {
+					t.Errorf("Stream() request = %v, want subscribe of %v", got, tt.wantSymbols)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Stream() request not received")
+			}
+
+			if tt.wantCancel {
+				select {
+				case <-s.GetContext().Done():
+				case <-time.After(5 * time.Second):
+					t.Errorf("Stream() context not cancelled on subscribe error")
+				}
+
+				return
+			}
+
+			for _, want := range tt.want {
+				select {
+				case feed := <-streamFeeds:
+					got := feed.(vwap.DataPoint)
+					if got.Type != want.Type || got.TradeID != want.TradeID || got.ProductID != want.ProductID {
+						t.Errorf("Stream() datapoint = %v, want %v", got, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Stream() datapoint not received, want %v", want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package kraken
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+)
+
+const (
+	ChannelTrade     = "trade"
+	ChannelHeartbeat = "heartbeat"
+	ChannelStatus    = "status"
+
+	MethodSubscribe   = "subscribe"
+	MethodUnsubscribe = "unsubscribe"
+
+	MessageTypeSnapshot = "snapshot"
+	MessageTypeUpdate   = "update"
+)
+
+// registry resolves the product ids into the canonical instruments, mapping the legacy Kraken asset codes such as XBT
+// to the common ones.
+var registry = instrument.NewRegistry()
+
+// Request is the Kraken v2 subscribe or unsubscribe request.
+type Request struct {
+	Method string        `json:"method"`
+	Params RequestParams `json:"params"`
+	ReqID  int64         `json:"req_id"`
+}
+
+type RequestParams struct {
+	Channel  string   `json:"channel"`
+	Symbol   []string `json:"symbol"`
+	Snapshot bool     `json:"snapshot,omitempty"`
+}
+
+// Response is the acknowledgement of a request, Kraken sends one per requested symbol.
+type Response struct {
+	Method  string `json:"method"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+	ReqID   int64  `json:"req_id"`
+}
+
+// Message is a channel message, the trade channel data is an array of trades.
+type Message struct {
+	Channel string          `json:"channel"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Trade is a trade of the trade channel. Kraken sends the prices and the quantities as JSON numbers, they are kept as
+// json.Number to be parsed into big.Float without losing precision.
+type Trade struct {
+	Symbol    string      `json:"symbol"`
+	Side      string      `json:"side"`
+	Price     json.Number `json:"price"`
+	Qty       json.Number `json:"qty"`
+	OrdType   string      `json:"ord_type"`
+	TradeID   int         `json:"trade_id"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Trades decodes the trades of a trade channel message.
+func (m Message) Trades() ([]Trade, error) {
+	if m.Channel != ChannelTrade || len(m.Data) == 0 {
+		return nil, nil
+	}
+
+	var trades []Trade
+
+	err := json.Unmarshal(m.Data, &trades)
+	if err != nil {
+		return nil, err
+	}
+
+	return trades, nil
+}
+
+// ToDataPoint maps the trade into the datapoint of the product's sliding window.
+func (t Trade) ToDataPoint(productID string) (vwap.DataPoint, error) {
+	price, _, err := big.ParseFloat(t.Price.String(), 10, 0, big.ToNearestEven)
+	if err != nil {
+		return vwap.DataPoint{}, err
+	}
+
+	size, _, err := big.ParseFloat(t.Qty.String(), 10, 0, big.ToNearestEven)
+	if err != nil {
+		return vwap.DataPoint{}, err
+	}
+
+	return vwap.DataPoint{
+		Type:      ChannelTrade,
+		TradeID:   t.TradeID,
+		Size:      size,
+		Price:     price,
+		ProductID: productID,
+		Venue:     instrument.VenueKraken,
+		Time:      t.Timestamp,
+	}, nil
+}
+
+// Symbol converts a product id such as BTC-USD or XBT/USD into the Kraken v2 symbol BTC/USD.
+func Symbol(productID string) string {
+	inst := registry.ResolveOrRaw(instrument.VenueKraken, productID)
+	if inst.Quote == "" {
+		return inst.Base
+	}
+
+	return inst.Base + "/" + inst.Quote
+}
@@ -0,0 +1,119 @@
+//go:build all
+// +build all
+
+package kraken
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDataDir = "../../../../tests/data"
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(testDataDir, name))
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", name, err)
+	}
+
+	return string(data)
+}
+
+func TestMessage_Trades(t *testing.T) {
+	type trade struct {
+		symbol  string
+		tradeID int
+		price   string
+		size    string
+	}
+	tests := []struct {
+		name    string
+		fixture string
+		want    []trade
+	}{
+		// Add TestMessage_Trades test cases.
+		{
+			name:    "TestMessage_Trades snapshot",
+			fixture: "message_feed_kraken_trade_snapshot.json",
+			want: []trade{
+				{symbol: "BTC/USD", tradeID: 41823012, price: "40128.6", size: "0.00130039"},
+				{symbol: "BTC/USD", tradeID: 41823013, price: "40129.7", size: "0.00002447"},
+			},
+		},
+		{
+			name:    "TestMessage_Trades update",
+			fixture: "message_feed_kraken_trade_update.json",
+			want: []trade{
+				{symbol: "ETH/USD", tradeID: 20932841, price: "3019.21", size: "0.00510154"},
+			},
+		},
+		{
+			name:    "TestMessage_Trades heartbeat",
+			fixture: "message_feed_kraken_heartbeat.json",
+		},
+		{
+			name:    "TestMessage_Trades status",
+			fixture: "message_feed_kraken_status.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Message
+			if err := json.Unmarshal([]byte(readFixture(t, tt.fixture)), &m); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			trades, err := m.Trades()
+			if err != nil {
+				t.Fatalf("Trades() error = %v", err)
+			}
+
+			if len(trades) != len(tt.want) {
+				t.Fatalf("Trades() = %v, want %v", trades, tt.want)
+			}
+
+			for i, want := range tt.want {
+				dataPoint, err := trades[i].ToDataPoint("product")
+				if err != nil {
+					t.Fatalf("ToDataPoint() error = %v", err)
+				}
+
+				got := trade{
+					symbol:  trades[i].Symbol,
+					tradeID: dataPoint.TradeID,
+					price:   dataPoint.Price.Text('f', -1),
+					size:    dataPoint.Size.Text('f', -1),
+				}
+				if got != want {
+					t.Errorf("Trades()[%d] = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSymbol(t *testing.T) {
+	tests := []struct {
+		name      string
+		productID string
+		want      string
+	}{
+		// Add TestSymbol test cases.
+		{name: "TestSymbol dash", productID: "BTC-USD", want: "BTC/USD"},
+		{name: "TestSymbol slash", productID: "eth/usd", want: "ETH/USD"},
+		{name: "TestSymbol legacy asset", productID: "XBT/USD", want: "BTC/USD"},
+		{name: "TestSymbol legacy assets", productID: "XDG-XBT", want: "DOGE/BTC"},
+		{name: "TestSymbol no separator", productID: "XBTEUR", want: "BTC/EUR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Symbol(tt.productID); got != tt.want {
+				t.Errorf("Symbol() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrPipelineStarted is returned when a pipeline is started more than once.
+	ErrPipelineStarted = errors.New("pipeline already started")
+	// ErrPipelineNotStarted is returned when waiting for a pipeline that hasn't been started.
+	ErrPipelineNotStarted = errors.New("pipeline not started")
+)
+
+// StopStreamer stops the streamer, or closes its client when it can't be stopped.
+func StopStreamer(s Streamer) {
+	if stopper, ok := s.(Stopper); ok {
+		stopper.Stop()
+	} else if client := s.GetClient(); client != nil {
+		client.Close()
+	}
+}
+
+// Pipeline runs a stream data handler, and through it the streamers, from the start to the end. It ends once it's
+// stopped, once the context it was started with is done, or once the handler has ended on its own, e.g. on a fatal
+// error of a streamer or at the end of a replay. Ending stops the handler, which stops its streamers and closes their
+// connections, and waits for the messages already handed to the handler to be processed and delivered.
+type Pipeline struct {
+	handler  LifecycleHandler
+	started  bool
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	err      error
+	mu       sync.Mutex
+	logger   *logrus.Logger
+}
+
+func NewPipeline(handler LifecycleHandler) *Pipeline {
+	return &Pipeline{
+		handler: handler,
+		stopCh:  make(chan struct{}),
+		done:    make(chan struct{}),
+		logger:  logrus.New(),
+	}
+}
+
+func (p *Pipeline) SetLogger(logger *logrus.Logger) {
+	p.logger = logger
+}
+
+// Start starts the handler, the pipeline runs until it's stopped or the context is done. The error of the handler
+// failing to start is also returned by Wait.
+func (p *Pipeline) Start(ctx context.Context) error {
+	p.mu.Lock()
+	if p.started {
+		p.mu.Unlock()
+		return ErrPipelineStarted
+	}
+	p.started = true
+	p.mu.Unlock()
+
+	err := p.handler.Handle()
+	if err != nil {
+		p.finish(err)
+		return err
+	}
+
+	go p.run(ctx)
+
+	return nil
+}
+
+func (p *Pipeline) run(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		p.logger.Infoln("Context done, stopping the pipeline")
+	case <-p.stopCh:
+		p.logger.Infoln("Stopping the pipeline")
+	case <-p.handler.Done():
+	}
+
+	p.handler.Stop()
+	<-p.handler.Done()
+
+	err := p.handler.Err()
+	if err != nil {
+		p.logger.Errorf("Pipeline failed %s", err)
+	}
+
+	p.finish(err)
+}
+
+func (p *Pipeline) finish(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+
+	close(p.done)
+}
+
+// Stop stops the pipeline and waits for it to end, or for the context to be done, e.g. a shutdown deadline, whose
+// error is returned then. Stopping a pipeline that hasn't been started does nothing.
+func (p *Pipeline) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	started := p.started
+	p.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed once the pipeline has ended.
+func (p *Pipeline) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the first fatal error the pipeline has ended with, nil while it's running or when it has been stopped
+// cleanly.
+func (p *Pipeline) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Wait waits for the pipeline to end and returns its first fatal error.
+func (p *Pipeline) Wait() error {
+	p.mu.Lock()
+	started := p.started
+	p.mu.Unlock()
+
+	if !started {
+		return ErrPipelineNotStarted
+	}
+
+	<-p.done
+
+	return p.Err()
+}
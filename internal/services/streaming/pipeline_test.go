@@ -0,0 +1,259 @@
+//go:build all
+// +build all
+
+package streaming
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/goleak"
+)
+
+var errTestFatal = errors.New("fatal")
+
+// lifecycleHandler is a LifecycleHandler that ends when it's stopped or fails, and takes stopDelay to drain.
+type lifecycleHandler struct {
+	handleErr error
+	stopDelay time.Duration
+	stopped   int
+	err       error
+	done      chan struct{}
+	once      sync.Once
+	mu        sync.Mutex
+}
+
+func newLifecycleHandler() *lifecycleHandler {
+	return &lifecycleHandler{done: make(chan struct{})}
+}
+
+func (h *lifecycleHandler) SetStreamer(streamer Streamer) {}
+
+func (h *lifecycleHandler) SetLogger(logger *logrus.Logger) {}
+
+func (h *lifecycleHandler) Handle() error {
+	return h.handleErr
+}
+
+func (h *lifecycleHandler) Stop() {
+	h.mu.Lock()
+	h.stopped++
+	h.mu.Unlock()
+
+	h.end(nil)
+}
+
+// fail ends the handler with the fatal error.
+func (h *lifecycleHandler) fail(err error) {
+	h.end(err)
+}
+
+func (h *lifecycleHandler) end(err error) {
+	h.once.Do(func() {
+		h.mu.Lock()
+		h.err = err
+		h.mu.Unlock()
+
+		go func() {
+			time.Sleep(h.stopDelay)
+			close(h.done)
+		}()
+	})
+}
+
+func (h *lifecycleHandler) Done() <-chan struct{} {
+	return h.done
+}
+
+func (h *lifecycleHandler) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.err
+}
+
+func (h *lifecycleHandler) stops() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.stopped
+}
+
+func newTestPipeline(h LifecycleHandler) *Pipeline {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	p := NewPipeline(h)
+	p.SetLogger(logger)
+
+	return p
+}
+
+func TestPipeline(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	tests := []struct {
+		name    string
+		end     func(p *Pipeline, h *lifecycleHandler, cancel context.CancelFunc) error
+		wantErr error
+	}{
+		// Add TestPipeline test cases.
+		{
+			name: "TestPipeline stop",
+			end: func(p *Pipeline, h *lifecycleHandler, cancel context.CancelFunc) error {
+				return p.Stop(context.Background())
+			},
+		},
+		{
+			name: "TestPipeline context done",
+			end: func(p *Pipeline, h *lifecycleHandler, cancel context.CancelFunc) error {
+				cancel()
+				return nil
+			},
+		},
+		{
+			name: "TestPipeline fatal error",
+			end: func(p *Pipeline, h *lifecycleHandler, cancel context.CancelFunc) error {
+				h.fail(errTestFatal)
+				return nil
+			},
+			wantErr: errTestFatal,
+		},
+		{
+			name: "TestPipeline stop after fatal error",
+			end: func(p *Pipeline, h *lifecycleHandler, cancel context.CancelFunc) error {
+				h.fail(errTestFatal)
+				<-p.Done()
+				return p.Stop(context.Background())
+			},
+			wantErr: errTestFatal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			h := newLifecycleHandler()
+			h.stopDelay = 10 * time.Millisecond
+			p := newTestPipeline(h)
+
+			err := p.Start(ctx)
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			err = tt.end(p, h, cancel)
+			if err != nil {
+				t.Fatalf("Stop() error = %v", err)
+			}
+
+			err = p.Wait()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Wait() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// The handler has drained before the pipeline ends, and is stopped however it ends.
+			select {
+			case <-h.Done():
+			default:
+				t.Errorf("Wait() returned before the handler has ended")
+			}
+
+			if h.stops() == 0 {
+				t.Errorf("handler not stopped")
+			}
+		})
+	}
+}
+
+func TestPipeline_Start(t *testing.T) {
+	h := newLifecycleHandler()
+	h.handleErr = errTestFatal
+	p := newTestPipeline(h)
+
+	err := p.Start(context.Background())
+	if !errors.Is(err, errTestFatal) {
+		t.Errorf("Start() error = %v, want %v", err, errTestFatal)
+	}
+
+	err = p.Wait()
+	if !errors.Is(err, errTestFatal) {
+		t.Errorf("Wait() error = %v, want %v", err, errTestFatal)
+	}
+
+	err = p.Start(context.Background())
+	if !errors.Is(err, ErrPipelineStarted) {
+		t.Errorf("Start() error = %v, want %v", err, ErrPipelineStarted)
+	}
+}
+
+func TestPipeline_not_started(t *testing.T) {
+	p := newTestPipeline(newLifecycleHandler())
+
+	err := p.Wait()
+	if !errors.Is(err, ErrPipelineNotStarted) {
+		t.Errorf("Wait() error = %v, want %v", err, ErrPipelineNotStarted)
+	}
+
+	err = p.Stop(context.Background())
+	if err != nil {
+		t.Errorf("Stop() error = %v, want nil", err)
+	}
+}
+
+func TestPipeline_Stop_timeout(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	h := newLifecycleHandler()
+	h.stopDelay = 200 * time.Millisecond
+	p := newTestPipeline(h)
+
+	err := p.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = p.Stop(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The pipeline still ends once the handler has drained.
+	err = p.Wait()
+	if err != nil {
+		t.Errorf("Wait() error = %v, want nil", err)
+	}
+}
+
+// stoppableStreamer is a Streamer that records whether it has been stopped.
+type stoppableStreamer struct {
+	stopped bool
+}
+
+func (s *stoppableStreamer) GetContext() context.Context { return context.Background() }
+
+func (s *stoppableStreamer) GetClient() *wsclient.Client { return nil }
+
+func (s *stoppableStreamer) SetLogger(logger *logrus.Logger) {}
+
+func (s *stoppableStreamer) Stream(streamFeeds chan interface{}) error { return nil }
+
+func (s *stoppableStreamer) Stop() { s.stopped = true }
+
+func TestStopStreamer(t *testing.T) {
+	s := &stoppableStreamer{}
+	StopStreamer(s)
+
+	if !s.stopped {
+		t.Errorf("StopStreamer() streamer not stopped")
+	}
+}
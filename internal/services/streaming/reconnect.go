@@ -0,0 +1,122 @@
+package streaming
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	wsclient "bitbucket.org/keynear/coinbase-vwap-calculation/internal/clients/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultReconnectDelay is the default delay before the first reconnect attempt.
+	DefaultReconnectDelay = time.Second
+	// DefaultMaxReconnectDelay is the default upper limit of the exponentially growing reconnect delay.
+	DefaultMaxReconnectDelay = time.Minute
+)
+
+// Reconnector reconnects the websocket client of a streamer with an exponential backoff after the connection drops.
+type Reconnector struct {
+	Delay    time.Duration
+	MaxDelay time.Duration
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+}
+
+func NewReconnector(delay time.Duration, maxDelay time.Duration) *Reconnector {
+	return &Reconnector{
+		Delay:    delay,
+		MaxDelay: maxDelay,
+	}
+}
+
+func (r *Reconnector) stopped() chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopCh == nil {
+		r.stopCh = make(chan struct{})
+	}
+
+	return r.stopCh
+}
+
+// Reconnect keeps reconnecting the client until it succeeds, the reconnector is stopped or the context is done, the
+// delay between the attempts doubles after every failed attempt. Once connected, resubscribe is called to restore the
+// subscriptions. A zero delay, or a nil reconnector, disables reconnecting.
+func (r *Reconnector) Reconnect(
+	ctx context.Context,
+	client *wsclient.Client,
+	resubscribe func() error,
+	logger *logrus.Logger,
+) {
+	if r == nil {
+		return
+	}
+
+	delay := r.Delay
+	if delay <= 0 {
+		return
+	}
+
+	stopCh := r.stopped()
+
+	client.SetReconnecting(true)
+
+	for {
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			client.SetReconnecting(false)
+			return
+		case <-stopCh:
+			timer.Stop()
+			client.SetReconnecting(false)
+			return
+		case <-timer.C:
+		}
+
+		logger.Infof("Reconnecting to %s", client.URL)
+
+		err := client.Connect()
+		if err == nil {
+			select {
+			case <-stopCh:
+				// Stopped while connecting, don't keep the new connection open.
+				client.Close()
+				return
+			default:
+			}
+
+			// When the new connection is broken as well, its reader reports it and reconnects again.
+			err = resubscribe()
+			if err != nil {
+				logger.Errorf("Error resubscribing %s", err)
+			}
+
+			return
+		}
+
+		delay *= 2
+		if r.MaxDelay > 0 && delay > r.MaxDelay {
+			delay = r.MaxDelay
+		}
+	}
+}
+
+// Stop stops the ongoing and the future reconnect attempts.
+func (r *Reconnector) Stop() {
+	if r == nil {
+		return
+	}
+
+	stopCh := r.stopped()
+
+	r.stopOnce.Do(func() {
+		close(stopCh)
+	})
+}
@@ -0,0 +1,284 @@
+package recorder
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPrefix is the default prefix of the recorded file names.
+	DefaultPrefix = "feed"
+	// DefaultMaxBytes is the default compressed size a file is rotated at.
+	DefaultMaxBytes = 64 * 1024 * 1024
+	// DefaultMaxAge is the default age a file is rotated at.
+	DefaultMaxAge = time.Hour
+	// DefaultBufferSize is the default number of messages buffered before the new ones are dropped.
+	DefaultBufferSize = 10000
+	// FileExtension is the extension of the recorded files.
+	FileExtension = ".jsonl.gz"
+)
+
+// ErrClosed is returned when the recorder is closed twice.
+var ErrClosed = errors.New("recorder closed")
+
+// Envelope is a recorded message with its local receive time.
+type Envelope struct {
+	Received time.Time       `json:"received"`
+	Message  json.RawMessage `json:"message"`
+}
+
+// Config is the configuration of a recorder.
+type Config struct {
+	// Dir is the directory the files are written to.
+	Dir string
+	// Prefix is the prefix of the file names.
+	Prefix string
+	// MaxBytes is the compressed size a file is rotated at, 0 disables the size rotation. The size is approximate,
+	// the compressor buffers the most recent messages.
+	MaxBytes int64
+	// MaxAge is the age a file is rotated at, 0 disables the time rotation.
+	MaxAge time.Duration
+	// BufferSize is the number of messages buffered before the new ones are dropped.
+	BufferSize int
+}
+
+func NewConfig(dir string) Config {
+	return Config{
+		Dir:        dir,
+		Prefix:     DefaultPrefix,
+		MaxBytes:   DefaultMaxBytes,
+		MaxAge:     DefaultMaxAge,
+		BufferSize: DefaultBufferSize,
+	}
+}
+
+// Recorder writes the raw messages with their local receive time to the gzip compressed JSON lines files, rotated by
+// size or age. Recording never blocks the caller: the messages are buffered and written by a background goroutine,
+// and when the buffer is full, the new messages are dropped and counted. The files are readable by the replay streamer.
+type Recorder struct {
+	config   Config
+	messages chan Envelope
+	dropped  uint64
+	recorded uint64
+	file     *os.File
+	counter  *countingWriter
+	gzip     *gzip.Writer
+	opened   time.Time
+	sequence int
+	files    []string
+	done     chan struct{}
+	closed   bool
+	closeMu  sync.RWMutex
+	filesMu  sync.Mutex
+	now      func() time.Time
+	logger   *logrus.Logger
+}
+
+func NewRecorder(config Config) (*Recorder, error) {
+	if config.Prefix == "" {
+		config.Prefix = DefaultPrefix
+	}
+
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	err := os.MkdirAll(config.Dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		config:   config,
+		messages: make(chan Envelope, config.BufferSize),
+		done:     make(chan struct{}),
+		now:      time.Now,
+		logger:   logrus.New(),
+	}
+
+	go r.run()
+
+	return r, nil
+}
+
+func (r *Recorder) SetLogger(logger *logrus.Logger) {
+	r.logger = logger
+}
+
+// Record records a raw message with the time it was received at, or now when the time is zero. It drops the message
+// when the buffer is full or the recorder closed.
+func (r *Recorder) Record(message string, receivedAt time.Time) {
+	r.closeMu.RLock()
+	defer r.closeMu.RUnlock()
+
+	if r.closed {
+		atomic.AddUint64(&r.dropped, 1)
+		return
+	}
+
+	raw := json.RawMessage(message)
+	if !json.Valid(raw) {
+		// Keep the malformed messages as JSON strings, so that they're still recorded as received.
+		raw, _ = json.Marshal(message)
+	}
+
+	if receivedAt.IsZero() {
+		receivedAt = r.now()
+	}
+
+	select {
+	case r.messages <- Envelope{Received: receivedAt.UTC(), Message: raw}:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+	}
+}
+
+// Dropped returns the number of messages dropped because the buffer was full.
+func (r *Recorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Recorded returns the number of messages written.
+func (r *Recorder) Recorded() uint64 {
+	return atomic.LoadUint64(&r.recorded)
+}
+
+// Files returns the paths of the files written so far, in the order they were opened.
+func (r *Recorder) Files() []string {
+	r.filesMu.Lock()
+	defer r.filesMu.Unlock()
+
+	return append([]string(nil), r.files...)
+}
+
+// Close writes the buffered messages and closes the current file.
+func (r *Recorder) Close() error {
+	r.closeMu.Lock()
+	if r.closed {
+		r.closeMu.Unlock()
+		return ErrClosed
+	}
+
+	r.closed = true
+	close(r.messages)
+	r.closeMu.Unlock()
+
+	<-r.done
+
+	return r.closeFile()
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	for envelope := range r.messages {
+		err := r.write(envelope)
+		if err != nil {
+			r.logger.Errorf("Error recording message %s", err)
+			atomic.AddUint64(&r.dropped, 1)
+		}
+	}
+}
+
+func (r *Recorder) write(envelope Envelope) error {
+	if r.shouldRotate() {
+		err := r.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.gzip.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&r.recorded, 1)
+
+	return nil
+}
+
+func (r *Recorder) shouldRotate() bool {
+	if r.file == nil {
+		return true
+	}
+
+	if r.config.MaxBytes > 0 && r.counter.written >= r.config.MaxBytes {
+		return true
+	}
+
+	return r.config.MaxAge > 0 && r.now().Sub(r.opened) >= r.config.MaxAge
+}
+
+func (r *Recorder) rotate() error {
+	err := r.closeFile()
+	if err != nil {
+		return err
+	}
+
+	r.opened = r.now().UTC()
+	r.sequence++
+
+	name := fmt.Sprintf("%s-%s-%04d%s", r.config.Prefix, r.opened.Format("20060102T150405Z"), r.sequence, FileExtension)
+	path := filepath.Join(r.config.Dir, name)
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	r.file = file
+	r.counter = &countingWriter{writer: file}
+	r.gzip = gzip.NewWriter(r.counter)
+
+	r.filesMu.Lock()
+	r.files = append(r.files, path)
+	r.filesMu.Unlock()
+
+	r.logger.Infof("Recording messages to %s", path)
+
+	return nil
+}
+
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.gzip.Close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	r.file = nil
+
+	return err
+}
+
+// countingWriter counts the bytes written to the file.
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,194 @@
+//go:build all
+// +build all
+
+package recorder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/goleak"
+)
+
+// readEnvelopes reads back the envelopes of a recorded file.
+func readEnvelopes(t *testing.T, path string) []Envelope {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed %v", err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("NewReader failed %v", err)
+	}
+
+	envelopes := make([]Envelope, 0)
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var envelope Envelope
+		if err := json.Unmarshal(scanner.Bytes(), &envelope); err != nil {
+			t.Fatalf("Unmarshal failed %v", err)
+		}
+
+		envelopes = append(envelopes, envelope)
+	}
+
+	return envelopes
+}
+
+func newTestRecorder(t *testing.T, config Config) *Recorder {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	r, err := NewRecorder(config)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	r.SetLogger(logger)
+
+	return r
+}
+
+func TestRecorder_Record(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	r := newTestRecorder(t, NewConfig(t.TempDir()))
+
+	messages := []string{
+		`{"type":"subscriptions"}`,
+		`{"type":"match","trade_id":1}`,
+		`not json`,
+	}
+
+	receivedAt := time.Date(2022, 6, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	for _, message := range messages {
+		r.Record(message, receivedAt)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if err := r.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Close() error = %v, want %v", err, ErrClosed)
+	}
+
+	files := r.Files()
+	if len(files) != 1 {
+		t.Fatalf("Files() = %v, want 1 file", files)
+	}
+
+	envelopes := readEnvelopes(t, files[0])
+	if len(envelopes) != len(messages) || r.Recorded() != uint64(len(messages)) {
+		t.Fatalf("Record() recorded %d, %d, want %d", len(envelopes), r.Recorded(), len(messages))
+	}
+
+	want := []string{`{"type":"subscriptions"}`, `{"type":"match","trade_id":1}`, `"not json"`}
+	for i, envelope := range envelopes {
+		if string(envelope.Message) != want[i] || !envelope.Received.Equal(receivedAt) {
+			t.Errorf("Record() envelope = %s %v, want %s", envelope.Message, envelope.Received, want[i])
+		}
+	}
+
+	r.Record(`{"type":"match"}`, receivedAt)
+	if r.Dropped() != 1 {
+		t.Errorf("Dropped() = %v, want 1 after Close()", r.Dropped())
+	}
+}
+
+func TestRecorder_rotate(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	tests := []struct {
+		name      string
+		maxBytes  int64
+		maxAge    time.Duration
+		step      time.Duration
+		wantFiles int
+	}{
+		// Add TestRecorder_rotate test cases.
+		{
+			name:      "TestRecorder_rotate by size",
+			maxBytes:  1,
+			wantFiles: 3,
+		},
+		{
+			name:      "TestRecorder_rotate by age",
+			maxAge:    time.Minute,
+			step:      45 * time.Second,
+			wantFiles: 2,
+		},
+		{
+			name:      "TestRecorder_rotate disabled",
+			step:      time.Hour,
+			wantFiles: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig(t.TempDir())
+			config.MaxBytes = tt.maxBytes
+			config.MaxAge = tt.maxAge
+
+			now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+			r := newTestRecorder(t, config)
+			r.now = func() time.Time { return now }
+
+			for i := 0; i < 3; i++ {
+				r.Record(`{"type":"match"}`, time.Time{})
+
+				// Wait for the message to be written before moving the clock.
+				for r.Recorded() < uint64(i+1) {
+					time.Sleep(time.Millisecond)
+				}
+
+				now = now.Add(tt.step)
+			}
+
+			if err := r.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			files := r.Files()
+			if len(files) != tt.wantFiles {
+				t.Errorf("Files() = %v, want %d files", files, tt.wantFiles)
+			}
+
+			total := 0
+			for _, file := range files {
+				total += len(readEnvelopes(t, file))
+			}
+
+			if total != 3 {
+				t.Errorf("Files() recorded %d messages, want 3", total)
+			}
+		})
+	}
+}
+
+func TestRecorder_Record_full(t *testing.T) {
+	// A recorder without its writer goroutine, so that the buffer fills up.
+	r := &Recorder{messages: make(chan Envelope, 1), now: time.Now}
+
+	r.Record(`{"type":"match"}`, time.Now())
+	r.Record(`{"type":"match"}`, time.Now())
+
+	if r.Dropped() != 1 {
+		t.Errorf("Dropped() = %v, want 1", r.Dropped())
+	}
+}
@@ -0,0 +1,277 @@
+package conversion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+)
+
+const (
+	SourceLive   = "live"
+	SourceStatic = "static"
+)
+
+var (
+	// ErrNoConversionPath is returned when there's no live or static rate path from a currency to the reporting one.
+	ErrNoConversionPath = errors.New("no conversion path")
+	// ErrInvalidRate is returned for the static rates that are missing, zero or negative, which can't be converted by.
+	ErrInvalidRate = errors.New("invalid rate")
+)
+
+// Step is a leg of a conversion path, the rate of an instrument and whether it's used inverted.
+type Step struct {
+	Instrument instrument.Instrument
+	Rate       *big.Float
+	Inverted   bool
+	Source     string
+}
+
+func (s Step) String() string {
+	name := s.Instrument.String()
+	if s.Inverted {
+		name = "1/" + name
+	}
+
+	return name + "(" + s.Source + ")"
+}
+
+// Path is the chain of rates converting an amount into the reporting currency.
+type Path []Step
+
+func (p Path) String() string {
+	if len(p) == 0 {
+		return "identity"
+	}
+
+	steps := make([]string, 0, len(p))
+	for _, step := range p {
+		steps = append(steps, step.String())
+	}
+
+	return strings.Join(steps, " x ")
+}
+
+// Converted is a window's VWAP and notional volume expressed in the reporting currency.
+type Converted struct {
+	Instrument string
+	Currency   string
+	VWAP       *big.Float
+	Notional   *big.Float
+	Path       Path
+}
+
+// Converter converts the amounts of a quote currency into the reporting currency, by the live VWAPs of the conversion
+// pairs first, and by the static FX table otherwise. The conversion may go through one intermediate currency, e.g.
+// BTC to EUR through BTC-USD and EUR-USD.
+type Converter struct {
+	currency string
+	live     map[instrument.Instrument]*big.Float
+	static   map[instrument.Instrument]*big.Float
+	mu       sync.RWMutex
+}
+
+func NewConverter(currency string) *Converter {
+	return &Converter{
+		currency: strings.ToUpper(currency),
+		live:     make(map[instrument.Instrument]*big.Float),
+		static:   make(map[instrument.Instrument]*big.Float),
+	}
+}
+
+// Currency returns the reporting currency.
+func (c *Converter) Currency() string {
+	return c.currency
+}
+
+// SetStaticRate sets the static FX rate of an instrument, it fails with ErrInvalidRate unless the rate is positive.
+func (c *Converter) SetStaticRate(inst instrument.Instrument, rate *big.Float) error {
+	if rate == nil || rate.Sign() <= 0 || rate.IsInf() {
+		return fmt.Errorf("%w: %v of %s", ErrInvalidRate, rate, inst)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.static[inst] = new(big.Float).Set(rate)
+
+	return nil
+}
+
+// LoadStaticRates loads the static FX table from a JSON file of the rates keyed by the instrument, e.g.
+// {"EUR-USD": 1.08}. It fails with ErrInvalidRate on a rate that isn't positive.
+func (c *Converter) LoadStaticRates(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	rates := make(map[string]json.Number)
+
+	err = json.Unmarshal(data, &rates)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for name, value := range rates {
+		inst, err := instrument.Parse(name)
+		if err != nil {
+			return err
+		}
+
+		rate, _, err := big.ParseFloat(value.String(), 10, 0, big.ToNearestEven)
+		if err != nil {
+			return fmt.Errorf("rate of %s: %w", name, err)
+		}
+
+		err = c.SetStaticRate(inst, rate)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateLive sets the live VWAP of an instrument.
+func (c *Converter) UpdateLive(inst instrument.Instrument, price *big.Float) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if price == nil || price.Sign() <= 0 {
+		delete(c.live, inst)
+		return
+	}
+
+	c.live[inst] = new(big.Float).Set(price)
+}
+
+// Convert converts an amount of a currency into the reporting currency.
+func (c *Converter) Convert(amount *big.Float, currency string) (*big.Float, Path, error) {
+	path, err := c.Path(currency)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	converted := new(big.Float).Set(amount)
+
+	for _, step := range path {
+		if step.Inverted {
+			converted.Quo(converted, step.Rate)
+		} else {
+			converted.Mul(converted, step.Rate)
+		}
+	}
+
+	return converted, path, nil
+}
+
+// ConvertWindow converts the VWAP and the notional volume of a window into the reporting currency.
+func (c *Converter) ConvertWindow(window *vwap.SlidingWindow) (Converted, error) {
+	inst, err := instrument.Parse(window.CurrencyPair())
+	if err != nil {
+		return Converted{}, err
+	}
+
+	calculator := window.GetCalculator()
+
+	price, path, err := c.Convert(calculator.Avg(), inst.Quote)
+	if err != nil {
+		return Converted{}, fmt.Errorf("%s: %w", inst, err)
+	}
+
+	notional, _, err := c.Convert(calculator.ValueSum, inst.Quote)
+	if err != nil {
+		return Converted{}, fmt.Errorf("%s: %w", inst, err)
+	}
+
+	return Converted{
+		Instrument: inst.String(),
+		Currency:   c.currency,
+		VWAP:       price,
+		Notional:   notional,
+		Path:       path,
+	}, nil
+}
+
+// Path returns the conversion path from a currency into the reporting currency, the direct paths are preferred to
+// the ones through an intermediate currency.
+func (c *Converter) Path(currency string) (Path, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	currency = strings.ToUpper(currency)
+	if currency == c.currency {
+		return Path{}, nil
+	}
+
+	if step, ok := c.step(currency, c.currency); ok {
+		return Path{step}, nil
+	}
+
+	for _, intermediate := range c.currencies() {
+		if intermediate == currency || intermediate == c.currency {
+			continue
+		}
+
+		first, ok := c.step(currency, intermediate)
+		if !ok {
+			continue
+		}
+
+		second, ok := c.step(intermediate, c.currency)
+		if ok {
+			return Path{first, second}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w from %s to %s", ErrNoConversionPath, currency, c.currency)
+}
+
+// step returns the rate converting from a currency into another, live rates first.
+func (c *Converter) step(from string, to string) (Step, bool) {
+	direct := instrument.Instrument{Base: from, Quote: to}
+
+	for _, table := range []struct {
+		rates  map[instrument.Instrument]*big.Float
+		source string
+	}{{c.live, SourceLive}, {c.static, SourceStatic}} {
+		if rate, ok := table.rates[direct]; ok {
+			return Step{Instrument: direct, Rate: rate, Source: table.source}, true
+		}
+
+		if rate, ok := table.rates[direct.Inverse()]; ok {
+			return Step{Instrument: direct.Inverse(), Rate: rate, Inverted: true, Source: table.source}, true
+		}
+	}
+
+	return Step{}, false
+}
+
+// currencies returns the sorted currencies of all the known rates.
+func (c *Converter) currencies() []string {
+	seen := make(map[string]bool)
+
+	for _, rates := range []map[instrument.Instrument]*big.Float{c.live, c.static} {
+		for inst := range rates {
+			seen[inst.Base] = true
+			seen[inst.Quote] = true
+		}
+	}
+
+	currencies := make([]string, 0, len(seen))
+	for currency := range seen {
+		currencies = append(currencies, currency)
+	}
+
+	sort.Strings(currencies)
+
+	return currencies
+}
@@ -0,0 +1,165 @@
+//go:build all
+// +build all
+
+package conversion
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap"
+)
+
+const testFXRatesFile = "../../../tests/data/fx_rates.json"
+
+func TestConverter_Convert(t *testing.T) {
+	type args struct {
+		amount   float64
+		currency string
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		args     args
+		want     float64
+		wantPath string
+		wantErr  error
+	}{
+		// Add TestConverter_Convert test cases.
+		{
+			name:     "TestConverter_Convert identity",
+			target:   "USD",
+			args:     args{amount: 10, currency: "USD"},
+			want:     10,
+			wantPath: "identity",
+		},
+		{
+			name:     "TestConverter_Convert live",
+			target:   "USD",
+			args:     args{amount: 0.05, currency: "BTC"},
+			want:     1000,
+			wantPath: "BTC-USD(live)",
+		},
+		{
+			name:     "TestConverter_Convert static inverted",
+			target:   "EUR",
+			args:     args{amount: 108, currency: "USD"},
+			want:     100,
+			wantPath: "1/EUR-USD(static)",
+		},
+		{
+			name:     "TestConverter_Convert through intermediate",
+			target:   "EUR",
+			args:     args{amount: 0.054, currency: "BTC"},
+			want:     1000,
+			wantPath: "BTC-USD(live) x 1/EUR-USD(static)",
+		},
+		{
+			name:    "TestConverter_Convert no path",
+			target:  "USD",
+			args:    args{amount: 1, currency: "GBP"},
+			wantErr: ErrNoConversionPath,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConverter(tt.target)
+			if err := c.LoadStaticRates(testFXRatesFile); err != nil {
+				t.Fatalf("LoadStaticRates() error = %v", err)
+			}
+
+			c.UpdateLive(instrument.Instrument{Base: "BTC", Quote: "USD"}, big.NewFloat(20000))
+
+			got, path, err := c.Convert(big.NewFloat(tt.args.amount), tt.args.currency)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			value, _ := got.Float64()
+			if math.Abs(value-tt.want) > 1e-9 {
+				t.Errorf("Convert() = %v, want %v", value, tt.want)
+			}
+
+			if path.String() != tt.wantPath {
+				t.Errorf("Convert() path = %v, want %v", path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestConverter_ConvertWindow(t *testing.T) {
+	c := NewConverter("USD")
+	c.UpdateLive(instrument.Instrument{Base: "BTC", Quote: "USD"}, big.NewFloat(20000))
+
+	window := vwap.NewSlidingWindow(5, "ETH-BTC")
+	window.Add(vwap.DataPoint{Price: big.NewFloat(0.05), Size: big.NewFloat(2), ProductID: "ETH-BTC"})
+	window.Add(vwap.DataPoint{Price: big.NewFloat(0.06), Size: big.NewFloat(2), ProductID: "ETH-BTC"})
+
+	got, err := c.ConvertWindow(window)
+	if err != nil {
+		t.Fatalf("ConvertWindow() error = %v", err)
+	}
+
+	price, _ := got.VWAP.Float64()
+	notional, _ := got.Notional.Float64()
+
+	if math.Abs(price-1100) > 1e-6 || math.Abs(notional-4400) > 1e-6 {
+		t.Errorf("ConvertWindow() = %v, %v, want 1100, 4400", price, notional)
+	}
+
+	if got.Path.String() != "BTC-USD(live)" {
+		t.Errorf("ConvertWindow() path = %v", got.Path)
+	}
+}
+
+func TestConverter_SetStaticRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    *big.Float
+		wantErr error
+	}{
+		// Add TestConverter_SetStaticRate test cases.
+		{name: "TestConverter_SetStaticRate positive", rate: big.NewFloat(1.08)},
+		{name: "TestConverter_SetStaticRate zero", rate: big.NewFloat(0), wantErr: ErrInvalidRate},
+		{name: "TestConverter_SetStaticRate negative", rate: big.NewFloat(-1.08), wantErr: ErrInvalidRate},
+		{name: "TestConverter_SetStaticRate missing", rate: nil, wantErr: ErrInvalidRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConverter("EUR")
+
+			err := c.SetStaticRate(instrument.Instrument{Base: "EUR", Quote: "USD"}, tt.rate)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetStaticRate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// A rejected rate isn't converted by.
+			_, _, err = c.Convert(big.NewFloat(0), "USD")
+			if tt.wantErr != nil && !errors.Is(err, ErrNoConversionPath) {
+				t.Errorf("Convert() error = %v, wantErr %v", err, ErrNoConversionPath)
+			}
+		})
+	}
+}
+
+func TestConverter_LoadStaticRates_invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fx_rates.json")
+	if err := os.WriteFile(path, []byte(`{"EUR-USD": 1.08, "GBP-USD": 0}`), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	err := NewConverter("EUR").LoadStaticRates(path)
+	if !errors.Is(err, ErrInvalidRate) {
+		t.Errorf("LoadStaticRates() error = %v, wantErr %v", err, ErrInvalidRate)
+	}
+}
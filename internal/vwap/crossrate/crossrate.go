@@ -0,0 +1,178 @@
+package crossrate
+
+import (
+	"math/big"
+	"sort"
+	"sync"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+)
+
+// BasisPoints is the number of basis points in one.
+const BasisPoints = 10000
+
+// Triangle is a directly traded instrument and the asset its implied rate is derived through, e.g. ETH-BTC via USD
+// is implied from ETH-USD and BTC-USD.
+type Triangle struct {
+	Direct instrument.Instrument
+	Via    string
+}
+
+// Legs returns the base and the quote legs of the triangle, as their canonical names with the asset the triangle is
+// derived through as the quote, e.g. ETH-USD and BTC-USD. A leg may be traded inverted, e.g. USD-ETH.
+func (t Triangle) Legs() (instrument.Instrument, instrument.Instrument) {
+	baseLeg := instrument.Instrument{Base: t.Direct.Base, Quote: t.Via}
+	quoteLeg := instrument.Instrument{Base: t.Direct.Quote, Quote: t.Via}
+
+	return baseLeg, quoteLeg
+}
+
+func (t Triangle) String() string {
+	return t.Direct.String() + "/" + t.Via
+}
+
+// Deviation is the deviation of the implied rate of a triangle from the directly traded VWAP.
+type Deviation struct {
+	Triangle    Triangle
+	Direct      *big.Float
+	Implied     *big.Float
+	BasisPoints float64
+}
+
+// Engine derives the synthetic cross rates of the instruments from the VWAPs of the other instruments sharing an
+// asset, and compares them with the directly traded VWAPs. Any triangle that can be built from the updated instruments,
+// traded either way round, is compared.
+type Engine struct {
+	prices map[instrument.Instrument]*big.Float
+	mu     sync.Mutex
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		prices: make(map[instrument.Instrument]*big.Float),
+	}
+}
+
+// Update sets the VWAP of an instrument and returns the deviations of its implied rates, one per asset its base and
+// quote are both traded against. Every triangle the instrument is part of is emitted once per update, keyed by the
+// updated instrument as the implied pair, rather than once per leg.
+func (e *Engine) Update(inst instrument.Instrument, price *big.Float) []Deviation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if price == nil || price.Sign() <= 0 {
+		delete(e.prices, inst)
+		return nil
+	}
+
+	e.prices[inst] = new(big.Float).Set(price)
+
+	deviations := make([]Deviation, 0)
+
+	for _, triangle := range e.triangles() {
+		if triangle.Direct != inst {
+			continue
+		}
+
+		if deviation, ok := e.deviation(triangle); ok {
+			deviations = append(deviations, deviation)
+		}
+	}
+
+	return deviations
+}
+
+// Deviations returns the deviations of all the triangles that can be built from the updated instruments.
+func (e *Engine) Deviations() []Deviation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	deviations := make([]Deviation, 0)
+
+	for _, triangle := range e.triangles() {
+		if deviation, ok := e.deviation(triangle); ok {
+			deviations = append(deviations, deviation)
+		}
+	}
+
+	return deviations
+}
+
+// Triangles returns the triangles that can be built from the updated instruments.
+func (e *Engine) Triangles() []Triangle {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.triangles()
+}
+
+// triangles enumerates, for each direct instrument, the assets that both its base and quote are traded against.
+func (e *Engine) triangles() []Triangle {
+	counterparts := make(map[string]map[string]bool)
+
+	for inst := range e.prices {
+		for _, pair := range [][2]string{{inst.Base, inst.Quote}, {inst.Quote, inst.Base}} {
+			if counterparts[pair[0]] == nil {
+				counterparts[pair[0]] = make(map[string]bool)
+			}
+
+			counterparts[pair[0]][pair[1]] = true
+		}
+	}
+
+	triangles := make([]Triangle, 0)
+
+	for inst := range e.prices {
+		for via := range counterparts[inst.Base] {
+			if via != inst.Quote && counterparts[inst.Quote][via] {
+				triangles = append(triangles, Triangle{Direct: inst, Via: via})
+			}
+		}
+	}
+
+	sort.Slice(triangles, func(i, j int) bool {
+		return triangles[i].String() < triangles[j].String()
+	})
+
+	return triangles
+}
+
+func (e *Engine) deviation(triangle Triangle) (Deviation, bool) {
+	baseLeg, quoteLeg := triangle.Legs()
+
+	baseRate, ok := e.rate(baseLeg)
+	if !ok {
+		return Deviation{}, false
+	}
+
+	quoteRate, ok := e.rate(quoteLeg)
+	if !ok || quoteRate.Sign() == 0 {
+		return Deviation{}, false
+	}
+
+	direct := e.prices[triangle.Direct]
+	implied := new(big.Float).Quo(baseRate, quoteRate)
+
+	difference := new(big.Float).Sub(implied, direct)
+	bps, _ := difference.Quo(difference, direct).Float64()
+
+	return Deviation{
+		Triangle:    triangle,
+		Direct:      new(big.Float).Set(direct),
+		Implied:     implied,
+		BasisPoints: bps * BasisPoints,
+	}, true
+}
+
+// rate returns the price of an instrument, directly or as the inverse of the instrument traded the other way round.
+func (e *Engine) rate(inst instrument.Instrument) (*big.Float, bool) {
+	if price, ok := e.prices[inst]; ok {
+		return price, true
+	}
+
+	if price, ok := e.prices[inst.Inverse()]; ok {
+		return new(big.Float).Quo(big.NewFloat(1), price), true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,158 @@
+//go:build all
+// +build all
+
+package crossrate
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/instrument"
+)
+
+var (
+	btcUSD = instrument.Instrument{Base: "BTC", Quote: "USD"}
+	ethUSD = instrument.Instrument{Base: "ETH", Quote: "USD"}
+	ethBTC = instrument.Instrument{Base: "ETH", Quote: "BTC"}
+	btcETH = instrument.Instrument{Base: "BTC", Quote: "ETH"}
+)
+
+func TestEngine_Update(t *testing.T) {
+	type update struct {
+		inst  instrument.Instrument
+		price float64
+	}
+
+	tests := []struct {
+		name    string
+		updates []update
+		want    map[string]float64
+	}{
+		// Add TestEngine_Update test cases.
+		{
+			name:    "TestEngine_Update incomplete triangle",
+			updates: []update{{btcUSD, 20000}, {ethUSD, 1000}},
+			want:    map[string]float64{},
+		},
+		{
+			name:    "TestEngine_Update implied ETH-BTC",
+			updates: []update{{btcUSD, 20000}, {ethUSD, 1000}, {ethBTC, 0.049}},
+			want: map[string]float64{
+				"ETH-BTC/USD": 204.08163265306123,
+			},
+		},
+		{
+			name:    "TestEngine_Update updated leg",
+			updates: []update{{btcUSD, 20000}, {ethBTC, 0.05}, {ethUSD, 1010}},
+			want: map[string]float64{
+				"ETH-USD/BTC": -99.00990099009901,
+			},
+		},
+		{
+			name:    "TestEngine_Update inverted leg",
+			updates: []update{{btcUSD, 20000}, {ethUSD, 1000}, {btcETH, 20}},
+			want: map[string]float64{
+				"BTC-ETH/USD": 0,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+
+			var deviations []Deviation
+			for _, u := range tt.updates {
+				deviations = e.Update(u.inst, big.NewFloat(u.price))
+			}
+
+			got := make(map[string]float64)
+			for _, deviation := range deviations {
+				got[deviation.Triangle.String()] = deviation.BasisPoints
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("Update() = %v, want %v", got, tt.want)
+			}
+
+			for triangle, bps := range tt.want {
+				if math.Abs(got[triangle]-bps) > 1e-6 {
+					t.Errorf("Update() %s = %v bps, want %v", triangle, got[triangle], bps)
+				}
+			}
+		})
+	}
+}
+
+func TestEngine_Update_emissions(t *testing.T) {
+	btcEUR := instrument.Instrument{Base: "BTC", Quote: "EUR"}
+	ethEUR := instrument.Instrument{Base: "ETH", Quote: "EUR"}
+	eurUSD := instrument.Instrument{Base: "EUR", Quote: "USD"}
+
+	tests := []struct {
+		name    string
+		traded  []instrument.Instrument
+		updated instrument.Instrument
+		want    []string
+	}{
+		// Add TestEngine_Update_emissions test cases.
+		{
+			name:    "TestEngine_Update_emissions one triangle",
+			traded:  []instrument.Instrument{btcUSD, ethUSD, ethBTC},
+			updated: btcUSD,
+			want:    []string{"BTC-USD/ETH"},
+		},
+		{
+			name:    "TestEngine_Update_emissions two triangles",
+			traded:  []instrument.Instrument{btcUSD, ethUSD, ethBTC, btcEUR, ethEUR, eurUSD},
+			updated: ethBTC,
+			want:    []string{"ETH-BTC/EUR", "ETH-BTC/USD"},
+		},
+		{
+			name:    "TestEngine_Update_emissions no triangle",
+			traded:  []instrument.Instrument{btcUSD, ethUSD, ethBTC},
+			updated: btcEUR,
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			for _, inst := range tt.traded {
+				e.Update(inst, big.NewFloat(2))
+			}
+
+			got := make([]string, 0)
+			for _, deviation := range e.Update(tt.updated, big.NewFloat(3)) {
+				got = append(got, deviation.Triangle.String())
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Update() = %v, want each implied rate of %v once", got, tt.updated)
+			}
+		})
+	}
+}
+
+func TestEngine_Triangles(t *testing.T) {
+	e := NewEngine()
+	e.Update(btcUSD, big.NewFloat(20000))
+	e.Update(ethUSD, big.NewFloat(1000))
+	e.Update(ethBTC, big.NewFloat(0.05))
+	e.Update(instrument.Instrument{Base: "SOL", Quote: "USD"}, big.NewFloat(30))
+
+	got := make([]string, 0)
+	for _, triangle := range e.Triangles() {
+		got = append(got, triangle.String())
+	}
+
+	want := []string{"BTC-USD/ETH", "ETH-BTC/USD", "ETH-USD/BTC"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Triangles() = %v, want %v", got, want)
+	}
+
+	if deviations := e.Deviations(); len(deviations) != 3 {
+		t.Errorf("Deviations() = %v, want 3", len(deviations))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bitbucket.org/keynear/coinbase-vwap-calculation/internal/vwap/utils"
 	"math/big"
 	"sync"
+	"time"
 )
 
 var mux sync.Mutex
@@ -17,11 +18,17 @@ type SlidingWindow struct {
 	calculator   utils.VolumeWeightedAveragePriceCalculator
 }
 
+// DataPoint is a trade of the sliding window. Time is the time of the trade on the exchange and ReceivedAt the time
+// its message was received at, either is zero when unknown.
 type DataPoint struct {
-	Type      string
-	Size      *big.Float
-	Price     *big.Float
-	ProductID string
+	Type       string
+	TradeID    int
+	Size       *big.Float
+	Price      *big.Float
+	ProductID  string
+	Venue      string
+	Time       time.Time
+	ReceivedAt time.Time
 }
 
 func NewSlidingWindow(maxSize int, currencyPair string) *SlidingWindow {
@@ -33,6 +40,11 @@ func NewSlidingWindow(maxSize int, currencyPair string) *SlidingWindow {
 	}
 }
 
+// CurrencyPair returns the pair the sliding window was created for.
+func (sw *SlidingWindow) CurrencyPair() string {
+	return sw.currencyPair
+}
+
 func (sw *SlidingWindow) SetSize(maxSize int) {
 	sw.windowSize = maxSize
 }